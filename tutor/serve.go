@@ -2,12 +2,14 @@ package tutor
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -40,9 +42,39 @@ func (a *app) withDeadline(next http.Handler) http.Handler {
 	return http.TimeoutHandler(next, a.timeout, "Timeout\n")
 }
 
+// wantsJSON is true if the client asked for JSON; anyone
+// else (including curl, which sends */*) gets plain text
+func wantsJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+// reply writes v as JSON if the client wants that, or else
+// the text (in the style of fmt.Println)
+func reply(w http.ResponseWriter, r *http.Request, v interface{}, text ...interface{}) {
+	if !wantsJSON(r) {
+		fmt.Fprintln(w, text...)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("reply:", err)
+	}
+}
+
+type message struct {
+	Message string `json:"message"`
+}
+
+type entry struct {
+	Item string `json:"item"`
+	Key  string `json:"key"`
+}
+
 func slow(w http.ResponseWriter, r *http.Request) {
 	time.Sleep(6 * time.Second)
-	fmt.Fprintln(w, "slow response")
+	reply(w, r, message{"slow response"}, "slow response")
 }
 
 func list(w http.ResponseWriter, r *http.Request) {
@@ -50,15 +82,21 @@ func list(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	item := vars["item"]
 
+	// GET /db has no item, so we explain how to ask for one
+
 	if item == "" {
-		http.Error(w, "No item", http.StatusNotFound)
+		const hint = "No item; try GET /db/{item}?key=..."
+
+		reply(w, r, message{hint}, hint)
 		return
 	}
 
+	e := entry{Item: item, Key: key}
+
 	if key != "" {
-		fmt.Fprintln(w, "the item is", item, "and the key is", key)
+		reply(w, r, e, "the item is", item, "and the key is", key)
 	} else {
-		fmt.Fprintln(w, "the item is", item, "(no key)")
+		reply(w, r, e, "the item is", item, "(no key)")
 	}
 }
 
@@ -71,7 +109,7 @@ func enter(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	fmt.Fprintln(w, item, "has been posted")
+	reply(w, r, message{item + " has been posted"}, item, "has been posted")
 }
 
 type app struct {
@@ -110,20 +148,25 @@ func (a *app) serve() int {
 	return 0
 }
 
-func (a *app) runServer() int {
+func (a *app) makeRouter() *mux.Router {
 	router := mux.NewRouter()
 
 	router.Use(logRequest)
 	router.Use(basicAuth)
 	router.Use(a.withDeadline)
 
+	router.HandleFunc("/db", list).Methods("GET")
 	router.HandleFunc("/db/{item}", list).Methods("GET")
 	router.HandleFunc("/db/{item}", enter).Methods("POST")
 	router.HandleFunc("/slow", slow).Methods("GET")
 
+	return router
+}
+
+func (a *app) runServer() int {
 	a.server = &http.Server{
 		Addr:    a.addr,
-		Handler: router,
+		Handler: a.makeRouter(),
 
 		ReadTimeout:       10 * time.Second,
 		WriteTimeout:      10 * time.Second,
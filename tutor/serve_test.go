@@ -1,19 +1,27 @@
 package tutor
 
 import (
+	"encoding/json"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestServer(t *testing.T) {
-	args := []string{"-addr", "localhost:8089"}
+	var a app
 
-	go runApp(args)
+	if err := a.fromArgs(nil); err != nil {
+		t.Fatal(err)
+	}
 
-	client := http.Client{}
-	req, _ := http.NewRequest("GET", "http://localhost:8089/db/xyz", nil)
+	s := httptest.NewServer(a.makeRouter())
+	defer s.Close()
+
+	client := s.Client()
+	req, _ := http.NewRequest("GET", s.URL+"/db/xyz", nil)
 
 	req.SetBasicAuth("admin", "secret")
 
@@ -36,4 +44,54 @@ func TestServer(t *testing.T) {
 	if result != "the item is xyz (no key)" {
 		t.Errorf("invalid response: %v", body)
 	}
+
+	// and again, asking for JSON
+
+	req.Header.Set("Accept", "application/json")
+
+	resp, err = client.Do(req)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("invalid content type: %s", ct)
+	}
+
+	var e entry
+
+	if err = json.NewDecoder(resp.Body).Decode(&e); err != nil {
+		t.Fatal(err)
+	}
+
+	if e.Item != "xyz" || e.Key != "" {
+		t.Errorf("invalid response: %#v", e)
+	}
+}
+
+// TestNoItem checks that GET /db (with no item) explains
+// itself rather than returning 404
+func TestNoItem(t *testing.T) {
+	a := app{timeout: time.Second}
+	router := a.makeRouter()
+
+	r := httptest.NewRequest("GET", "http://who-cares/db", nil)
+	w := httptest.NewRecorder()
+
+	r.SetBasicAuth("admin", "secret")
+	router.ServeHTTP(w, r)
+
+	resp := w.Result()
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("invalid response: %d", resp.StatusCode)
+	}
+
+	if !strings.HasPrefix(string(body), "No item") {
+		t.Errorf("invalid body: %q", body)
+	}
 }
@@ -24,6 +24,8 @@ type app struct {
 	util    string
 	noAuth  bool
 	debug   bool
+
+	putUpsert bool
 }
 
 func (a *app) serve() int {
@@ -83,6 +85,9 @@ func (a *app) addRoutes() {
 		a.router.Use(basicAuth)
 	}
 
+	a.router.HandleFunc("/", a.index).Methods("GET")
+	a.router.HandleFunc("/favicon.ico", favicon).Methods("GET")
+
 	a.router.HandleFunc("/items", a.list).Methods("GET")
 	a.router.HandleFunc("/items", a.add).Methods("POST")
 
@@ -105,6 +110,7 @@ func (a *app) fromArgs(args []string) error {
 
 	fl.BoolVar(&a.debug, "debug", false, "enable debugging")
 	fl.BoolVar(&a.noAuth, "no-auth", false, "disable auth")
+	fl.BoolVar(&a.putUpsert, "put-upsert", false, "let PUT create a missing item")
 
 	if err := fl.Parse(args); err != nil {
 		return err
@@ -113,27 +119,48 @@ func (a *app) fromArgs(args []string) error {
 	return nil
 }
 
-func (a *app) listRoutes() {
-	visit := func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
-		t, err := route.GetPathTemplate()
+type route struct {
+	Path    string   `json:"path"`
+	Methods []string `json:"methods"`
+}
+
+func (a *app) routes() ([]route, error) {
+	var routes []route
+
+	visit := func(r *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		t, err := r.GetPathTemplate()
 
 		if err != nil {
 			return err
 		}
 
-		m, err := route.GetMethods()
+		m, err := r.GetMethods()
 
 		if err != nil {
 			return err
 		}
 
-		log.Println("route", t, m)
+		routes = append(routes, route{t, m})
 		return nil
 	}
 
 	if err := a.router.Walk(visit); err != nil {
+		return nil, err
+	}
+
+	return routes, nil
+}
+
+func (a *app) listRoutes() {
+	routes, err := a.routes()
+
+	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 	}
+
+	for _, r := range routes {
+		log.Println("route", r.Path, r.Methods)
+	}
 }
 
 func RunApp(args []string) int {
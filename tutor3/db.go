@@ -20,6 +20,7 @@ type DB interface {
 	ListItems(context.Context) ([]*Item, error)
 	ListSKUs(context.Context) (map[string]string, error)
 	UpdateItem(context.Context, *Item) error
+	PutItem(context.Context, *Item) (bool, error)
 	DeleteItem(context.Context, string) error
 }
 
@@ -291,6 +292,59 @@ func (c *Client) UpdateItem(ctx context.Context, i *Item) error {
 	return nil
 }
 
+// PutItem updates the item, or creates it with its own ID
+// (and a new SKU) if it doesn't exist; it returns true if
+// the item was created
+func (c *Client) PutItem(ctx context.Context, i *Item) (bool, error) {
+	ref := c.data.Doc(i.ID)
+	seqRef := c.util.Doc(skuDoc)
+
+	var created bool
+
+	err := c.fs.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		created = false
+
+		// all the reads must come before any writes
+
+		if _, err := tx.Get(ref); err != nil {
+			if status.Code(err) != codes.NotFound {
+				return err
+			}
+
+			created = true
+		}
+
+		if !created {
+			return tx.Set(ref, i)
+		}
+
+		next, err := getNext(seqRef, tx)
+
+		if err != nil {
+			return err
+		}
+
+		i.SKU = next
+
+		update := []firestore.Update{{
+			Path:  nextField,
+			Value: next + 1,
+		}}
+
+		if err := tx.Update(seqRef, update); err != nil {
+			return err
+		}
+
+		return tx.Create(ref, i)
+	})
+
+	if err != nil {
+		return false, err
+	}
+
+	return created, nil
+}
+
 func (c *Client) DeleteItem(ctx context.Context, id string) error {
 	_, err := c.data.Doc(id).Delete(ctx)
 
@@ -112,11 +112,37 @@ func (m *mockDB) UpdateItem(_ context.Context, i *Item) error {
 		return errInvalid
 	}
 
+	if _, ok := m.data[i.ID]; !ok {
+		return errNotFound
+	}
+
 	m.data[i.ID] = i
 
 	return nil
 }
 
+func (m *mockDB) PutItem(_ context.Context, i *Item) (bool, error) {
+	if m.fail {
+		return false, errShouldFail
+	}
+
+	if m.data == nil {
+		return false, errInvalid
+	}
+
+	if _, ok := m.data[i.ID]; ok {
+		m.data[i.ID] = i
+		return false, nil
+	}
+
+	i.SKU = m.next
+	m.data[i.ID] = i
+
+	m.next++
+
+	return true, nil
+}
+
 func (m *mockDB) DeleteItem(_ context.Context, id string) error {
 	if m.fail {
 		return errShouldFail
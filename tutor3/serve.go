@@ -20,8 +20,20 @@ func logRequest(next http.Handler) http.Handler {
 	})
 }
 
+// public paths don't need auth; browsers ask for
+// them on their own without credentials
+var public = map[string]bool{
+	"/":            true,
+	"/favicon.ico": true,
+}
+
 func basicAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if public[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		user, pass, ok := r.BasicAuth()
 
 		if !ok || user != "admin" || pass != "secret" {
@@ -34,6 +46,29 @@ func basicAuth(next http.Handler) http.Handler {
 	})
 }
 
+// index lists our routes, so a browser (or a person)
+// pointed at the root can see what's available
+func (a *app) index(w http.ResponseWriter, r *http.Request) {
+	routes, err := a.routes()
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err = json.NewEncoder(w).Encode(routes); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, err)
+	}
+}
+
+// favicon stops browsers filling the logs with 404s
+func favicon(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func (a *app) list(w http.ResponseWriter, r *http.Request) {
 	items, err := a.db.ListItems(r.Context())
 
@@ -95,6 +130,11 @@ func (a *app) add(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if item.SKU != 0 {
+		http.Error(w, "sku is server-assigned", http.StatusBadRequest)
+		return
+	}
+
 	id, err := a.db.AddItem(r.Context(), &item)
 
 	if err != nil {
@@ -181,6 +221,11 @@ func (a *app) put(w http.ResponseWriter, r *http.Request) {
 
 	item.ID = id // in case it was left out of the object data
 
+	if a.putUpsert {
+		a.upsert(w, r, &item)
+		return
+	}
+
 	if err = a.db.UpdateItem(r.Context(), &item); err != nil {
 		if errors.Is(err, errNotFound) {
 			http.Error(w, err.Error(), http.StatusNotFound)
@@ -192,6 +237,27 @@ func (a *app) put(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// upsert is PUT as strict REST has it: a missing item is
+// created with the ID in the URL (we still assign the SKU)
+func (a *app) upsert(w http.ResponseWriter, r *http.Request, item *Item) {
+	created, err := a.db.PutItem(r.Context(), item)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !created {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", fmt.Sprintf("http://%s%s", r.Host, r.URL.Path))
+	w.WriteHeader(http.StatusCreated)
+
+	_ = json.NewEncoder(w).Encode(item)
+}
+
 func (a *app) drop(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
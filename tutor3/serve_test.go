@@ -179,3 +179,140 @@ func TestNotFoundWithMocks(t *testing.T) {
 		t.Errorf("invalid response: %d", resp.StatusCode)
 	}
 }
+
+// TestIndexWithMocks needs no auth to see the routes
+func TestIndexWithMocks(t *testing.T) {
+	d := new(mockDB)
+	a := app{
+		router: mux.NewRouter(),
+		db:     d,
+	}
+
+	d.preload()
+	a.addRoutes()
+
+	r := httptest.NewRequest("GET", "http://who-cares/", nil)
+	w := httptest.NewRecorder()
+
+	a.router.ServeHTTP(w, r)
+
+	resp := w.Result()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("invalid response: %d", resp.StatusCode)
+	}
+
+	var result []route
+
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+
+	found := false
+
+	for _, r := range result {
+		if r.Path == "/items" {
+			found = true
+		}
+	}
+
+	if !found {
+		t.Errorf("invalid result: %#v", result)
+	}
+
+	r = httptest.NewRequest("GET", "http://who-cares/favicon.ico", nil)
+	w = httptest.NewRecorder()
+
+	a.router.ServeHTTP(w, r)
+
+	if resp = w.Result(); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("invalid favicon response: %d", resp.StatusCode)
+	}
+}
+
+// TestPutUpsertWithMocks puts an item that doesn't exist,
+// with and without -put-upsert
+func TestPutUpsertWithMocks(t *testing.T) {
+	table := []struct {
+		name   string
+		upsert bool
+		status int
+	}{
+		{"no-upsert", false, http.StatusNotFound},
+		{"upsert", true, http.StatusCreated},
+	}
+
+	for _, st := range table {
+		t.Run(st.name, func(t *testing.T) {
+			d := new(mockDB)
+			a := app{
+				router:    mux.NewRouter(),
+				db:        d,
+				noAuth:    true,
+				putUpsert: st.upsert,
+			}
+
+			d.preload()
+			a.addRoutes()
+
+			body := strings.NewReader(`{"name":"new"}`)
+			r := httptest.NewRequest("PUT", "http://who-cares/items/abc", body)
+			w := httptest.NewRecorder()
+
+			a.router.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if resp.StatusCode != st.status {
+				t.Fatalf("invalid response: %d", resp.StatusCode)
+			}
+
+			item, ok := d.data["abc"]
+
+			if !st.upsert {
+				if ok {
+					t.Errorf("item was created")
+				}
+
+				return
+			}
+
+			if !ok || item.SKU != 1009 {
+				t.Errorf("invalid item: %#v", item)
+			}
+
+			if loc := resp.Header.Get("Location"); loc != "http://who-cares/items/abc" {
+				t.Errorf("invalid location: %s", loc)
+			}
+		})
+	}
+}
+
+// TestAddSKUWithMocks tries to pick the SKU for a new item
+func TestAddSKUWithMocks(t *testing.T) {
+	d := new(mockDB)
+	a := app{
+		router: mux.NewRouter(),
+		db:     d,
+		noAuth: true,
+	}
+
+	d.preload()
+	a.addRoutes()
+
+	body := strings.NewReader(`{"name":"new","sku":1234}`)
+	r := httptest.NewRequest("POST", "http://who-cares/items", body)
+	w := httptest.NewRecorder()
+
+	a.router.ServeHTTP(w, r)
+
+	resp := w.Result()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("invalid response: %d", resp.StatusCode)
+	}
+
+	if len(d.data) != 9 {
+		t.Errorf("item was added")
+	}
+}
@@ -0,0 +1,48 @@
+package tutor4
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// allowMethods are the methods a path might take; OPTIONS
+// is always allowed
+var allowMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE"}
+
+// allowed is the Allow header for the request's path: the
+// methods some route takes there, as the router matches
+// them, so /items has just GET and POST, not the methods
+// of /items/{id}
+func (a *app) allowed(r *http.Request) string {
+	var result []string
+
+	for _, m := range allowMethods {
+		probe := r.WithContext(r.Context())
+		probe.Method = m
+
+		var match mux.RouteMatch
+
+		if a.router.Match(probe, &match) && match.MatchErr == nil {
+			result = append(result, m)
+		}
+	}
+
+	return strings.Join(append(result, "OPTIONS"), ", ")
+}
+
+// notAllowed answers a request for a path we serve, but not
+// with its method: OPTIONS (other than a CORS preflight,
+// which has a route of its own) gets 204, and anything
+// else 405, both with Allow
+func (a *app) notAllowed(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Allow", a.allowed(r))
+
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	jsonError(w, http.StatusMethodNotAllowed, "method_not_allowed", r.Method+" isn't allowed here")
+}
@@ -0,0 +1,51 @@
+package tutor4
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// TestAllowWithMocks asks for methods a path doesn't take,
+// and OPTIONS, and checks Allow has just what that path
+// takes, with or without CORS
+func TestAllowWithMocks(t *testing.T) {
+	table := []struct {
+		method string
+		path   string
+		status int
+		allow  string
+	}{
+		{"OPTIONS", "/items", http.StatusNoContent, "GET, POST, OPTIONS"},
+		{"DELETE", "/items", http.StatusMethodNotAllowed, "GET, POST, OPTIONS"},
+		{"PUT", "/items", http.StatusMethodNotAllowed, "GET, POST, OPTIONS"},
+		{"OPTIONS", "/items/abc", http.StatusNoContent, "GET, PUT, PATCH, DELETE, OPTIONS"},
+		{"POST", "/items/abc", http.StatusMethodNotAllowed, "GET, PUT, PATCH, DELETE, OPTIONS"},
+		{"DELETE", "/skus", http.StatusMethodNotAllowed, "GET, OPTIONS"},
+	}
+
+	for _, args := range [][]string{{"-no-auth"}, {"-no-auth", "-cors-origins", "*"}} {
+		d := new(mockDB)
+		a := app{router: mux.NewRouter(), db: d}
+
+		if err := a.fromArgs(args); err != nil {
+			t.Fatal(err)
+		}
+
+		d.preload()
+		a.addRoutes()
+
+		for _, tt := range table {
+			r := httptest.NewRequest(tt.method, "http://who-cares"+tt.path, nil)
+			w := httptest.NewRecorder()
+
+			a.router.ServeHTTP(w, r)
+
+			if w.Code != tt.status || w.Header().Get("Allow") != tt.allow {
+				t.Errorf("%v %s %s: got %d, Allow %q", args, tt.method, tt.path, w.Code, w.Header().Get("Allow"))
+			}
+		}
+	}
+}
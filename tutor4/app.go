@@ -2,17 +2,21 @@ package tutor4
 
 import (
 	"context"
+	"encoding/base64"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/99designs/gqlgen/graphql/handler"
-	"github.com/99designs/gqlgen/graphql/playground"
 	"github.com/gorilla/mux"
 
 	"tutor4/db"
@@ -21,16 +25,117 @@ import (
 )
 
 type app struct {
-	router  *mux.Router
-	server  *http.Server
-	graphql *handler.Server
-	db      db.DB
-	addr    string
-	project string
-	data    string
-	util    string
-	noAuth  bool
-	debug   bool
+	router   *mux.Router
+	server   *http.Server
+	graphql  *handler.Server
+	db       db.DB
+	backend  string
+	addr     string
+	project  string
+	data     string
+	util     string
+	emulator string
+	skuStart int
+	skuMode  string
+	slow     time.Duration
+	staleOK  time.Duration
+	logFmt   string
+
+	// logDrop is the fraction of good requests we don't
+	// log (1 - -log-sample), so by default we log them all
+	logDrop  float64
+	logSlow  time.Duration
+	logRand  xorshift
+	order    string
+	idScheme string
+
+	// resources are the paths we serve items under, if
+	// not just /items (see -resources)
+	resources []*resource
+
+	// strictIDs means an item ID in a path must look
+	// like one we'd make
+	strictIDs bool
+
+	collation string
+	encKey    string
+	noAuth    bool
+	noREST    bool
+	https     bool
+	debug     bool
+	pprof     bool
+
+	// checkIndexes tries our queries at startup to find
+	// missing Firestore indexes
+	checkIndexes bool
+
+	// trustProxy means we believe X-Forwarded-For etc.
+	trustProxy bool
+
+	corsOrigins []string
+	corsMaxAge  time.Duration
+	corsCreds   bool
+
+	reconcileEvery time.Duration
+	maxConcurrent  int
+	maxURI         int
+	sem            chan struct{}
+
+	// maxBulk is the most items in one bulk request
+	maxBulk int
+
+	// REST calls should be quick, but a GraphQL query may
+	// do a lot more work, so each has its own deadline
+	restTimeout time.Duration
+	gqlTimeout  time.Duration
+	gqlMaxItems int
+
+	// streamTimeout is for a backup or restore, which may
+	// run for minutes
+	streamTimeout time.Duration
+
+	predrain time.Duration
+	draining int32
+	webhook  string
+	users    map[string]user
+
+	// maxHeader and noKeepAlive tune the server for the
+	// proxy in front of it
+	maxHeader   int
+	noKeepAlive bool
+
+	// flags are what fromArgs parsed, for -print-config
+	flags      *flag.FlagSet
+	showConfig bool
+
+	// auditClosed fails a change if we can't audit it
+	auditClosed bool
+
+	// skuPrefix and skuWidth make the display form of a
+	// SKU, e.g. PRD-0001042
+	skuPrefix string
+	skuWidth  int
+
+	// skuString sends SKUs in REST responses as strings
+	// (see skuStrings)
+	skuString bool
+
+	// root is what we serve at / (see rootHandler)
+	root    string
+	rootURL string
+	started time.Time
+
+	// wg tracks background work that must finish (or
+	// be told to stop) before we exit; bg is canceled
+	// when we stop, and bgMu keeps work from being added
+	// after that (see goBG)
+	wg     sync.WaitGroup
+	bg     context.Context
+	bgMu   sync.Mutex
+	stopBG context.CancelFunc
+
+	// closers are flushed after the server stops
+	closers []closer
 }
 
 func (a *app) serve() int {
@@ -38,6 +143,22 @@ func (a *app) serve() int {
 
 	signal.Notify(done, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
 
+	a.start()
+	<-done
+
+	return a.stop()
+}
+
+// start runs the server and any background work
+// without blocking
+func (a *app) start() {
+	a.bg, a.stopBG = context.WithCancel(context.Background())
+
+	if a.reconcileEvery > 0 {
+		a.wg.Add(1)
+		go a.reconcile(a.bg)
+	}
+
 	go func() {
 		if err := a.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("listen: %s\n", err)
@@ -45,9 +166,22 @@ func (a *app) serve() int {
 	}()
 
 	log.Print("server started on ", a.addr)
-	<-done
+}
+
+// stop drains and shuts down the server, returning the
+// exit code for the process
+func (a *app) stop() int {
 	log.Print("server stopping")
 
+	// first fail the readiness probe, and give the load
+	// balancer time to notice and stop sending us traffic
+
+	if a.predrain > 0 {
+		atomic.StoreInt32(&a.draining, 1)
+		log.Print("server draining for ", a.predrain)
+		time.Sleep(a.predrain)
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 
 	defer func() {
@@ -55,104 +189,519 @@ func (a *app) serve() int {
 		log.Print("server stopped")
 	}()
 
-	if err := a.server.Shutdown(ctx); err != nil {
+	err := a.server.Shutdown(ctx)
+
+	// a handler that outlived the shutdown may still try
+	// to start a webhook, which goBG won't allow now
+
+	a.bgMu.Lock()
+	a.stopBG()
+	a.bgMu.Unlock()
+
+	code := 0
+
+	if err != nil {
 		log.Printf("server shutdown: %s", err)
-		return -1
+		code = -1
+	}
+
+	if !a.waitBG(ctx) {
+		log.Print("background work still running at shutdown")
+		code = -1
+	}
+
+	// the last requests are done, so whatever they left
+	// buffered can go now, in whatever time we have left
+
+	for _, c := range a.closers {
+		if err := c.close(ctx); err != nil {
+			log.Printf("close %s: %s", c.name, err)
+			code = -1
+		}
+	}
+
+	// the DBs go last, in case a closer still needs one
+
+	for _, d := range a.stores() {
+		if err := d.Close(); err != nil {
+			log.Printf("close DB: %s", err)
+			code = -1
+		}
 	}
 
-	return 0
+	return code
+}
+
+// closer is something to flush or close once the server
+// has stopped, e.g. a tracing or metrics exporter
+type closer struct {
+	name  string
+	close func(context.Context) error
+}
+
+// goBG runs f in the background with a context that's
+// canceled when we stop, unless we're stopping already;
+// the lock makes sure the group isn't added to while stop
+// waits on it
+func (a *app) goBG(f func(context.Context)) bool {
+	a.bgMu.Lock()
+	defer a.bgMu.Unlock()
+
+	ctx := a.bg
+
+	if ctx == nil {
+		ctx = context.Background() // never started, e.g. in tests
+	} else if ctx.Err() != nil {
+		return false
+	}
+
+	a.wg.Add(1)
+
+	go func() {
+		defer a.wg.Done()
+		f(ctx)
+	}()
+
+	return true
+}
+
+// waitBG waits for background work until ctx is done, and
+// reports whether it all finished
+func (a *app) waitBG(ctx context.Context) bool {
+	done := make(chan struct{})
+
+	go func() {
+		a.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// onStop adds a closer; they run in the order added
+func (a *app) onStop(name string, close func(context.Context) error) {
+	a.closers = append(a.closers, closer{name, close})
+}
+
+// reconcile keeps the SKU counter ahead of the highest
+// SKU in use until the context is canceled
+func (a *app) reconcile(ctx context.Context) {
+	defer a.wg.Done()
+
+	t := time.NewTicker(a.reconcileEvery)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-t.C:
+			for _, d := range a.stores() {
+				fixed, err := d.ReconcileSKU(ctx)
+
+				if err != nil {
+					log.Printf("reconcile SKU: %s", err)
+					continue
+				}
+
+				if fixed {
+					log.Print("reconcile SKU: repaired counter")
+				}
+			}
+		}
+	}
+}
+
+// backendOpts is what we open the backend with
+func (a *app) backendOpts() map[string]string {
+	return map[string]string{
+		"project":  a.project,
+		"data":     a.data,
+		"util":     a.util,
+		"emulator": a.emulator,
+		"skuStart": strconv.Itoa(a.skuStart),
+	}
 }
 
 func (a *app) createClient() (err error) {
-	a.db, err = db.NewClient(a.project, a.data, a.util)
+	a.db, err = a.openDB(a.backendOpts())
+	return err
+}
+
+// openDB opens and configures the backend, and wraps it
+// as the flags say
+func (a *app) openDB(opts map[string]string) (db.DB, error) {
+	c, err := db.Open(a.backend, opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err = a.configure(c); err != nil {
+		return nil, err
+	}
+
+	if ic, ok := c.(indexChecker); ok && a.checkIndexes {
+		warnIndexes(ic)
+	}
+
+	// the audit wrapper goes on the backend itself, which
+	// may audit its own changes and not need it
+
+	return db.WithCoalescing(db.WithStaleList(db.WithSlowLog(db.WithAudit(c, a.auditClosed), a.slow), a.staleOK)), nil
+}
+
+// configurable is a backend that takes the settings from
+// dbFlags once it's been created
+type configurable interface {
+	SetListOrder(string) error
+	SetIDScheme(string) error
+	SetCollation(string) error
+}
+
+// keyed is a backend that can encrypt secret fields
+type keyed interface {
+	SetKey([]byte) error
+}
+
+// skuModed is a backend that can hand out SKUs other
+// than one transaction per create
+type skuModed interface {
+	SetSKUMode(string) error
+}
+
+func (a *app) configure(d db.DB) error {
+	if a.skuMode != db.SKUTransaction {
+		m, ok := d.(skuModed)
+
+		if !ok {
+			return fmt.Errorf("backend %s can't use SKU mode %s", a.backend, a.skuMode)
+		}
+
+		if err := m.SetSKUMode(a.skuMode); err != nil {
+			return err
+		}
+	}
+
+	if a.encKey != "" {
+		k, ok := d.(keyed)
+
+		if !ok {
+			return fmt.Errorf("backend %s can't encrypt fields", a.backend)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(a.encKey)
+
+		if err != nil {
+			return fmt.Errorf("invalid encryption key: %w", err)
+		}
+
+		if err = k.SetKey(key); err != nil {
+			return err
+		}
+	}
+
+	c, ok := d.(configurable)
+
+	if !ok {
+		return nil
+	}
+
+	if err := c.SetListOrder(a.order); err != nil {
+		return err
+	}
+
+	if err := c.SetIDScheme(a.idScheme); err != nil {
+		return err
+	}
 
-	return
+	return c.SetCollation(a.collation)
 }
 
+// handler is the router with the checks that must come
+// before routing
+func (a *app) handler() http.Handler {
+	if a.maxURI > 0 {
+		return a.limitURI(a.router)
+	}
+
+	return a.router
+}
+
+// writeSlack is how much longer than the longest handler
+// timeout the server gives a response to be written
+const writeSlack = 5 * time.Second
+
 func (a *app) makeServer() {
+	// the handler timeouts are what limit a request; the
+	// server's WriteTimeout is just a backstop, and must be
+	// longer than both or it would cut off a slow response
+	// without the handler's error
+
+	write := a.restTimeout
+
+	if a.gqlTimeout > write {
+		write = a.gqlTimeout
+	}
+
 	a.server = &http.Server{
 		Addr:    a.addr,
-		Handler: a.router,
+		Handler: a.handler(),
 
 		ReadTimeout:       10 * time.Second,
-		WriteTimeout:      10 * time.Second,
+		WriteTimeout:      write + writeSlack,
 		IdleTimeout:       60 * time.Second,
 		ReadHeaderTimeout: 20 * time.Second,
+		MaxHeaderBytes:    a.maxHeader,
+		ConnContext:       withConn,
+	}
+
+	if a.noKeepAlive {
+		a.server.SetKeepAlivesEnabled(false)
 	}
 }
 
 func (a *app) addRoutes() {
-	r := graph.Resolver{Client: a.db}
+	r := graph.Resolver{Client: a.db, OnCreate: a.notify, CanWrite: a.canWrite, MaxItems: a.gqlMaxItems}
 	c := generated.Config{Resolvers: &r}
 	s := generated.NewExecutableSchema(c)
 
+	a.started = time.Now()
 	a.graphql = handler.NewDefaultServer(s)
+	a.graphql.SetErrorPresenter(graph.ErrorPresenter)
+
+	if a.logFmt == "clf" {
+		a.router.Use(a.logCLF)
+	} else {
+		a.router.Use(a.logRequest)
+	}
+
+	if a.maxConcurrent > 0 {
+		a.sem = make(chan struct{}, a.maxConcurrent)
+		a.router.Use(a.limit)
+	}
+
+	if a.https {
+		a.router.Use(forceHTTPS)
+	}
 
-	a.router.Use(logRequest)
+	// a path we serve, but not with the method asked for,
+	// gets 405 (or 204 for OPTIONS) with Allow
+
+	a.router.MethodNotAllowedHandler = http.HandlerFunc(a.notAllowed)
+
+	// CORS preflights don't match any of our routes (or carry
+	// credentials), so they get a route of their own
+
+	if len(a.corsOrigins) > 0 {
+		a.router.Use(a.cors)
+		a.router.Methods("OPTIONS").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Allow", a.allowed(r))
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
 
 	if a.noAuth {
 		log.Println("AUTH DISABLED")
 	} else {
-		a.router.Use(basicAuth)
+		a.router.Use(a.basicAuth)
 	}
 
-	a.router.Handle("/", playground.Handler("GraphQL playground", "/graphql"))
-	a.router.Handle("/graphql", a.graphql)
+	if a.encKey != "" {
+		a.router.Use(a.secrets)
+	}
+
+	// GraphQL and REST each get a subrouter so they can
+	// have their own timeouts; the GraphQL one must match
+	// on its path, because a subrouter that fails to match
+	// leaves an error that stops the middleware for any
+	// later match
 
-	a.router.HandleFunc("/items", a.list).Methods("GET")
-	a.router.HandleFunc("/items", a.add).Methods("POST")
+	gql := a.router.Path("/graphql").Subrouter()
+	gql.Use(timeout(a.gqlTimeout))
+	gql.NewRoute().Handler(graph.LoaderMiddleware(a.db, a.graphql))
 
-	a.router.HandleFunc("/items/{id}", a.get).Methods("GET")
-	a.router.HandleFunc("/items/{id}", a.put).Methods("PUT")
-	a.router.HandleFunc("/items/{id}", a.drop).Methods("DELETE")
+	if a.pprof {
+		log.Println("PPROF ENABLED on", pprofPrefix)
+		a.addPprof()
+	}
+
+	// a backup or restore can take far longer than the
+	// REST timeout (or the server's read and write ones),
+	// so they're routes of their own with a limit of their
+	// own; they come before the REST subrouter, because
+	// with mux 1.6.1 a route after a subrouter that fails
+	// to match skips all our middleware, auth included
+
+	if !a.noREST {
+		a.router.HandleFunc("/admin/backup", a.streaming(a.editor(a.backup))).Methods("GET")
+		a.router.HandleFunc("/admin/restore", a.streaming(a.editor(a.restore))).Methods("POST")
+	}
+
+	rest := a.router.NewRoute().Subrouter()
+	rest.Use(timeout(a.restTimeout))
+	rest.Use(versioned)
+
+	if a.skuString {
+		rest.Use(skuStrings)
+	}
+	rest.Handle("/", a.rootHandler())
 
-	a.router.HandleFunc("/skus", a.listSKU).Methods("GET")
+	// the probes and root page stay even with -rest=false,
+	// but nothing else does
 
-	a.router.HandleFunc("/skus/{sku}", a.getSKU).Methods("GET")
+	rest.HandleFunc("/healthz", a.health).Methods("GET")
+	rest.HandleFunc("/readyz", a.ready).Methods("GET")
+
+	if a.noREST {
+		log.Println("REST DISABLED")
+		return
+	}
+
+	a.addResources(rest)
+
+	// SKUs and changes are the main DB's (-data); another
+	// resource has its own, which these don't cover
+
+	rest.HandleFunc("/skus", a.listSKU).Methods("GET")
+	rest.HandleFunc("/skus/reserve", a.editor(a.reserve)).Methods("POST")
+
+	rest.HandleFunc("/skus/{sku}", a.getSKU).Methods("GET")
+
+	rest.HandleFunc("/stats/sku", a.skuStats).Methods("GET")
+
+	rest.HandleFunc("/changes", a.changes).Methods("GET")
+
+	rest.HandleFunc("/schema", a.schema).Methods("GET")
+
+	rest.HandleFunc("/admin/audit", a.editor(a.auditTrail)).Methods("GET")
+	rest.HandleFunc("/admin/backend", a.editor(a.backendInfo)).Methods("GET")
+	rest.HandleFunc("/admin/reindex", a.editor(a.reindex)).Methods("POST")
+	rest.HandleFunc("/admin/routes", a.editor(a.routeTable)).Methods("GET")
+}
+
+// dbFlags are the flags every command needs to reach
+// the database
+func (a *app) dbFlags(fl *flag.FlagSet) {
+	fl.StringVar(&a.backend, "backend", "firestore", "storage backend ("+strings.Join(db.Backends(), ", ")+")")
+	fl.StringVar(&a.project, "proj", "tutor-dev", "GCP project")
+	fl.StringVar(&a.data, "data", "items", "FS data collection")
+	fl.StringVar(&a.util, "util", "util", "FS util collection")
+	fl.StringVar(&a.emulator, "emulator", "", "FS emulator host:port")
+	fl.StringVar(&a.skuMode, "sku-mode", db.SKUTransaction, "how creates get SKUs (transaction, or batched to skip the SKU counter but gaps on restart; creates still take turns on the change counter)")
+	fl.IntVar(&a.skuStart, "sku-start", db.DefaultStartSKU, "first SKU of a new database (an existing counter is kept)")
+	fl.DurationVar(&a.slow, "slow-threshold", 0, "log DB calls slower than this")
+	fl.BoolVar(&a.auditClosed, "audit-fail-closed", false, "fail a change that can't be audited (Firestore writes the entry with the change)")
+	fl.StringVar(&a.order, "list-order", db.OrderSKU, "item list order (sku, id or name)")
+	fl.StringVar(&a.collation, "collation", db.CollateNoCase, "how names sort (binary, nocase, or fold for case and accents)")
+	fl.StringVar(&a.idScheme, "id-scheme", db.IDUUID, "new item IDs (uuid, or sku for item-<sku>)")
+	fl.StringVar(&a.encKey, "enc-key", "", "AES key for secret fields (base64, 16, 24 or 32 bytes)")
 }
 
 func (a *app) fromArgs(args []string) error {
+	var origins, usersFile, resources string
+
 	fl := flag.NewFlagSet("service", flag.ContinueOnError)
 
+	a.dbFlags(fl)
+
 	fl.StringVar(&a.addr, "addr", "localhost:8080", "server address")
-	fl.StringVar(&a.project, "proj", "tutor-dev", "GCP project")
-	fl.StringVar(&a.data, "data", "items", "FS data collection")
-	fl.StringVar(&a.util, "util", "util", "FS util collection")
+	fl.DurationVar(&a.staleOK, "stale-ok", 0, "how stale an item list may be (0 = always read)")
+	fl.DurationVar(&a.restTimeout, "rest-timeout", 10*time.Second, "time limit for a REST request (0 = none)")
+	fl.DurationVar(&a.gqlTimeout, "gql-timeout", 30*time.Second, "time limit for a GraphQL request (0 = none)")
+	fl.DurationVar(&a.streamTimeout, "stream-timeout", 30*time.Minute, "time limit for a backup or restore (0 = none)")
+	fl.IntVar(&a.gqlMaxItems, "gql-max-items", 10000, "most items a GraphQL items query returns (0 = no limit)")
+	fl.DurationVar(&a.reconcileEvery, "reconcile-interval", 0, "how often to check the SKU counter (0 = never)")
+
+	fl.StringVar(&a.logFmt, "log-format", "text", "request log format (text or clf)")
+	sample := fl.Float64("log-sample", 1, "fraction of requests to log (0 to 1); failed and slow ones always are")
+	fl.DurationVar(&a.logSlow, "log-slow", time.Second, "always log requests slower than this")
+	fl.StringVar(&a.skuPrefix, "sku-prefix", "", "prefix for displayed SKUs, e.g. PRD-")
+	fl.IntVar(&a.skuWidth, "sku-width", 7, "digits in a displayed SKU (zero-padded)")
+	fl.BoolVar(&a.skuString, "sku-string", false, "send SKUs in JSON as strings, for JavaScript clients")
+	fl.StringVar(&a.root, "root", rootPlayground, "what / serves (playground, redirect or status)")
+	fl.StringVar(&a.rootURL, "root-url", "", "where / redirects to with -root=redirect")
 
 	fl.BoolVar(&a.debug, "debug", false, "enable debugging")
+	fl.BoolVar(&a.showConfig, "print-config", false, "print the settings as JSON (secrets redacted) and exit")
+	fl.BoolVar(&a.pprof, "pprof", false, "serve /debug/pprof/ (to localhost only)")
 	fl.BoolVar(&a.noAuth, "no-auth", false, "disable auth")
+	rest := fl.Bool("rest", true, "serve the REST API (else only GraphQL)")
+	fl.StringVar(&usersFile, "users", "", "JSON file of users and roles (default admin only)")
+	fl.BoolVar(&a.https, "force-https", false, "redirect HTTP to HTTPS")
+	fl.BoolVar(&a.trustProxy, "trust-proxy", false, "take the client IP from proxy headers")
+	fl.StringVar(&a.webhook, "webhook-url", "", "URL to post new items to")
+	fl.StringVar(&resources, "resources", "", resourcesUsage)
+	fl.DurationVar(&a.predrain, "predrain", 0, "time to fail /readyz before shutdown")
+	fl.IntVar(&a.maxConcurrent, "max-concurrent", 0, "max requests in flight (0 = no limit)")
+	fl.BoolVar(&a.checkIndexes, "check-indexes", true, "try each kind of query at startup and warn of missing indexes")
+	fl.BoolVar(&a.strictIDs, "strict-ids", false, "reject item IDs in paths that don't match -id-scheme")
+	fl.IntVar(&a.maxURI, "max-uri", 8192, "longest request URI in bytes, else 414 (0 = no limit)")
+	fl.IntVar(&a.maxBulk, "max-bulk", 500, fmt.Sprintf("most items in a bulk create, upsert or import (1 to %d)", db.MaxSKUBlock))
+	fl.IntVar(&a.maxHeader, "max-header-bytes", http.DefaultMaxHeaderBytes, "most bytes of request headers, including the request line")
+	keepAlive := fl.Bool("keep-alive", true, "keep connections open between requests (false = close after each)")
+
+	fl.StringVar(&origins, "cors-origins", "", "CORS origins (comma-separated or *)")
+	fl.DurationVar(&a.corsMaxAge, "cors-max-age", 0, "CORS preflight cache time")
+	fl.BoolVar(&a.corsCreds, "cors-credentials", false, "allow CORS credentials")
 
 	if err := fl.Parse(args); err != nil {
 		return err
 	}
 
-	return nil
-}
+	a.flags = fl
+	a.noREST = !*rest
+	a.noKeepAlive = !*keepAlive
 
-func (a *app) listRoutes() {
-	visit := func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
-		t, err := route.GetPathTemplate()
+	if a.maxHeader < 1 {
+		return fmt.Errorf("invalid max header bytes: %d", a.maxHeader)
+	}
 
-		if err != nil {
-			return err
-		}
+	if a.maxBulk < 1 || a.maxBulk > db.MaxSKUBlock {
+		return fmt.Errorf("invalid max bulk: %d (1 to %d)", a.maxBulk, db.MaxSKUBlock)
+	}
 
-		m, err := route.GetMethods()
+	var err error
 
-		if err != nil {
+	if a.resources, err = parseResources(resources); err != nil {
+		return err
+	}
+
+	if usersFile != "" {
+		if a.users, err = loadUsers(usersFile); err != nil {
 			return err
 		}
+	}
 
-		log.Println("route", t, m)
-		return nil
+	if a.logFmt != "text" && a.logFmt != "clf" {
+		return fmt.Errorf("invalid log format: %s", a.logFmt)
 	}
 
-	if err := a.router.Walk(visit); err != nil {
-		fmt.Fprintln(os.Stderr, err)
+	if *sample < 0 || *sample > 1 {
+		return fmt.Errorf("invalid log sample: %g (must be 0 to 1)", *sample)
+	}
+
+	a.logDrop = 1 - *sample
+
+	if a.skuWidth < 0 {
+		return fmt.Errorf("invalid SKU width: %d", a.skuWidth)
 	}
+
+	if err := a.checkRoot(); err != nil {
+		return err
+	}
+
+	return a.parseCORS(origins)
 }
 
-func RunApp(args []string) int {
+func runServe(args []string) int {
 	a := app{router: mux.NewRouter()}
 
 	if err := a.fromArgs(args); err != nil {
@@ -160,11 +709,25 @@ func RunApp(args []string) int {
 		return -2
 	}
 
+	if a.showConfig {
+		if err := a.printConfig(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return -1
+		}
+
+		return 0
+	}
+
 	if err := a.createClient(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		return -2
 	}
 
+	if err := a.openResources(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return -2
+	}
+
 	a.makeServer()
 	a.addRoutes()
 
@@ -174,3 +737,28 @@ func RunApp(args []string) int {
 
 	return a.serve()
 }
+
+// NewHandler builds the service around an existing DB
+// without starting a server, so it can be embedded or
+// used in tests; args are the same flags RunApp takes
+func NewHandler(d db.DB, args []string) (http.Handler, error) {
+	a := app{router: mux.NewRouter(), db: d}
+
+	if err := a.fromArgs(args); err != nil {
+		return nil, err
+	}
+
+	if err := a.configure(d); err != nil {
+		return nil, err
+	}
+
+	a.db = db.WithAudit(d, a.auditClosed)
+
+	if err := a.openResources(); err != nil {
+		return nil, err
+	}
+
+	a.addRoutes()
+
+	return a.handler(), nil
+}
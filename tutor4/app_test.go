@@ -0,0 +1,410 @@
+package tutor4
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"tutor4/db"
+	"tutor4/graph/model"
+)
+
+// TestReconcileWithMocks puts the SKU counter behind the
+// items and expects the reconciler to bump it
+func TestReconcileWithMocks(t *testing.T) {
+	d := new(mockDB)
+	a := app{
+		router:         mux.NewRouter(),
+		db:             d,
+		reconcileEvery: time.Millisecond,
+	}
+
+	d.preload()
+	d.next = 1000
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	a.wg.Add(1)
+	go a.reconcile(ctx)
+
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	a.wg.Wait()
+
+	if d.next != 1009 {
+		t.Errorf("invalid next SKU: %d", d.next)
+	}
+}
+
+// TestWebhookShutdown cancels a webhook that hangs, so
+// shutdown needn't wait out its retries, and starts no
+// more once it's stopping
+func TestWebhookShutdown(t *testing.T) {
+	release := make(chan struct{})
+
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+
+	defer hook.Close()
+	defer close(release)
+
+	a := app{webhook: hook.URL}
+
+	a.bg, a.stopBG = context.WithCancel(context.Background())
+
+	a.notify(&model.Item{ID: "x"})
+
+	time.Sleep(20 * time.Millisecond)
+
+	a.bgMu.Lock()
+	a.stopBG()
+	a.bgMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if !a.waitBG(ctx) {
+		t.Fatal("webhook wasn't canceled")
+	}
+
+	if a.goBG(func(context.Context) {}) {
+		t.Errorf("background work started after stop")
+	}
+}
+
+// TestPredrain checks that /readyz fails while we wait
+// for the load balancer, before the server shuts down
+func TestPredrain(t *testing.T) {
+	a := app{
+		router:   mux.NewRouter(),
+		db:       new(mockDB),
+		addr:     "localhost:0",
+		predrain: 100 * time.Millisecond,
+	}
+
+	a.makeServer()
+	a.addRoutes()
+
+	ready := func() int {
+		r := httptest.NewRequest("GET", "http://who-cares/readyz", nil)
+		w := httptest.NewRecorder()
+
+		a.router.ServeHTTP(w, r)
+		return w.Result().StatusCode
+	}
+
+	a.start()
+
+	if code := ready(); code != http.StatusOK {
+		t.Errorf("invalid ready response: %d", code)
+	}
+
+	stopped := make(chan int)
+
+	go func() {
+		stopped <- a.stop()
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+
+	if code := ready(); code != http.StatusServiceUnavailable {
+		t.Errorf("invalid draining response: %d", code)
+	}
+
+	select {
+	case <-stopped:
+		t.Errorf("stopped during predrain")
+	default:
+	}
+
+	if code := <-stopped; code != 0 {
+		t.Errorf("invalid exit code: %d", code)
+	}
+}
+
+// TestClosersWithMocks checks closers run in order once
+// the server has stopped, and then the DB is closed
+func TestClosersWithMocks(t *testing.T) {
+	a := app{
+		router: mux.NewRouter(),
+		db:     new(mockDB),
+		addr:   "localhost:0",
+	}
+
+	a.makeServer()
+	a.addRoutes()
+
+	var closed []string
+
+	for _, name := range []string{"traces", "metrics"} {
+		name := name
+
+		a.onStop(name, func(ctx context.Context) error {
+			if _, ok := ctx.Deadline(); !ok {
+				t.Errorf("%s: no deadline", name)
+			}
+
+			closed = append(closed, name)
+			return nil
+		})
+	}
+
+	a.start()
+
+	if code := a.stop(); code != 0 {
+		t.Errorf("invalid exit code: %d", code)
+	}
+
+	if len(closed) != 2 || closed[0] != "traces" || closed[1] != "metrics" {
+		t.Errorf("invalid closers: %v", closed)
+	}
+
+	if d := a.db.(*mockDB); d.closed != 1 {
+		t.Errorf("DB closed %d times", d.closed)
+	}
+
+	// a closer that fails is an unclean exit
+
+	b := app{
+		router: mux.NewRouter(),
+		db:     new(mockDB),
+		addr:   "localhost:0",
+	}
+
+	b.makeServer()
+	b.addRoutes()
+	b.onStop("broken", func(context.Context) error { return errShouldFail })
+	b.start()
+
+	if code := b.stop(); code != -1 {
+		t.Errorf("invalid exit code: %d", code)
+	}
+}
+
+// TestServerLimits sets the header limit and turns off
+// keep-alives, which makes the server close connections
+func TestServerLimits(t *testing.T) {
+	a := app{router: mux.NewRouter(), db: new(mockDB)}
+
+	if err := a.fromArgs([]string{"-no-auth", "-max-header-bytes", "4096", "-keep-alive=false"}); err != nil {
+		t.Fatal(err)
+	}
+
+	a.makeServer()
+	a.addRoutes()
+
+	if a.server.MaxHeaderBytes != 4096 {
+		t.Errorf("invalid max header bytes: %d", a.server.MaxHeaderBytes)
+	}
+
+	ln, err := net.Listen("tcp", "localhost:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() { _ = a.server.Serve(ln) }()
+	defer a.server.Close()
+
+	resp, err := http.Get("http://" + ln.Addr().String() + "/healthz")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp.Body.Close()
+
+	if !resp.Close {
+		t.Errorf("connection kept alive")
+	}
+
+	var b app
+
+	if err = b.fromArgs(nil); err != nil || b.maxHeader != http.DefaultMaxHeaderBytes || b.noKeepAlive {
+		t.Errorf("invalid defaults: %d %t %v", b.maxHeader, b.noKeepAlive, err)
+	}
+
+	if err = b.fromArgs([]string{"-max-header-bytes", "0"}); err == nil {
+		t.Errorf("zero max header bytes allowed")
+	}
+}
+
+// fakeOpts is what the fake backend was opened with
+var fakeOpts map[string]string
+
+func init() {
+	db.Register("fake", func(opts map[string]string) (db.DB, error) {
+		fakeOpts = opts
+
+		d := new(mockDB)
+		d.preload()
+
+		return d, nil
+	})
+}
+
+// TestBackendWithMocks picks the fake backend by name
+func TestBackendWithMocks(t *testing.T) {
+	a := app{router: mux.NewRouter()}
+
+	if err := a.fromArgs([]string{"-backend", "fake", "-proj", "p1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.createClient(); err != nil {
+		t.Fatal(err)
+	}
+
+	if fakeOpts["project"] != "p1" {
+		t.Errorf("invalid options: %v", fakeOpts)
+	}
+
+	items, err := a.db.ListItems(context.Background())
+
+	if err != nil || len(items) != 9 {
+		t.Errorf("not the fake backend: %d items, %v", len(items), err)
+	}
+
+	a.backend = "nope"
+
+	if err = a.createClient(); err == nil {
+		t.Errorf("unknown backend accepted")
+	}
+}
+
+// TestEncKeyWithMocks refuses a key the backend can't use
+func TestEncKeyWithMocks(t *testing.T) {
+	a := app{router: mux.NewRouter()}
+
+	if err := a.fromArgs([]string{"-backend", "fake", "-enc-key", "AAAAAAAAAAAAAAAAAAAAAA=="}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.createClient(); err == nil || !strings.Contains(err.Error(), "encrypt") {
+		t.Errorf("wanted an error about encryption, got %v", err)
+	}
+}
+
+// TestBackendInfoWithMocks reports the memory backend as healthy
+func TestBackendInfoWithMocks(t *testing.T) {
+	a := app{router: mux.NewRouter()}
+
+	if err := a.fromArgs([]string{"-backend", "memory", "-no-auth"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.createClient(); err != nil {
+		t.Fatal(err)
+	}
+
+	a.addRoutes()
+
+	r := httptest.NewRequest("GET", "http://who-cares/admin/backend", nil)
+	w := httptest.NewRecorder()
+
+	a.router.ServeHTTP(w, r)
+
+	var report backendReport
+
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Backend != "memory" || !report.Healthy || report.Params["project"] != "tutor-dev" {
+		t.Errorf("invalid report: %#v", report)
+	}
+
+	// a backend that doesn't answer is still reported
+
+	d := new(mockDB)
+	d.fail = true
+	a.db = d
+
+	w = httptest.NewRecorder()
+	a.router.ServeHTTP(w, r)
+
+	report = backendReport{}
+
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Healthy || report.Error == "" {
+		t.Errorf("invalid report: %#v", report)
+	}
+}
+
+// TestSKUStartWithMocks sets -sku-start and checks the
+// first item gets it
+func TestSKUStartWithMocks(t *testing.T) {
+	a := app{router: mux.NewRouter()}
+
+	if err := a.fromArgs([]string{"-backend", "memory", "-sku-start", "100000", "-no-auth"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.createClient(); err != nil {
+		t.Fatal(err)
+	}
+
+	a.addRoutes()
+
+	r := httptest.NewRequest("POST", "http://who-cares/items", strings.NewReader(`{"name":"first"}`))
+	w := httptest.NewRecorder()
+
+	a.router.ServeHTTP(w, r)
+
+	if sku := w.Result().Header.Get("X-Item-SKU"); sku != "100000" {
+		t.Errorf("wanted SKU 100000, got %q", sku)
+	}
+
+	b := app{router: mux.NewRouter()}
+
+	if err := b.fromArgs([]string{"-backend", "memory", "-sku-start", "0", "-no-auth"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.createClient(); err == nil {
+		t.Errorf("SKU start 0 accepted")
+	}
+}
+
+// TestRoutesWithMocks reads the route table back
+func TestRoutesWithMocks(t *testing.T) {
+	a := app{router: mux.NewRouter(), db: new(mockDB), noAuth: true}
+
+	a.addRoutes()
+
+	r := httptest.NewRequest("GET", "http://who-cares/admin/routes", nil)
+	w := httptest.NewRecorder()
+
+	a.router.ServeHTTP(w, r)
+
+	var routes []routeInfo
+
+	if err := json.NewDecoder(w.Body).Decode(&routes); err != nil {
+		t.Fatal(err)
+	}
+
+	methods := map[string][]string{}
+
+	for _, r := range routes {
+		methods[r.Path] = append(methods[r.Path], r.Methods...)
+	}
+
+	if m := strings.Join(methods["/items"], ","); m != "GET,POST" {
+		t.Errorf("/items: wanted GET,POST, got %s", m)
+	}
+
+	if m, ok := methods["/graphql"]; !ok || len(m) != 0 {
+		t.Errorf("/graphql: wanted any method, got %v (%t)", m, ok)
+	}
+}
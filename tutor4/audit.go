@@ -0,0 +1,32 @@
+package tutor4
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// auditTrail lists the changes made to one item, oldest
+// first, e.g. GET /admin/audit?item=1234
+func (a *app) auditTrail(w http.ResponseWriter, r *http.Request) {
+	id := r.FormValue("item")
+
+	if id == "" {
+		writeError(w, http.StatusBadRequest, apiError{"item_required", "which item? (?item=id)", "item"})
+		return
+	}
+
+	entries, err := a.db.ListAudit(r.Context(), id)
+
+	if err != nil {
+		dbError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err = json.NewEncoder(w).Encode(entries); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, err)
+	}
+}
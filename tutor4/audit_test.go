@@ -0,0 +1,102 @@
+package tutor4
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"tutor4/db"
+)
+
+// TestAuditWithMocks updates an item and reads back its
+// audit trail
+func TestAuditWithMocks(t *testing.T) {
+	d := new(mockDB)
+	a := app{router: mux.NewRouter(), db: db.WithAudit(d, false)}
+
+	if err := a.fromArgs(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	d.preload()
+	a.addRoutes()
+
+	var id, name string
+
+	for k, v := range d.data {
+		id, name = k, v.Name
+		break
+	}
+
+	r := httptest.NewRequest("PUT", "http://who-cares/items/"+id, strings.NewReader(`{"name":"renamed"}`))
+	w := httptest.NewRecorder()
+
+	r.SetBasicAuth("admin", "secret")
+	a.router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("update: invalid response: %d", w.Code)
+	}
+
+	r = httptest.NewRequest("GET", "http://who-cares/admin/audit?item="+id, nil)
+	w = httptest.NewRecorder()
+
+	r.SetBasicAuth("admin", "secret")
+	a.router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("audit: invalid response: %d", w.Code)
+	}
+
+	var entries []*db.AuditEntry
+
+	if err := json.NewDecoder(w.Body).Decode(&entries); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 1 {
+		t.Fatalf("wanted 1 entry, got %d", len(entries))
+	}
+
+	e := entries[0]
+
+	if e.Action != db.AuditUpdate || e.User != "admin" || e.Before.Name != name || e.After.Name != "renamed" {
+		t.Errorf("invalid entry: %+v", e)
+	}
+}
+
+// TestAuditFailWithMocks can't write the audit log, which
+// only fails the change if we fail closed
+func TestAuditFailWithMocks(t *testing.T) {
+	table := []struct {
+		name       string
+		failClosed bool
+		status     int
+	}{
+		{"open", false, http.StatusCreated},
+		{"closed", true, http.StatusInternalServerError},
+	}
+
+	for _, st := range table {
+		t.Run(st.name, func(t *testing.T) {
+			d := &mockDB{noAudit: true}
+			a := app{router: mux.NewRouter(), db: db.WithAudit(d, st.failClosed), noAuth: true}
+
+			d.preload()
+			a.addRoutes()
+
+			r := httptest.NewRequest("POST", "http://who-cares/items", strings.NewReader(`{"name":"new"}`))
+			w := httptest.NewRecorder()
+
+			a.router.ServeHTTP(w, r)
+
+			if w.Code != st.status {
+				t.Errorf("invalid response: %d", w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package tutor4
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+// redacted stands in for a secret we won't show
+const redacted = "[redacted]"
+
+type backendReport struct {
+	Backend string            `json:"backend"`
+	Healthy bool              `json:"healthy"`
+	Error   string            `json:"error,omitempty"`
+	Params  map[string]string `json:"params"`
+}
+
+// backendInfo says which backend we're using, how it was
+// set up and whether it answers, e.g. to find a deployment
+// pointed at the wrong project
+func (a *app) backendInfo(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), healthTimeout)
+	defer cancel()
+
+	report := backendReport{Backend: a.backend, Healthy: true, Params: map[string]string{}}
+
+	for k, v := range a.backendOpts() {
+		if v != "" {
+			report.Params[k] = v
+		}
+	}
+
+	if a.encKey != "" {
+		report.Params["encKey"] = redacted
+	}
+
+	if err := a.db.Ping(ctx); err != nil {
+		report.Healthy, report.Error = false, err.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	_ = json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,225 @@
+package tutor4
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"tutor4/db"
+	"tutor4/graph/model"
+)
+
+const (
+	// restoreBatch is how many items restore writes at once,
+	// so a big backup never has to be held in memory
+	restoreBatch = 500
+
+	// maxRestoreLine is the longest item a backup may have
+	maxRestoreLine = 1 << 20
+
+	// backupPage is how many items backup reads at once,
+	// for the same reason
+	backupPage = 500
+)
+
+// backup streams every item of a resource (?resource=, or
+// the main DB) as gzipped NDJSON, the same lines export
+// writes, for restore to load elsewhere; it's read a page
+// at a time in SKU order, and each page is sent as it
+// comes
+func (a *app) backup(w http.ResponseWriter, r *http.Request) {
+	d, path, ok := a.resourceDB(w, r)
+
+	if !ok {
+		return
+	}
+
+	items, err := d.ListItemPage(r.Context(), 0, backupPage, nil)
+
+	if err != nil {
+		dbError(w, err)
+		return
+	}
+
+	name := path + "-" + time.Now().UTC().Format("20060102T150405Z") + ".ndjson.gz"
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+
+	gz := gzip.NewWriter(w)
+
+	// once we've started there's no way to send an error,
+	// but a backup cut short won't unzip
+
+	for err == nil && len(items) > 0 {
+		if err = writeItems(gz, items); err != nil || len(items) < backupPage {
+			break
+		}
+
+		items, err = d.ListItemPage(r.Context(), items[len(items)-1].Sku, backupPage, nil)
+	}
+
+	if err == nil {
+		err = gz.Close()
+	}
+
+	if err != nil {
+		log.Printf("ERROR backup %s: %s", path, err)
+	}
+}
+
+// restore loads a backup into a resource (?resource=, or
+// the main DB), keeping each item's ID and SKU and
+// replacing any item with the same ID, and then moves the
+// SKU counter past the highest SKU so new items can't
+// clash; it takes the gzipped NDJSON backup sends, or the
+// plain NDJSON of export
+//
+// restored items don't go to the webhook, which would
+// otherwise get the whole catalog at once
+func (a *app) restore(w http.ResponseWriter, r *http.Request) {
+	d, _, ok := a.resourceDB(w, r)
+
+	if !ok {
+		return
+	}
+
+	in, err := ungzip(r.Body)
+
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, "invalid_input", err.Error())
+		return
+	}
+
+	n, err := restoreItems(r, d, in)
+
+	// some items may be in even if it failed, and their
+	// SKUs are as much in use as if it hadn't
+
+	fixed, rerr := d.ReconcileSKU(r.Context())
+
+	if rerr != nil {
+		log.Printf("ERROR restore: reconcile SKU after %d items: %s", n, rerr)
+
+		if err == nil {
+			err = rerr
+		}
+	}
+
+	if err != nil {
+		var bad badLine
+
+		switch {
+		case errors.As(err, &bad) && n == 0:
+			jsonError(w, http.StatusBadRequest, "invalid_input", err.Error())
+		case n == 0:
+			dbError(w, err)
+		default:
+			log.Printf("ERROR restore: %d items written: %s", n, err)
+			jsonError(w, http.StatusInternalServerError, "partial_write", fmt.Sprintf("only %d items were restored: %s", n, err))
+		}
+
+		return
+	}
+
+	result := struct {
+		Restored int  `json:"restored"`
+		SKUFixed bool `json:"skuFixed"`
+	}{n, fixed}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	_ = json.NewEncoder(w).Encode(result)
+}
+
+// badLine is a backup line we can't restore
+type badLine struct {
+	line int
+	err  error
+}
+
+func (b badLine) Error() string {
+	return fmt.Sprintf("line %d: %s", b.line, b.err)
+}
+
+// restoreItems reads items a batch at a time and returns
+// how many it wrote
+func restoreItems(r *http.Request, d db.DB, in io.Reader) (int, error) {
+	done := 0
+	batch := make([]*model.Item, 0, restoreBatch)
+
+	flush := func() error {
+		n, err := d.Restore(r.Context(), batch)
+
+		done += n
+		batch = batch[:0]
+
+		return err
+	}
+
+	s := bufio.NewScanner(in)
+	s.Buffer(nil, maxRestoreLine)
+
+	for line := 1; s.Scan(); line++ {
+		if len(bytes.TrimSpace(s.Bytes())) == 0 {
+			continue
+		}
+
+		var item model.Item
+
+		if err := json.Unmarshal(s.Bytes(), &item); err != nil {
+			return done, badLine{line, err}
+		}
+
+		if item.ID == "" || item.Sku < 1 {
+			return done, badLine{line, fmt.Errorf("item has no ID or SKU")}
+		}
+
+		if err := item.Validate(); err != nil {
+			return done, badLine{line, err}
+		}
+
+		if batch = append(batch, &item); len(batch) == restoreBatch {
+			if err := flush(); err != nil {
+				return done, err
+			}
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		return done, fmt.Errorf("read backup: %w", err)
+	}
+
+	if len(batch) == 0 {
+		return done, nil
+	}
+
+	err := flush()
+
+	return done, err
+}
+
+// ungzip unzips a body that starts like gzip, and passes
+// through one that doesn't
+func ungzip(body io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(body)
+
+	if magic, _ := br.Peek(2); !bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		return br, nil
+	}
+
+	gz, err := gzip.NewReader(br)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid gzip: %w", err)
+	}
+
+	return gz, nil
+}
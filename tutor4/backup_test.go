@@ -0,0 +1,123 @@
+package tutor4
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"tutor4/graph/model"
+)
+
+// TestRestoreWithMocks takes plain NDJSON and rejects
+// lines that didn't come from a backup
+func TestRestoreWithMocks(t *testing.T) {
+	table := []struct {
+		body   string
+		status int
+		items  int
+	}{
+		{`{"id":"a","sku":2000,"name":"apple"}` + "\n\n" + `{"id":"b","sku":2001,"name":"banana"}`, http.StatusOK, 11},
+		{`{"id":"a","sku":2000,"name":"apple"`, http.StatusBadRequest, 9},
+		{`{"name":"apple"}`, http.StatusBadRequest, 9},
+		{`{"id":"a","sku":2000,"name":""}`, http.StatusBadRequest, 9},
+	}
+
+	for _, st := range table {
+		d := new(mockDB)
+		a := app{
+			router: mux.NewRouter(),
+			db:     d,
+			noAuth: true,
+		}
+
+		d.preload()
+		a.addRoutes()
+
+		r := httptest.NewRequest("POST", "http://who-cares/admin/restore", strings.NewReader(st.body))
+		w := httptest.NewRecorder()
+
+		a.router.ServeHTTP(w, r)
+
+		if resp := w.Result(); resp.StatusCode != st.status {
+			t.Errorf("%s: wanted %d, got %d", st.body, st.status, resp.StatusCode)
+		}
+
+		if len(d.data) != st.items {
+			t.Errorf("%s: wanted %d items, got %d", st.body, st.items, len(d.data))
+		}
+
+		if st.status == http.StatusOK && d.next != 2002 {
+			t.Errorf("wanted next SKU 2002, got %d", d.next)
+		}
+	}
+}
+
+// TestBackupResourceWithMocks backs up a resource that
+// takes more than one page and restores it into another
+func TestBackupResourceWithMocks(t *testing.T) {
+	ctx := context.Background()
+	d, offers := new(mockDB), new(mockDB)
+
+	d.preload()
+
+	for n := 0; n <= backupPage; n++ {
+		if _, err := offers.AddItem(ctx, &model.Item{Name: fmt.Sprintf("offer %d", n)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a := app{
+		router: mux.NewRouter(),
+		db:     d,
+		noAuth: true,
+		resources: []*resource{
+			{path: "items", collection: "items", db: d},
+			{path: "offers", collection: "offers", db: offers},
+		},
+	}
+
+	a.addRoutes()
+
+	r := httptest.NewRequest("GET", "http://who-cares/admin/backup?resource=offers", nil)
+	w := httptest.NewRecorder()
+
+	a.router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK || offers.pages != 2 || d.pages != 0 {
+		t.Fatalf("backup: got %d after %d page reads", w.Code, offers.pages)
+	}
+
+	if cd := w.Header().Get("Content-Disposition"); !strings.Contains(cd, `"offers-`) {
+		t.Errorf("invalid disposition: %q", cd)
+	}
+
+	backup := w.Body.Bytes()
+	restored := new(mockDB)
+
+	restored.preload()
+	a.resources[1].db = restored
+
+	r = httptest.NewRequest("POST", "http://who-cares/admin/restore?resource=offers", bytes.NewReader(backup))
+	w = httptest.NewRecorder()
+
+	a.router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK || len(restored.data) != 9+backupPage+1 || len(d.data) != 9 {
+		t.Errorf("restore: got %d, %d offers", w.Code, len(restored.data))
+	}
+
+	r = httptest.NewRequest("GET", "http://who-cares/admin/backup?resource=nope", nil)
+	w = httptest.NewRecorder()
+
+	a.router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("unknown resource: wanted 400, got %d", w.Code)
+	}
+}
@@ -0,0 +1,242 @@
+// Package client is a Go client for the items service, so
+// programs don't have to build requests by hand
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"tutor4/graph/model"
+)
+
+// errors the service can return; use errors.Is, since
+// what's returned is an *Error with the details
+var (
+	ErrNotFound     = errors.New("not found")
+	ErrExists       = errors.New("already exists")
+	ErrInvalid      = errors.New("invalid request")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+	ErrUnavailable  = errors.New("service unavailable")
+)
+
+// kinds maps HTTP status codes to the errors above
+var kinds = map[int]error{
+	http.StatusNotFound:            ErrNotFound,
+	http.StatusConflict:            ErrExists,
+	http.StatusBadRequest:          ErrInvalid,
+	http.StatusUnprocessableEntity: ErrInvalid,
+	http.StatusUnauthorized:        ErrUnauthorized,
+	http.StatusForbidden:           ErrForbidden,
+	http.StatusTooManyRequests:     ErrUnavailable,
+	http.StatusServiceUnavailable:  ErrUnavailable,
+}
+
+// Error is a failed call; Code and Field are only set if
+// the service sent a structured error
+type Error struct {
+	Status  int
+	Code    string
+	Message string
+	Field   string
+}
+
+func (e *Error) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("%d %s: %s", e.Status, e.Code, e.Message)
+	}
+
+	return fmt.Sprintf("%d: %s", e.Status, e.Message)
+}
+
+func (e *Error) Is(target error) bool {
+	return kinds[e.Status] == target
+}
+
+// Client talks to one instance of the service; it's safe
+// for concurrent use
+type Client struct {
+	base string
+	hc   *http.Client
+	user string
+	pass string
+}
+
+// Option changes how New sets up the client
+type Option func(*Client)
+
+// WithBasicAuth sends the credentials with every request
+func WithBasicAuth(user, pass string) Option {
+	return func(c *Client) { c.user, c.pass = user, pass }
+}
+
+// WithHTTPClient uses hc rather than http.DefaultClient
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.hc = hc }
+}
+
+// New makes a client for the service at base, e.g.
+// https://items.example.com
+func New(base string, opts ...Option) *Client {
+	c := Client{base: strings.TrimSuffix(base, "/"), hc: http.DefaultClient}
+
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	return &c
+}
+
+// List returns all the items, in SKU order
+func (c *Client) List(ctx context.Context) ([]*model.Item, error) {
+	var items []*model.Item
+
+	if err := c.do(ctx, "GET", "/items", nil, &items, nil); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+func (c *Client) Get(ctx context.Context, id string) (*model.Item, error) {
+	var item model.Item
+
+	if err := c.do(ctx, "GET", "/items/"+url.PathEscape(id), nil, &item, nil); err != nil {
+		return nil, err
+	}
+
+	return &item, nil
+}
+
+func (c *Client) GetBySKU(ctx context.Context, sku int) (*model.Item, error) {
+	var item model.Item
+
+	if err := c.do(ctx, "GET", "/skus/"+strconv.Itoa(sku), nil, &item, nil); err != nil {
+		return nil, err
+	}
+
+	return &item, nil
+}
+
+// Create adds a new item, which must not have an ID or
+// SKU, and returns it as stored
+func (c *Client) Create(ctx context.Context, i *model.Item) (*model.Item, error) {
+	var item model.Item
+
+	if err := c.do(ctx, "POST", "/items", i, &item, nil); err != nil {
+		return nil, err
+	}
+
+	return &item, nil
+}
+
+// Update replaces the item with i.ID and returns it as
+// stored
+func (c *Client) Update(ctx context.Context, i *model.Item) (*model.Item, error) {
+	var item model.Item
+
+	hdr := http.Header{"Prefer": {"return=representation"}}
+
+	if err := c.do(ctx, "PUT", "/items/"+url.PathEscape(i.ID), i, &item, hdr); err != nil {
+		return nil, err
+	}
+
+	return &item, nil
+}
+
+// Delete removes an item; it's not an error if there's
+// no such item
+func (c *Client) Delete(ctx context.Context, id string) error {
+	return c.do(ctx, "DELETE", "/items/"+url.PathEscape(id), nil, nil, nil)
+}
+
+// do sends body (if any) as JSON and decodes the reply
+// into out (if any)
+func (c *Client) do(ctx context.Context, method, path string, body, out interface{}, hdr http.Header) error {
+	var rd io.Reader
+
+	if body != nil {
+		b, err := json.Marshal(body)
+
+		if err != nil {
+			return err
+		}
+
+		rd = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.base+path, rd)
+
+	if err != nil {
+		return err
+	}
+
+	for k, v := range hdr {
+		req.Header[k] = v
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	if c.user != "" {
+		req.SetBasicAuth(c.user, c.pass)
+	}
+
+	resp, err := c.hc.Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return readError(resp)
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	if err = json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("%s %s: %w", method, path, err)
+	}
+
+	return nil
+}
+
+// readError makes an *Error from a reply, which may be a
+// JSON error or just text
+func readError(resp *http.Response) error {
+	e := Error{Status: resp.StatusCode}
+
+	b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+
+	var body struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+			Field   string `json:"field"`
+		} `json:"error"`
+	}
+
+	if json.Unmarshal(b, &body) == nil && body.Error.Code != "" {
+		e.Code, e.Message, e.Field = body.Error.Code, body.Error.Message, body.Error.Field
+	} else {
+		e.Message = strings.TrimSpace(string(b))
+	}
+
+	return &e
+}
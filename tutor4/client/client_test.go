@@ -0,0 +1,86 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"tutor4/graph/model"
+	"tutor4/testutil"
+)
+
+// TestClient runs each call against the memory backend
+func TestClient(t *testing.T) {
+	s := testutil.NewTestServer(testutil.WithAuth(), testutil.WithItems("apple", "banana"))
+	defer s.Close()
+
+	ctx := context.Background()
+	c := New(s.URL, WithBasicAuth(testutil.User, testutil.Password), WithHTTPClient(s.Client()))
+
+	items, err := c.List(ctx)
+
+	if err != nil || len(items) != 2 {
+		t.Fatalf("list: %d items, %v", len(items), err)
+	}
+
+	item, err := c.Create(ctx, &model.Item{Name: "cherry"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if item.ID == "" || item.Sku != 1002 {
+		t.Errorf("create: invalid item: %#v", item)
+	}
+
+	got, err := c.GetBySKU(ctx, item.Sku)
+
+	if err != nil || got.ID != item.ID {
+		t.Errorf("get by SKU: %#v, %v", got, err)
+	}
+
+	item.Name = "cherries"
+
+	if got, err = c.Update(ctx, item); err != nil || got.Name != "cherries" {
+		t.Errorf("update: %#v, %v", got, err)
+	}
+
+	if got, err = c.Get(ctx, item.ID); err != nil || got.Name != "cherries" {
+		t.Errorf("get: %#v, %v", got, err)
+	}
+
+	if err = c.Delete(ctx, item.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = c.Get(ctx, item.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("get deleted: wanted %v, got %v", ErrNotFound, err)
+	}
+}
+
+// TestClientErrors maps failures to typed errors
+func TestClientErrors(t *testing.T) {
+	s := testutil.NewTestServer(testutil.WithAuth())
+	defer s.Close()
+
+	ctx := context.Background()
+	c := New(s.URL, WithBasicAuth(testutil.User, testutil.Password), WithHTTPClient(s.Client()))
+
+	_, err := c.Create(ctx, &model.Item{Name: ""})
+
+	var e *Error
+
+	if !errors.Is(err, ErrInvalid) || !errors.As(err, &e) || e.Code != "name_required" {
+		t.Errorf("empty name: wanted %v, got %v", ErrInvalid, err)
+	}
+
+	if _, err = c.GetBySKU(ctx, 9999); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing SKU: wanted %v, got %v", ErrNotFound, err)
+	}
+
+	anon := New(s.URL, WithHTTPClient(s.Client()))
+
+	if _, err = anon.List(ctx); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("no auth: wanted %v, got %v", ErrUnauthorized, err)
+	}
+}
@@ -0,0 +1,301 @@
+package tutor4
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"tutor4/db"
+	"tutor4/graph/model"
+)
+
+// a command runs with the args after its name and
+// returns the exit code
+type command func(args []string) int
+
+var commands = map[string]command{
+	"serve":   runServe,
+	"migrate": runMigrate,
+	"seed":    runSeed,
+	"export":  runExport,
+	"help":    runHelp,
+}
+
+// RunApp runs the command named by the first arg; with no
+// command (e.g. just flags) it runs the server, as it
+// always used to
+func RunApp(args []string) int {
+	cmd, args, err := dispatch(args)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return -2
+	}
+
+	return cmd(args)
+}
+
+func dispatch(args []string) (command, []string, error) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return runServe, args, nil
+	}
+
+	cmd, ok := commands[args[0]]
+
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown command %q (try help)", args[0])
+	}
+
+	return cmd, args[1:], nil
+}
+
+func runHelp(_ []string) int {
+	fmt.Fprintln(os.Stderr, "usage: tutor4 [serve|migrate|seed|export] [flags]")
+	fmt.Fprintln(os.Stderr, "use -h after a command to see its flags")
+	return 0
+}
+
+// dbCommand parses the DB flags and any others the command
+// adds, then connects to the DB
+func dbCommand(name string, args []string, more func(*flag.FlagSet)) (*app, error) {
+	var a app
+
+	fl := flag.NewFlagSet(name, flag.ContinueOnError)
+
+	a.dbFlags(fl)
+
+	if more != nil {
+		more(fl)
+	}
+
+	if err := fl.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if err := a.createClient(); err != nil {
+		return nil, err
+	}
+
+	return &a, nil
+}
+
+// closeDB closes every DB the command opened; the command
+// is done by then, so there's nothing to do but log
+func (a *app) closeDB() {
+	for _, d := range a.stores() {
+		if d == nil {
+			continue // a resource we failed to open
+		}
+
+		if err := d.Close(); err != nil {
+			log.Printf("close DB: %s", err)
+		}
+	}
+}
+
+// runMigrate fixes up stored data, for every resource,
+// and exits
+func runMigrate(args []string) int {
+	var resources string
+
+	a, err := dbCommand("migrate", args, func(fl *flag.FlagSet) {
+		fl.StringVar(&resources, "resources", "", resourcesUsage)
+	})
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return -2
+	}
+
+	defer a.closeDB()
+
+	if a.resources, err = parseResources(resources); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return -2
+	}
+
+	if err = a.openResources(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return -2
+	}
+
+	n, err := migrate(context.Background(), a.stores())
+
+	log.Printf("reindexed %d items", n)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return -1
+	}
+
+	return 0
+}
+
+// migrate checks each DB's SKU counter and recomputes its
+// name sort keys (e.g. after changing -collation); it
+// returns how many items were reindexed
+func migrate(ctx context.Context, stores []db.DB) (int, error) {
+	n := 0
+
+	for _, d := range stores {
+		fixed, err := d.ReconcileSKU(ctx)
+
+		if err != nil {
+			return n, fmt.Errorf("reconcile SKU: %w", err)
+		}
+
+		log.Printf("SKU counter checked, fixed: %t", fixed)
+
+		updated, err := d.Reindex(ctx)
+
+		if err != nil {
+			return n, fmt.Errorf("reindex: %w", err)
+		}
+
+		n += updated
+	}
+
+	return n, nil
+}
+
+// runSeed adds items from an NDJSON file (one item per
+// line, as export writes them); each gets a new ID and SKU
+func runSeed(args []string) int {
+	var file string
+
+	a, err := dbCommand("seed", args, func(fl *flag.FlagSet) {
+		fl.StringVar(&file, "file", "-", "NDJSON file to load (- = stdin)")
+	})
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return -2
+	}
+
+	defer a.closeDB()
+
+	in := os.Stdin
+
+	if file != "-" {
+		if in, err = os.Open(file); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return -2
+		}
+
+		defer in.Close()
+	}
+
+	n, err := seed(context.Background(), a.db, in)
+
+	log.Printf("seeded %d items", n)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return -1
+	}
+
+	return 0
+}
+
+func seed(ctx context.Context, d db.DB, r io.Reader) (int, error) {
+	n := 0
+	s := bufio.NewScanner(r)
+
+	for line := 1; s.Scan(); line++ {
+		if len(strings.TrimSpace(s.Text())) == 0 {
+			continue
+		}
+
+		var item model.Item
+
+		if err := json.Unmarshal(s.Bytes(), &item); err != nil {
+			return n, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		item.ID, item.Sku = "", 0
+
+		if err := item.Validate(); err != nil {
+			return n, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		if _, err := d.AddItem(ctx, &item); err != nil {
+			return n, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		n++
+	}
+
+	return n, s.Err()
+}
+
+// runExport writes every item as NDJSON
+func runExport(args []string) int {
+	var file string
+
+	a, err := dbCommand("export", args, func(fl *flag.FlagSet) {
+		fl.StringVar(&file, "out", "-", "file to write (- = stdout)")
+	})
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return -2
+	}
+
+	defer a.closeDB()
+
+	out := os.Stdout
+
+	if file != "-" {
+		if out, err = os.Create(file); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return -2
+		}
+	}
+
+	err = export(context.Background(), a.db, out)
+
+	if file != "-" {
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return -1
+	}
+
+	return 0
+}
+
+// export is run by an operator, who gets the secret fields
+// as an editor would, or seeding from it would lose them
+func export(ctx context.Context, d db.DB, w io.Writer) error {
+	items, err := d.ListItems(db.WithSecrets(ctx))
+
+	if err != nil {
+		return err
+	}
+
+	return writeItems(w, items)
+}
+
+// writeItems writes items as NDJSON, one per line
+func writeItems(w io.Writer, items []*model.Item) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+
+	for _, i := range items {
+		if err := enc.Encode(i); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
@@ -0,0 +1,188 @@
+package tutor4
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"tutor4/db"
+	"tutor4/graph/model"
+)
+
+// TestDispatch checks which command runs and what args
+// it gets; no command at all means serve
+func TestDispatch(t *testing.T) {
+	table := []struct {
+		args []string
+		cmd  command
+		rest []string
+	}{
+		{nil, runServe, nil},
+		{[]string{"-no-auth"}, runServe, []string{"-no-auth"}},
+		{[]string{"serve", "-debug"}, runServe, []string{"-debug"}},
+		{[]string{"migrate"}, runMigrate, []string{}},
+		{[]string{"seed", "-file", "x"}, runSeed, []string{"-file", "x"}},
+		{[]string{"export", "-out", "y"}, runExport, []string{"-out", "y"}},
+		{[]string{"bogus"}, nil, nil},
+	}
+
+	for _, tt := range table {
+		cmd, rest, err := dispatch(tt.args)
+
+		if tt.cmd == nil {
+			if err == nil {
+				t.Errorf("%v: wanted an error", tt.args)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%v: %s", tt.args, err)
+			continue
+		}
+
+		// funcs can't be compared, but their pointers can
+
+		if reflect.ValueOf(cmd).Pointer() != reflect.ValueOf(tt.cmd).Pointer() {
+			t.Errorf("%v: wrong command", tt.args)
+		}
+
+		if len(rest) != len(tt.rest) || (len(rest) > 0 && !reflect.DeepEqual(rest, tt.rest)) {
+			t.Errorf("%v: invalid args: %v", tt.args, rest)
+		}
+	}
+}
+
+// TestExportSeedWithMocks exports items and seeds them
+// into an empty DB, which gives them new IDs and SKUs
+func TestExportSeedWithMocks(t *testing.T) {
+	ctx := context.Background()
+	src := new(mockDB)
+
+	src.preload()
+
+	var buf bytes.Buffer
+
+	if err := export(ctx, src, &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := strings.Count(buf.String(), "\n"); n != 9 {
+		t.Errorf("wanted 9 lines, got %d", n)
+	}
+
+	dst := new(mockDB)
+	n, err := seed(ctx, dst, &buf)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 9 || len(dst.data) != 9 {
+		t.Errorf("seeded %d, stored %d", n, len(dst.data))
+	}
+
+	for id, i := range dst.data {
+		if _, ok := src.data[id]; ok {
+			t.Errorf("ID %s was reused", id)
+		}
+
+		if i.Name == "" {
+			t.Errorf("invalid item: %#v", i)
+		}
+	}
+
+	if _, err = seed(ctx, dst, strings.NewReader(`{"name":""}`)); err == nil {
+		t.Errorf("invalid item seeded")
+	}
+}
+
+// hidingDB clears secret fields from callers who can't see
+// them, as the Firestore client does
+type hidingDB struct {
+	db.DB
+}
+
+func (h hidingDB) ListItems(ctx context.Context) ([]*model.Item, error) {
+	items, err := h.DB.ListItems(ctx)
+
+	if db.CanSee(ctx) {
+		return items, err
+	}
+
+	result := make([]*model.Item, 0, len(items))
+
+	for _, i := range items {
+		c := *i
+		c.SupplierCost = ""
+		result = append(result, &c)
+	}
+
+	return result, err
+}
+
+// TestExportSecretsWithMocks exports the secret fields, so
+// seeding from the export keeps them
+func TestExportSecretsWithMocks(t *testing.T) {
+	ctx := context.Background()
+	src := new(mockDB)
+
+	if _, err := src.AddItem(ctx, &model.Item{Name: "costly", SupplierCost: "4.20"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+
+	if err := export(ctx, hidingDB{src}, &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(buf.String(), `"supplierCost":"4.20"`) {
+		t.Errorf("secret not exported: %s", buf.String())
+	}
+}
+
+// TestMigrateWithMocks expects migrate to fix the SKU
+// counter and reindex, in every DB
+func TestMigrateWithMocks(t *testing.T) {
+	d, other := new(mockDB), new(mockDB)
+
+	d.preload()
+	other.preload()
+	d.next, other.next = 1000, 1000
+
+	n, err := migrate(context.Background(), []db.DB{d, other})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d.next != 1009 || other.next != 1009 {
+		t.Errorf("invalid next SKUs: %d, %d", d.next, other.next)
+	}
+
+	if n != 18 {
+		t.Errorf("wanted 18 reindexed, got %d", n)
+	}
+}
+
+// TestCloseDBWithMocks closes the main DB and each
+// resource's, skipping any that wasn't opened
+func TestCloseDBWithMocks(t *testing.T) {
+	d, other := new(mockDB), new(mockDB)
+
+	a := app{db: d, resources: []*resource{
+		{path: "items", collection: "items", db: d},
+		{path: "offers", collection: "offers", db: other},
+		{path: "later", collection: "later"},
+	}}
+
+	a.closeDB()
+
+	if d.closed != 1 || other.closed != 1 {
+		t.Errorf("invalid closes: %d, %d", d.closed, other.closed)
+	}
+}
@@ -0,0 +1,46 @@
+package tutor4
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"time"
+)
+
+// secretFlags are left out of -print-config; a webhook URL
+// often has a token in it
+var secretFlags = map[string]bool{
+	"enc-key":     true,
+	"webhook-url": true,
+}
+
+// printConfig writes every setting as fromArgs left it,
+// defaults and all, as JSON, e.g. {"addr":":8080",...}; a
+// secret that's set is shown only as REDACTED
+func (a *app) printConfig(w io.Writer) error {
+	config := map[string]interface{}{}
+
+	a.flags.VisitAll(func(f *flag.Flag) {
+		var v interface{} = f.Value.String()
+
+		if g, ok := f.Value.(flag.Getter); ok {
+			v = g.Get()
+		}
+
+		switch val := v.(type) {
+		case time.Duration:
+			v = val.String()
+		case string:
+			if secretFlags[f.Name] && val != "" {
+				v = redacted
+			}
+		}
+
+		config[f.Name] = v
+	})
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(config)
+}
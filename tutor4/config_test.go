@@ -0,0 +1,62 @@
+package tutor4
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+// TestPrintConfig parses some flags and prints the config,
+// which must have what we set, the defaults for the rest,
+// and no secrets
+func TestPrintConfig(t *testing.T) {
+	var a app
+
+	args := []string{
+		"-print-config",
+		"-addr", ":9999",
+		"-rest-timeout", "3s",
+		"-enc-key", "c2VjcmV0LWtleS0xNi1ieQ==",
+		"-webhook-url", "https://hooks.example.com/T0/secret-token",
+	}
+
+	if err := a.fromArgs(args); err != nil {
+		t.Fatal(err)
+	}
+
+	if !a.showConfig {
+		t.Fatal("-print-config not set")
+	}
+
+	var buf bytes.Buffer
+
+	if err := a.printConfig(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var config map[string]interface{}
+
+	if err := json.Unmarshal(buf.Bytes(), &config); err != nil {
+		t.Fatalf("%s: %s", err, buf.String())
+	}
+
+	want := map[string]interface{}{
+		"addr":         ":9999",
+		"rest-timeout": "3s",
+		"max-bulk":     float64(500),
+		"keep-alive":   true,
+		"enc-key":      redacted,
+		"webhook-url":  redacted,
+		"emulator":     "",
+	}
+
+	for k, v := range want {
+		if config[k] != v {
+			t.Errorf("%s: wanted %v, got %v", k, v, config[k])
+		}
+	}
+
+	if bytes.Contains(buf.Bytes(), []byte("secret")) {
+		t.Errorf("secret printed: %s", buf.String())
+	}
+}
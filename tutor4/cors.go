@@ -0,0 +1,110 @@
+package tutor4
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// allowOrigin says what to put in Access-Control-Allow-Origin
+// for this request's origin, or "" if it's not allowed
+func (a *app) allowOrigin(origin string) string {
+	if a.corsCreds {
+		// with credentials we can't use *, so we must echo
+		// back the origin, but only if it's on the list
+
+		for _, o := range a.corsOrigins {
+			if o == origin {
+				return origin
+			}
+		}
+
+		return ""
+	}
+
+	for _, o := range a.corsOrigins {
+		if o == "*" {
+			return "*"
+		}
+
+		if o == origin {
+			return origin
+		}
+	}
+
+	return ""
+}
+
+func (a *app) cors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		allow := a.allowOrigin(origin)
+
+		if allow != "*" {
+			h.Add("Vary", "Origin")
+		}
+
+		if allow == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h.Set("Access-Control-Allow-Origin", allow)
+
+		if a.corsCreds {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		// a script can't read a header that isn't exposed,
+		// and a grid needs the total from a ranged or paged
+		// list
+
+		h.Set("Access-Control-Expose-Headers", "Content-Range, Link, X-API-Version, X-Total-Count, X-Page-Limit")
+
+		// a preflight never reaches the handler (and has
+		// no credentials, so it must come before auth)
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+
+			if req := r.Header.Get("Access-Control-Request-Headers"); req != "" {
+				h.Set("Access-Control-Allow-Headers", req)
+			}
+
+			if a.corsMaxAge > 0 {
+				h.Set("Access-Control-Max-Age", strconv.Itoa(int(a.corsMaxAge.Seconds())))
+			}
+
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *app) parseCORS(origins string) error {
+	if origins == "" {
+		return nil
+	}
+
+	a.corsOrigins = strings.Split(origins, ",")
+
+	if a.corsCreds {
+		for _, o := range a.corsOrigins {
+			if o == "*" {
+				return errors.New("-cors-credentials needs an explicit list of origins")
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,305 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/firestore"
+
+	"tutor4/graph/model"
+)
+
+// auditPrefix names the collection that keeps one
+// document per change to an item, after the collection
+// the items are in (e.g. audit-items), so each resource
+// has its own trail; nothing ever updates or deletes them
+const auditPrefix = "audit-"
+
+// the actions an audit entry records
+const (
+	AuditCreate  = "create"
+	AuditUpdate  = "update"
+	AuditDelete  = "delete"
+	AuditUpsert  = "upsert"
+	AuditRestore = "restore"
+)
+
+// AuditEntry says who changed an item, when, and how;
+// Before is nil for a create (or upsert or restore),
+// After for a delete
+type AuditEntry struct {
+	Action string      `json:"action" firestore:"action"`
+	ItemID string      `json:"itemId" firestore:"item_id"`
+	User   string      `json:"user" firestore:"user"`
+	At     time.Time   `json:"at" firestore:"at"`
+	Before *model.Item `json:"before" firestore:"before"`
+	After  *model.Item `json:"after" firestore:"after"`
+}
+
+// sortAudit puts an item's trail in time order; we sort in
+// memory rather than need another index
+func sortAudit(entries []*AuditEntry) {
+	sort.SliceStable(entries, func(x, y int) bool {
+		return entries[x].At.Before(entries[y].At)
+	})
+}
+
+// audits is where the client's audit entries go
+func (c *Client) audits() *firestore.CollectionRef {
+	return c.fs.Collection(auditPrefix + c.data.ID)
+}
+
+// logged is the audit entry for a change the client makes,
+// for it to write in the same transaction or batch as the
+// change; before and after are as stored, so their secrets
+// are sealed already
+func (c *Client) logged(ctx context.Context, action, itemID string, before, after *model.Item) (*firestore.DocumentRef, AuditEntry) {
+	return c.audits().NewDoc(), AuditEntry{action, itemID, userFrom(ctx), time.Now().UTC(), before, after}
+}
+
+// storedItem is an item as it's stored, for the audit
+// entry of a change to it; one that doesn't decode is left
+// out of the entry rather than block the change
+func storedItem(doc *firestore.DocumentSnapshot) *model.Item {
+	var i model.Item
+
+	if err := doc.DataTo(&i); err != nil {
+		log.Printf("ERROR audit %s decode: %s", doc.Ref.ID, err)
+		return nil
+	}
+
+	return &i
+}
+
+// auditsItself says the client writes an audit entry with
+// each change (see logged), so auditDB leaves it alone
+func (c *Client) auditsItself() {}
+
+func (c *Client) AuditLog(ctx context.Context, action, itemID, user string, before, after *model.Item) error {
+	// the log mustn't give away what the items hide
+
+	before, err := c.sealer.sealItem(before)
+
+	if err != nil {
+		return err
+	}
+
+	if after, err = c.sealer.sealItem(after); err != nil {
+		return err
+	}
+
+	e := AuditEntry{action, itemID, user, time.Now().UTC(), before, after}
+
+	_, _, err = c.audits().Add(ctx, e)
+
+	return err
+}
+
+func (c *Client) ListAudit(ctx context.Context, itemID string) ([]*AuditEntry, error) {
+	docs, err := c.audits().Where("item_id", "==", itemID).Documents(ctx).GetAll()
+
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*AuditEntry, 0, len(docs))
+
+	for _, doc := range docs {
+		var e AuditEntry
+
+		if err = doc.DataTo(&e); err != nil {
+			return nil, fmt.Errorf("audit %s decode: %w", doc.Ref.ID, err)
+		}
+
+		c.sealer.openItem(ctx, e.Before)
+		c.sealer.openItem(ctx, e.After)
+
+		result = append(result, &e)
+	}
+
+	sortAudit(result)
+
+	return result, nil
+}
+
+type userKey struct{}
+
+// WithUser records who is making the changes, for the
+// audit log
+func WithUser(ctx context.Context, user string) context.Context {
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+func userFrom(ctx context.Context) string {
+	user, _ := ctx.Value(userKey{}).(string)
+	return user
+}
+
+// auditDB writes an audit entry after each change to an
+// item, for a DB that doesn't audit itself (Client writes
+// the entry in the same transaction or batch as the change,
+// so a change is never made without one); the entry is a
+// separate write, so it can fail after the change has been
+// made, and a bulk change is audited an item at a time, so
+// it's only for DBs where that's cheap (e.g. Memory)
+//
+// by default a failure is just logged, but if failClosed is
+// set the error is returned, so the client is told the call
+// failed (even though the change stands) rather than a
+// change going unaudited without anyone noticing
+type auditDB struct {
+	DB
+	failClosed bool
+}
+
+// selfAuditing is a DB that writes its own audit entries
+type selfAuditing interface {
+	auditsItself()
+}
+
+// WithAudit wraps d so every change to an item is logged
+// with AuditLog, unless d does that itself
+func WithAudit(d DB, failClosed bool) DB {
+	if _, ok := d.(selfAuditing); ok {
+		return d
+	}
+
+	return &auditDB{DB: d, failClosed: failClosed}
+}
+
+func (a *auditDB) audit(ctx context.Context, action, itemID string, before, after *model.Item) error {
+	err := a.DB.AuditLog(ctx, action, itemID, userFrom(ctx), before, after)
+
+	if err == nil {
+		return nil
+	}
+
+	log.Printf("ERROR audit %s %s: %s", action, itemID, err)
+
+	if a.failClosed {
+		return fmt.Errorf("audit %s %s: %w", action, itemID, err)
+	}
+
+	return nil
+}
+
+// prior is the item before a change, if we can read it;
+// the change itself will fail if it's not there
+func (a *auditDB) prior(ctx context.Context, id string) (*model.Item, error) {
+	i, err := a.DB.GetItem(ctx, id)
+
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+
+	return i, err
+}
+
+func (a *auditDB) AddItem(ctx context.Context, i *model.Item) (string, error) {
+	id, err := a.DB.AddItem(ctx, i)
+
+	if err != nil {
+		return "", err
+	}
+
+	return id, a.audit(ctx, AuditCreate, id, nil, i)
+}
+
+func (a *auditDB) AddItems(ctx context.Context, items []*model.Item) (int, error) {
+	n, err := a.DB.AddItems(ctx, items)
+
+	// the items before the error (if any) were written
+
+	for _, i := range items[:n] {
+		if aerr := a.audit(ctx, AuditCreate, i.ID, nil, i); aerr != nil && err == nil {
+			err = aerr
+		}
+	}
+
+	return n, err
+}
+
+func (a *auditDB) ImportItems(ctx context.Context, items []*model.Item) (int, error) {
+	n, err := a.DB.ImportItems(ctx, items)
+
+	for _, i := range items[:n] {
+		if aerr := a.audit(ctx, AuditCreate, i.ID, nil, i); aerr != nil && err == nil {
+			err = aerr
+		}
+	}
+
+	return n, err
+}
+
+func (a *auditDB) CreateWithID(ctx context.Context, id string, i *model.Item) error {
+	if err := a.DB.CreateWithID(ctx, id, i); err != nil {
+		return err
+	}
+
+	return a.audit(ctx, AuditCreate, id, nil, i)
+}
+
+// Upsert doesn't tell us which items were new, or what the
+// old ones were, so each entry just has the item written
+func (a *auditDB) Upsert(ctx context.Context, items []*model.Item) (int, int, error) {
+	created, updated, err := a.DB.Upsert(ctx, items)
+
+	// the items before the error (if any) were written
+
+	for _, i := range items[:created+updated] {
+		if aerr := a.audit(ctx, AuditUpsert, i.ID, nil, i); aerr != nil && err == nil {
+			err = aerr
+		}
+	}
+
+	return created, updated, err
+}
+
+// Restore may replace items, but reading each one first
+// would double the cost of a big restore, so as for Upsert
+// the entry has just the item written
+func (a *auditDB) Restore(ctx context.Context, items []*model.Item) (int, error) {
+	n, err := a.DB.Restore(ctx, items)
+
+	for _, i := range items[:n] {
+		if aerr := a.audit(ctx, AuditRestore, i.ID, nil, i); aerr != nil && err == nil {
+			err = aerr
+		}
+	}
+
+	return n, err
+}
+
+func (a *auditDB) UpdateItem(ctx context.Context, i *model.Item) error {
+	before, err := a.prior(ctx, i.ID)
+
+	if err != nil {
+		return err
+	}
+
+	if err = a.DB.UpdateItem(ctx, i); err != nil {
+		return err
+	}
+
+	return a.audit(ctx, AuditUpdate, i.ID, before, i)
+}
+
+// DeleteItem of an item that isn't there changes nothing,
+// so there's nothing to audit
+func (a *auditDB) DeleteItem(ctx context.Context, id string) error {
+	before, err := a.prior(ctx, id)
+
+	if err != nil {
+		return err
+	}
+
+	if err = a.DB.DeleteItem(ctx, id); err != nil || before == nil {
+		return err
+	}
+
+	return a.audit(ctx, AuditDelete, id, before, nil)
+}
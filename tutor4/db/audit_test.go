@@ -0,0 +1,50 @@
+package db
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+)
+
+// TestWithAudit wraps a DB that doesn't audit its own
+// changes, and leaves one that does alone
+func TestWithAudit(t *testing.T) {
+	m := NewMemory()
+
+	if d, ok := WithAudit(m, true).(*auditDB); !ok || d.DB != m || !d.failClosed {
+		t.Errorf("memory not wrapped: %#v", d)
+	}
+
+	c := new(Client)
+
+	if d := WithAudit(c, true); d != c {
+		t.Errorf("client wrapped: %#v", d)
+	}
+}
+
+// TestAuditCollection keeps each resource's trail apart;
+// the client never connects, so no emulator is needed
+func TestAuditCollection(t *testing.T) {
+	if os.Getenv("FIRESTORE_EMULATOR_HOST") == "" {
+		os.Setenv("FIRESTORE_EMULATOR_HOST", "localhost:0")
+		defer os.Unsetenv("FIRESTORE_EMULATOR_HOST")
+	}
+
+	fs, err := firestore.NewClient(context.Background(), "test")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer fs.Close()
+
+	for data, audit := range map[string]string{"items": "audit-items", "offers": "audit-offers"} {
+		c := Client{fs: fs, data: fs.Collection(data)}
+
+		if id := c.audits().ID; id != audit {
+			t.Errorf("%s: wanted %s, got %s", data, audit, id)
+		}
+	}
+}
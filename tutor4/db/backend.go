@@ -0,0 +1,119 @@
+package db
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Opener makes a backend from its options, which are just
+// strings (from flags) so any backend can take its own
+type Opener func(opts map[string]string) (DB, error)
+
+var (
+	backendsMu sync.Mutex
+	backends   = make(map[string]Opener)
+)
+
+// Register makes a backend available to Open by name; it
+// panics if the name is taken, like database/sql does
+func Register(name string, open Opener) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+
+	if _, ok := backends[name]; ok {
+		panic("db: backend registered twice: " + name)
+	}
+
+	backends[name] = open
+}
+
+// Backends lists the registered backends by name
+func Backends() []string {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+
+	names := make([]string, 0, len(backends))
+
+	for name := range backends {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// Open creates a backend by the name it was registered as
+func Open(name string, opts map[string]string) (DB, error) {
+	backendsMu.Lock()
+	open, ok := backends[name]
+	backendsMu.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown backend %q (have %s)", name, strings.Join(Backends(), ", "))
+	}
+
+	return open(opts)
+}
+
+func init() {
+	Register("firestore", openFirestore)
+	Register("memory", openMemory)
+}
+
+// skuStart reads the skuStart option, which is the first
+// SKU of a new database
+func skuStart(opts map[string]string) (int, error) {
+	s := opts["skuStart"]
+
+	if s == "" {
+		return DefaultStartSKU, nil
+	}
+
+	start, err := strconv.Atoi(s)
+
+	if err != nil {
+		return 0, fmt.Errorf("invalid SKU start %q", s)
+	}
+
+	return start, nil
+}
+
+// openMemory takes only skuStart
+func openMemory(opts map[string]string) (DB, error) {
+	start, err := skuStart(opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NewMemoryAt(start)
+}
+
+// openFirestore takes project, data and util (collection
+// names) and optionally emulator (host:port) and skuStart
+func openFirestore(opts map[string]string) (DB, error) {
+	// the Firestore client only looks at the env var, so we
+	// must set it before the client is created
+
+	if e := opts["emulator"]; e != "" {
+		if err := os.Setenv("FIRESTORE_EMULATOR_HOST", e); err != nil {
+			return nil, err
+		}
+
+		log.Println("USING FIRESTORE EMULATOR AT", e)
+	}
+
+	start, err := skuStart(opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NewClient(opts["project"], opts["data"], opts["util"], start)
+}
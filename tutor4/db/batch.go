@@ -0,0 +1,130 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"cloud.google.com/go/firestore"
+
+	"tutor4/graph/model"
+)
+
+// MaxGetItems is the most IDs one GetItems call takes
+const MaxGetItems = 500
+
+// ItemBatch is what GetItems read: the items it could, in
+// the order asked for, and why it couldn't read the rest
+type ItemBatch struct {
+	Items  []*model.Item `json:"items"`
+	Errors []ItemError   `json:"errors"`
+}
+
+// ItemError is an item GetItems couldn't read, e.g. one
+// that isn't there or doesn't decode
+type ItemError struct {
+	ID    string `json:"id"`
+	Error string `json:"error"`
+}
+
+// SKUBatch is what GetItemsBySKUs read: the item with each
+// SKU it found, and an error for each SKU that's on more
+// than one item (see ErrAmbiguous); a SKU that isn't found
+// is in neither
+type SKUBatch struct {
+	Items  map[int]*model.Item
+	Errors map[int]error
+}
+
+// bySKU sorts the items found for a batch of SKUs, which
+// must be in ID order; a SKU found more than once is an
+// error, unless the caller takes the first (WithAnyMatch)
+func bySKU(ctx context.Context, found []*model.Item) *SKUBatch {
+	result := SKUBatch{Items: map[int]*model.Item{}, Errors: map[int]error{}}
+	ids := map[int][]string{}
+
+	for _, i := range found {
+		if _, ok := result.Items[i.Sku]; !ok {
+			result.Items[i.Sku] = i
+		}
+
+		ids[i.Sku] = append(ids[i.Sku], i.ID)
+	}
+
+	if AnyMatch(ctx) {
+		return &result
+	}
+
+	for sku, these := range ids {
+		if len(these) > 1 {
+			delete(result.Items, sku)
+			result.Errors[sku] = ambiguous(sku, these)
+		}
+	}
+
+	return &result
+}
+
+func checkGetItems(ids []string) error {
+	if len(ids) > MaxGetItems {
+		return fmt.Errorf("can't get %d items (max %d)", len(ids), MaxGetItems)
+	}
+
+	return nil
+}
+
+// collectItems reads each ID with get, which reports
+// whether the item is there; unlike a list, a bad document
+// isn't just skipped, and doesn't fail the rest
+func collectItems(ids []string, get func(n int, i *model.Item) (bool, error)) *ItemBatch {
+	result := ItemBatch{Items: []*model.Item{}, Errors: []ItemError{}}
+
+	for n, id := range ids {
+		var i model.Item
+
+		found, err := get(n, &i)
+
+		switch {
+		case err != nil:
+			result.Errors = append(result.Errors, ItemError{id, "decode: " + err.Error()})
+		case !found:
+			result.Errors = append(result.Errors, ItemError{id, ErrNotFound.Error()})
+		default:
+			result.Items = append(result.Items, &i)
+		}
+	}
+
+	return &result
+}
+
+// GetItems reads items by ID in one round trip
+func (c *Client) GetItems(ctx context.Context, ids []string) (*ItemBatch, error) {
+	if err := checkGetItems(ids); err != nil {
+		return nil, err
+	}
+
+	refs := make([]*firestore.DocumentRef, len(ids))
+
+	for n, id := range ids {
+		refs[n] = c.data.Doc(id)
+	}
+
+	docs, err := c.fs.GetAll(ctx, refs)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return collectItems(ids, func(n int, i *model.Item) (bool, error) {
+		if !docs[n].Exists() {
+			return false, nil
+		}
+
+		if err := docs[n].DataTo(i); err != nil {
+			return true, err
+		}
+
+		c.sealer.openItem(ctx, i)
+
+		return true, nil
+	}), nil
+}
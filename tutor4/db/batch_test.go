@@ -0,0 +1,75 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"tutor4/graph/model"
+)
+
+// TestCollectItems reads a bad document among good ones,
+// and one that isn't there
+func TestCollectItems(t *testing.T) {
+	ids := []string{"a", "bad", "b", "gone", "c"}
+
+	batch := collectItems(ids, func(n int, i *model.Item) (bool, error) {
+		switch ids[n] {
+		case "bad":
+			return true, errors.New("cannot set type string")
+		case "gone":
+			return false, nil
+		}
+
+		i.ID, i.Name = ids[n], "item "+ids[n]
+		return true, nil
+	})
+
+	var got []string
+
+	for _, i := range batch.Items {
+		got = append(got, i.ID)
+	}
+
+	if s := strings.Join(got, ","); s != "a,b,c" {
+		t.Errorf("wanted a,b,c, got %s", s)
+	}
+
+	if len(batch.Errors) != 2 {
+		t.Fatalf("wanted 2 errors, got %v", batch.Errors)
+	}
+
+	if e := batch.Errors[0]; e.ID != "bad" || !strings.HasPrefix(e.Error, "decode: ") {
+		t.Errorf("invalid error: %#v", e)
+	}
+
+	if e := batch.Errors[1]; e.ID != "gone" || e.Error != ErrNotFound.Error() {
+		t.Errorf("invalid error: %#v", e)
+	}
+}
+
+// TestMemoryGetItems reads some items by ID
+func TestMemoryGetItems(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	i := &model.Item{Name: "apple"}
+
+	if _, err := m.AddItem(ctx, i); err != nil {
+		t.Fatal(err)
+	}
+
+	batch, err := m.GetItems(ctx, []string{i.ID, "nope"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(batch.Items) != 1 || batch.Items[0].Name != "apple" || len(batch.Errors) != 1 {
+		t.Errorf("invalid batch: %#v", batch)
+	}
+
+	if _, err = m.GetItems(ctx, make([]string, MaxGetItems+1)); err == nil {
+		t.Errorf("too many IDs allowed")
+	}
+}
@@ -0,0 +1,397 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"cloud.google.com/go/firestore"
+	"github.com/google/uuid"
+
+	"tutor4/graph/model"
+)
+
+// maxBatch is the most writes Firestore takes in one batch
+const maxBatch = 500
+
+// AddItems adds a batch of new items with consecutive SKUs
+// and returns how many were written
+//
+// the SKUs (and change numbers) are reserved up front in one
+// transaction, and the items are then written, with their
+// audit entries, in batches of up to maxBatch writes; each
+// batch is atomic but the whole isn't
+//
+// if a batch fails, the items before it were written and
+// the rest weren't (the count says where it stopped), and
+// the SKUs set aside for the rest are never used; if the
+// reservation fails, nothing was written or used up
+func (c *Client) AddItems(ctx context.Context, items []*model.Item) (int, error) {
+	if len(items) == 0 {
+		return 0, nil
+	}
+
+	// a batch that would fail for want of a key shouldn't
+	// use up SKUs first
+
+	if c.sealer == nil {
+		for _, i := range items {
+			if _, err := c.sealer.sealItem(i); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	sku, seq, err := c.reserve(ctx, len(items))
+
+	if err != nil {
+		return 0, err
+	}
+
+	for n, i := range items {
+		i.Sku, i.Seq = sku+n, seq+n
+
+		if c.skuIDs {
+			i.ID = skuID(i.Sku)
+		} else {
+			i.ID = uuid.New().String()
+		}
+	}
+
+	// each item is two writes, itself and its audit entry
+
+	return writeChunks(items, maxBatch/2, func(chunk []*model.Item) error {
+		b := c.fs.Batch()
+
+		// Create fails the batch rather than overwrite an
+		// item that's somehow there already
+
+		for _, i := range chunk {
+			stored, err := c.stored(i)
+
+			if err != nil {
+				return err
+			}
+
+			b.Create(c.data.Doc(i.ID), stored)
+			b.Create(c.logged(ctx, AuditCreate, i.ID, nil, stored))
+		}
+
+		_, err := b.Commit(ctx)
+		return err
+	})
+}
+
+// Restore writes items from a backup as they were, with
+// their IDs and SKUs, replacing any item with the same ID;
+// each gets a new change number so sync clients see it, and
+// any tombstone it left when deleted is removed, or they'd
+// see it as both deleted and there
+//
+// it doesn't move the SKU counter, so call ReconcileSKU
+// when the whole backup is in; as with AddItems, a failed
+// batch leaves the ones before it written
+func (c *Client) Restore(ctx context.Context, items []*model.Item) (int, error) {
+	if len(items) == 0 {
+		return 0, nil
+	}
+
+	if err := checkRestore(items); err != nil {
+		return 0, err
+	}
+
+	var seq int
+
+	err := c.fs.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) (err error) {
+		seq, err = c.claimSeqs(tx, len(items))
+		return err
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	for n, i := range items {
+		i.Seq = seq + n
+	}
+
+	// each item is three writes: itself, its tombstone and
+	// its audit entry (which, as for the auditDB wrapper,
+	// has just the item written; reading each one first
+	// would double the cost of a big restore)
+
+	return writeChunks(items, maxBatch/3, func(chunk []*model.Item) error {
+		b := c.fs.Batch()
+
+		for _, i := range chunk {
+			stored, err := c.stored(i)
+
+			if err != nil {
+				return err
+			}
+
+			b.Set(c.data.Doc(i.ID), stored)
+			b.Delete(c.tombRef(i.ID))
+			b.Create(c.logged(ctx, AuditRestore, i.ID, nil, stored))
+		}
+
+		_, err := b.Commit(ctx)
+		return err
+	})
+}
+
+// checkRestore rejects items that didn't come from a
+// backup: each must have an ID and a SKU we could have
+// handed out
+func checkRestore(items []*model.Item) error {
+	for n, i := range items {
+		if i.ID == "" {
+			return fmt.Errorf("item %d: no ID", n)
+		}
+
+		if i.Sku < 1 || i.Sku > MaxSKU {
+			return fmt.Errorf("item %s: invalid SKU %d", i.ID, i.Sku)
+		}
+	}
+
+	return nil
+}
+
+// ImportItems adds new items that already have SKUs, e.g.
+// from another system, keeping them; it fails with
+// ErrDuplicate if any SKU is taken, and moves the counter
+// past the highest SKU so we won't hand one out again
+//
+// the SKUs are all checked up front, so a taken one fails
+// the batch before anything's written; then each chunk is
+// written in a transaction that checks its SKUs again, so
+// two imports of the same SKU at once can't both win; as
+// with AddItems, a chunk that fails leaves the ones before
+// it written
+//
+// a SKU below the counter that SKUBatched has set aside but
+// not yet used isn't taken, so don't import below the
+// counter while it's running
+func (c *Client) ImportItems(ctx context.Context, items []*model.Item) (int, error) {
+	if len(items) == 0 {
+		return 0, nil
+	}
+
+	if err := checkImport(items); err != nil {
+		return 0, err
+	}
+
+	if c.sealer == nil {
+		for _, i := range items {
+			if _, err := c.sealer.sealItem(i); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	err := c.findSKUs(items, func(q firestore.Query) ([]*firestore.DocumentSnapshot, error) {
+		return q.Documents(ctx).GetAll()
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	// each item is three writes: itself, its tombstone and
+	// its audit entry; each transaction also writes the
+	// counter and the change number
+
+	return writeChunks(items, (maxBatch-2)/3, func(chunk []*model.Item) error {
+		return c.fs.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+			return c.importChunk(ctx, tx, chunk)
+		})
+	})
+}
+
+// importChunk writes items with their own SKUs in tx, if
+// none of the SKUs is taken
+func (c *Client) importChunk(ctx context.Context, tx *firestore.Transaction, items []*model.Item) error {
+	seqRef := c.util.Doc(skuDoc)
+	next, err := getNext(seqRef, tx)
+
+	if err != nil {
+		return err
+	}
+
+	err = c.findSKUs(items, func(q firestore.Query) ([]*firestore.DocumentSnapshot, error) {
+		return tx.Documents(q).GetAll()
+	})
+
+	if err != nil {
+		return err
+	}
+
+	seq, err := c.claimSeqs(tx, len(items))
+
+	if err != nil {
+		return err
+	}
+
+	last := 0
+
+	for n, i := range items {
+		i.Seq = seq + n
+
+		if c.skuIDs {
+			i.ID = skuID(i.Sku)
+		} else {
+			i.ID = uuid.New().String()
+		}
+
+		stored, err := c.stored(i)
+
+		if err != nil {
+			return err
+		}
+
+		if err = tx.Create(c.data.Doc(i.ID), stored); err != nil {
+			return err
+		}
+
+		// with -id-scheme=sku the ID may be one that was
+		// deleted, and sync clients mustn't see it as both
+		// deleted and there
+
+		if err = tx.Delete(c.tombRef(i.ID)); err != nil {
+			return err
+		}
+
+		if err = tx.Create(c.logged(ctx, AuditCreate, i.ID, nil, stored)); err != nil {
+			return err
+		}
+
+		if i.Sku > last {
+			last = i.Sku
+		}
+	}
+
+	if last < next {
+		return nil
+	}
+
+	return tx.Update(seqRef, []firestore.Update{{Path: nextField, Value: last + 1}})
+}
+
+// findSKUs fails with ErrDuplicate if an item already has
+// any of the items' SKUs; get runs each query, in or out
+// of a transaction
+func (c *Client) findSKUs(items []*model.Item, get func(firestore.Query) ([]*firestore.DocumentSnapshot, error)) error {
+	skus := make([]int, len(items))
+
+	for n, i := range items {
+		skus[n] = i.Sku
+	}
+
+	for len(skus) > 0 {
+		n := len(skus)
+
+		if n > maxIn {
+			n = maxIn
+		}
+
+		docs, err := get(c.data.Where("sku", "in", skus[:n]))
+
+		if err != nil {
+			return err
+		}
+
+		if len(docs) > 0 {
+			sku, _ := docs[0].DataAt("sku")
+			return fmt.Errorf("SKU %v: %w", sku, ErrDuplicate)
+		}
+
+		skus = skus[n:]
+	}
+
+	return nil
+}
+
+// checkImport rejects a batch we couldn't import: each item
+// must have a SKU we could have handed out, and no other
+// item in the batch may have it too
+func checkImport(items []*model.Item) error {
+	if err := checkBlock(len(items)); err != nil {
+		return err
+	}
+
+	seen := make(map[int]bool, len(items))
+
+	for n, i := range items {
+		if i.Sku < 1 || i.Sku > MaxSKU {
+			return fmt.Errorf("item %d: invalid SKU %d", n, i.Sku)
+		}
+
+		if seen[i.Sku] {
+			return fmt.Errorf("item %d: SKU %d is in the batch twice: %w", n, i.Sku, ErrDuplicate)
+		}
+
+		seen[i.Sku] = true
+	}
+
+	return nil
+}
+
+// reserve sets aside n SKUs and n change numbers, returning
+// the first of each
+func (c *Client) reserve(ctx context.Context, n int) (sku, seq int, err error) {
+	if err = checkBlock(n); err != nil {
+		return 0, 0, err
+	}
+
+	seqRef := c.util.Doc(skuDoc)
+
+	err = c.fs.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		next, err := getNext(seqRef, tx)
+
+		if err != nil {
+			return err
+		}
+
+		if err = checkSKU(next + n - 1); err != nil {
+			return err
+		}
+
+		if seq, err = c.claimSeqs(tx, n); err != nil {
+			return err
+		}
+
+		sku = next
+
+		return tx.Update(seqRef, []firestore.Update{{Path: nextField, Value: next + n}})
+	})
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	c.took(n)
+
+	return sku, seq, nil
+}
+
+// writeChunks calls write for each run of up to size items
+// in order, stopping at the first error; it returns how many
+// items were in the chunks that succeeded
+func writeChunks(items []*model.Item, size int, write func([]*model.Item) error) (int, error) {
+	done := 0
+
+	for done < len(items) {
+		end := done + size
+
+		if end > len(items) {
+			end = len(items)
+		}
+
+		if err := write(items[done:end]); err != nil {
+			return done, fmt.Errorf("items %d to %d: %w", done, end-1, err)
+		}
+
+		done = end
+	}
+
+	return done, nil
+}
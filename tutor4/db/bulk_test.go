@@ -0,0 +1,56 @@
+package db
+
+import (
+	"errors"
+	"testing"
+
+	"tutor4/graph/model"
+)
+
+// TestWriteChunks splits a batch bigger than one write and
+// counts only the chunks written before a failure
+func TestWriteChunks(t *testing.T) {
+	items := make([]*model.Item, 2*maxBatch+1)
+
+	for n := range items {
+		items[n] = &model.Item{Sku: n}
+	}
+
+	var sizes []int
+
+	n, err := writeChunks(items, maxBatch, func(chunk []*model.Item) error {
+		if chunk[0].Sku != len(sizes)*maxBatch {
+			t.Errorf("chunk %d out of order: starts at %d", len(sizes), chunk[0].Sku)
+		}
+
+		sizes = append(sizes, len(chunk))
+		return nil
+	})
+
+	if err != nil || n != len(items) {
+		t.Fatalf("wrote %d: %v", n, err)
+	}
+
+	if len(sizes) != 3 || sizes[0] != maxBatch || sizes[1] != maxBatch || sizes[2] != 1 {
+		t.Errorf("invalid chunks: %v", sizes)
+	}
+
+	errFail := errors.New("commit failed")
+	calls := 0
+
+	n, err = writeChunks(items, maxBatch, func([]*model.Item) error {
+		if calls++; calls == 2 {
+			return errFail
+		}
+
+		return nil
+	})
+
+	if !errors.Is(err, errFail) || n != maxBatch {
+		t.Errorf("wanted %d written and %v, got %d and %v", maxBatch, errFail, n, err)
+	}
+
+	if calls != 2 {
+		t.Errorf("kept writing after a failure: %d calls", calls)
+	}
+}
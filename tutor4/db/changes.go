@@ -0,0 +1,138 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"tutor4/graph/model"
+)
+
+// every write to an item takes the next change number from
+// the counter in the util collection and stores it in the
+// item; a delete leaves a tombstone there instead, so sync
+// clients can learn about it
+const (
+	seqDoc     = "Next$Seq"
+	tombPrefix = "Deleted$"
+	tombSeq    = "deleted_seq"
+)
+
+// Changes is everything written since some change number;
+// NextSeq is what to ask for next time
+type Changes struct {
+	Items   []*model.Item `json:"items"`
+	Deleted []string      `json:"deleted"`
+	NextSeq int           `json:"nextSeq"`
+}
+
+type tombstone struct {
+	ID  string `firestore:"id"`
+	Seq int    `firestore:"deleted_seq"`
+}
+
+// claimSeq takes the next change number; it must be the
+// last read in the transaction, since it also writes
+func (c *Client) claimSeq(tx *firestore.Transaction) (int, error) {
+	return c.claimSeqs(tx, 1)
+}
+
+// claimSeqs takes n change numbers and returns the first
+func (c *Client) claimSeqs(tx *firestore.Transaction, n int) (int, error) {
+	ref := c.util.Doc(seqDoc)
+	seq := 1
+
+	doc, err := tx.Get(ref)
+
+	switch {
+	case status.Code(err) == codes.NotFound:
+		// the first write makes the counter
+	case err != nil:
+		return 0, err
+	default:
+		val, ok := doc.Data()[nextField].(int64)
+
+		if !ok {
+			return 0, fmt.Errorf("can't read %s %s", seqDoc, nextField)
+		}
+
+		seq = int(val)
+	}
+
+	if err = tx.Set(ref, map[string]interface{}{nextField: seq + n}); err != nil {
+		return 0, err
+	}
+
+	return seq, nil
+}
+
+// LastSeq is the change number of the last write, or 0 if
+// there hasn't been one; it's one read, so it's a cheap
+// way to tell whether anything has changed
+func (c *Client) LastSeq(ctx context.Context) (int, error) {
+	doc, err := c.util.Doc(seqDoc).Get(ctx)
+
+	switch {
+	case status.Code(err) == codes.NotFound:
+		return 0, nil
+	case err != nil:
+		return 0, err
+	}
+
+	val, ok := doc.Data()[nextField].(int64)
+
+	if !ok {
+		return 0, fmt.Errorf("can't read %s %s", seqDoc, nextField)
+	}
+
+	return int(val) - 1, nil
+}
+
+func (c *Client) tombRef(id string) *firestore.DocumentRef {
+	return c.util.Doc(tombPrefix + id)
+}
+
+// ListChanges returns the items written and deleted after
+// change number since; items written before we kept
+// change numbers don't have one, so a new client should
+// list all the items first and then ask for changes
+func (c *Client) ListChanges(ctx context.Context, since int) (*Changes, error) {
+	items, err := c.list(ctx, c.data.Where("seq", ">", since).OrderBy("seq", firestore.Asc))
+
+	if err != nil {
+		return nil, err
+	}
+
+	docs, err := c.util.Where(tombSeq, ">", since).Documents(ctx).GetAll()
+
+	if err != nil {
+		return nil, err
+	}
+
+	result := Changes{Items: items, Deleted: make([]string, 0, len(docs)), NextSeq: since}
+
+	for _, i := range items {
+		if i.Seq > result.NextSeq {
+			result.NextSeq = i.Seq
+		}
+	}
+
+	for _, doc := range docs {
+		var t tombstone
+
+		if err = doc.DataTo(&t); err != nil {
+			return nil, fmt.Errorf("tombstone %s decode: %w", doc.Ref.ID, err)
+		}
+
+		result.Deleted = append(result.Deleted, t.ID)
+
+		if t.Seq > result.NextSeq {
+			result.NextSeq = t.Seq
+		}
+	}
+
+	return &result, nil
+}
@@ -0,0 +1,83 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"tutor4/graph/model"
+)
+
+// coalesceDB lets concurrent GetItem calls for the same ID
+// share one read, so a popular item doesn't stampede the
+// database; callers get the same *Item and must not
+// modify it
+type coalesceDB struct {
+	DB
+	group singleflight.Group
+}
+
+// coalesceTimeout bounds a shared read, since it can't
+// use any one caller's deadline
+const coalesceTimeout = 10 * time.Second
+
+func WithCoalescing(d DB) DB {
+	return &coalesceDB{DB: d}
+}
+
+type freshKey struct{}
+
+// WithFresh makes GetItem read for this caller alone; a
+// shared read may have started before the caller's last
+// write, so a read-modify-write must not join one
+func WithFresh(ctx context.Context) context.Context {
+	return context.WithValue(ctx, freshKey{}, true)
+}
+
+// Fresh reports whether the caller needs its own read
+// (see WithFresh)
+func Fresh(ctx context.Context) bool {
+	ok, _ := ctx.Value(freshKey{}).(bool)
+	return ok
+}
+
+// GetItem uses a context that isn't tied to the first
+// caller, or one of them giving up would fail them all
+func (c *coalesceDB) GetItem(ctx context.Context, id string) (*model.Item, error) {
+	if Fresh(ctx) {
+		return c.DB.GetItem(ctx, id)
+	}
+
+	// callers who may see secret fields can only share
+	// with each other
+
+	key, see := id, CanSee(ctx)
+
+	if see {
+		key = "+" + id
+	}
+
+	ch := c.group.DoChan(key, func() (interface{}, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), coalesceTimeout)
+		defer cancel()
+
+		if see {
+			ctx = WithSecrets(ctx)
+		}
+
+		return c.DB.GetItem(ctx, id)
+	})
+
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+
+		return res.Val.(*model.Item), nil
+
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,70 @@
+package db
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCoalescing(t *testing.T) {
+	c := &countingDB{delay: 50 * time.Millisecond}
+	d := WithCoalescing(c)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			if i, err := d.GetItem(context.Background(), "x"); err != nil || i.ID != "x" {
+				t.Errorf("invalid result: %v %v", i, err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&c.gets); n != 1 {
+		t.Errorf("wanted 1 read, got %d", n)
+	}
+
+	// once that's done, the next call reads again
+
+	if _, err := d.GetItem(context.Background(), "x"); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := atomic.LoadInt32(&c.gets); n != 2 {
+		t.Errorf("wanted 2 reads, got %d", n)
+	}
+}
+
+// TestFreshRead doesn't join a read already in flight,
+// which may not see the caller's last write
+func TestFreshRead(t *testing.T) {
+	c := &countingDB{delay: 50 * time.Millisecond}
+	d := WithCoalescing(c)
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		_, _ = d.GetItem(context.Background(), "x")
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+
+	if _, err := d.GetItem(WithFresh(context.Background()), "x"); err != nil {
+		t.Fatal(err)
+	}
+
+	<-done
+
+	if n := atomic.LoadInt32(&c.gets); n != 2 {
+		t.Errorf("wanted 2 reads, got %d", n)
+	}
+}
@@ -0,0 +1,110 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"cloud.google.com/go/firestore"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+
+	"tutor4/graph/model"
+)
+
+// Firestore orders strings by their UTF-8 bytes, so "Zebra"
+// comes before "apple"; to list by name we store a sort key
+// alongside the name, and order on that
+//
+// the collations we can make sort keys with
+const (
+	CollateBinary = "binary" // the name as is
+	CollateNoCase = "nocase" // ignoring case
+	CollateFold   = "fold"   // ignoring case and accents
+)
+
+func checkCollation(c string) error {
+	if c != CollateBinary && c != CollateNoCase && c != CollateFold {
+		return fmt.Errorf("invalid collation: %s", c)
+	}
+
+	return nil
+}
+
+var folder = cases.Fold()
+
+// sortKey is what we store as name_sort for a name
+func sortKey(name, collation string) string {
+	switch collation {
+	case CollateNoCase:
+		return folder.String(norm.NFC.String(name))
+
+	case CollateFold:
+		// take the accents off as separate marks, then
+		// drop them
+
+		s := norm.NFKD.String(name)
+
+		s = strings.Map(func(r rune) rune {
+			if unicode.Is(unicode.Mn, r) {
+				return -1
+			}
+
+			return r
+		}, s)
+
+		return folder.String(norm.NFC.String(s))
+	}
+
+	return name
+}
+
+// Reindex sets name_sort on every item whose key isn't
+// what the current collation makes, e.g. items written
+// before it changed (or before we had name_sort); it's
+// not an edit, so it doesn't take a change number
+//
+// it returns how many items it fixed; if a batch fails,
+// the ones before it stay fixed and running it again
+// picks up the rest
+func (c *Client) Reindex(ctx context.Context) (int, error) {
+	docs, err := c.data.Select("name", "name_sort").Documents(ctx).GetAll()
+
+	if err != nil {
+		return 0, err
+	}
+
+	var stale []*model.Item
+
+	skips := skipLog{what: "Reindex"}
+
+	defer skips.done()
+
+	for _, doc := range docs {
+		var i model.Item
+
+		err = doc.DataTo(&i)
+		skips.add(doc.Ref.ID, err)
+
+		if err != nil {
+			continue
+		}
+
+		if key := sortKey(i.Name, c.collation); key != i.NameSort {
+			i.ID, i.NameSort = doc.Ref.ID, key
+			stale = append(stale, &i)
+		}
+	}
+
+	return writeChunks(stale, maxBatch, func(chunk []*model.Item) error {
+		b := c.fs.Batch()
+
+		for _, i := range chunk {
+			b.Update(c.data.Doc(i.ID), []firestore.Update{{Path: "name_sort", Value: i.NameSort}})
+		}
+
+		_, err := b.Commit(ctx)
+		return err
+	})
+}
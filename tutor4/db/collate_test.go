@@ -0,0 +1,164 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"tutor4/graph/model"
+)
+
+// TestSortKey checks what each collation does to a name
+func TestSortKey(t *testing.T) {
+	table := []struct {
+		name      string
+		collation string
+		want      string
+	}{
+		{"Apple", CollateBinary, "Apple"},
+		{"Apple", CollateNoCase, "apple"},
+		{"STRASSE", CollateNoCase, "strasse"},
+		{"Straße", CollateNoCase, "strasse"},
+		{"Éclair", CollateNoCase, "éclair"},
+		{"Éclair", CollateFold, "eclair"},
+		{"E\u0301clair", CollateFold, "eclair"}, // decomposed
+		{"ｆｕｌｌ", CollateFold, "full"},           // full width
+	}
+
+	for _, st := range table {
+		if got := sortKey(st.name, st.collation); got != st.want {
+			t.Errorf("%s %q: wanted %q, got %q", st.collation, st.name, st.want, got)
+		}
+	}
+
+	if err := checkCollation("klingon"); err == nil {
+		t.Errorf("invalid collation accepted")
+	}
+}
+
+// TestMemoryNameOrder lists mixed-case names by name,
+// and has Reindex pick up a change of collation
+func TestMemoryNameOrder(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	if err := m.SetListOrder(OrderName); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, n := range []string{"banana", "Zebra", "apple", "Cherry"} {
+		if _, err := m.AddItem(ctx, &model.Item{Name: n}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	names := func() []string {
+		items, err := m.ListItems(ctx)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		result := make([]string, 0, len(items))
+
+		for _, i := range items {
+			result = append(result, i.Name)
+		}
+
+		return result
+	}
+
+	check := func(what string, want ...string) {
+		got := names()
+
+		if len(got) != len(want) {
+			t.Fatalf("%s: wanted %v, got %v", what, want, got)
+		}
+
+		for n := range want {
+			if got[n] != want[n] {
+				t.Errorf("%s: wanted %v, got %v", what, want, got)
+				break
+			}
+		}
+	}
+
+	// binary order puts every capital first
+
+	check("binary", "Cherry", "Zebra", "apple", "banana")
+
+	if err := m.SetCollation(CollateNoCase); err != nil {
+		t.Fatal(err)
+	}
+
+	// the stored keys don't change until we reindex
+
+	check("before reindex", "Cherry", "Zebra", "apple", "banana")
+
+	n, err := m.Reindex(ctx)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 2 {
+		t.Errorf("wanted 2 reindexed, got %d", n)
+	}
+
+	check("nocase", "apple", "banana", "Cherry", "Zebra")
+
+	if n, _ = m.Reindex(ctx); n != 0 {
+		t.Errorf("second reindex: wanted 0, got %d", n)
+	}
+
+	// new items get the new key on write
+
+	if _, err := m.AddItem(ctx, &model.Item{Name: "Banana"}); err != nil {
+		t.Fatal(err)
+	}
+
+	got := names()
+
+	if len(got) != 5 || got[1] != "banana" && got[1] != "Banana" || got[4] != "Zebra" {
+		t.Errorf("after add: got %v", got)
+	}
+}
+
+// TestClientStored gives what Firestore is sent the sort
+// key, secrets or no, and still won't write a secret with
+// no key to seal it
+func TestClientStored(t *testing.T) {
+	c := new(Client)
+
+	if err := c.SetCollation(CollateFold); err != nil {
+		t.Fatal(err)
+	}
+
+	plain := &model.Item{Name: "Éclair"}
+	out, err := c.stored(plain)
+
+	if err != nil || out != plain || out.NameSort != "eclair" {
+		t.Errorf("plain item: got %#v, %v", out, err)
+	}
+
+	if _, err = c.stored(&model.Item{Name: "Éclair", SupplierCost: "1.00"}); !errors.Is(err, ErrNoKey) {
+		t.Errorf("wanted ErrNoKey, got %v", err)
+	}
+
+	if err = c.SetKey(bytes.Repeat([]byte{7}, 32)); err != nil {
+		t.Fatal(err)
+	}
+
+	secret := &model.Item{Name: "Éclair", SupplierCost: "1.00"}
+	out, err = c.stored(secret)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out == secret || out.NameSort != "eclair" || !strings.HasPrefix(out.SupplierCost, sealedPrefix) {
+		t.Errorf("secret item: got %#v", out)
+	}
+}
@@ -5,7 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"math"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"cloud.google.com/go/firestore"
 	"github.com/google/uuid"
@@ -17,31 +21,143 @@ import (
 
 type DB interface {
 	AddItem(context.Context, *model.Item) (string, error)
+	AddItems(context.Context, []*model.Item) (int, error)
+	CreateWithID(context.Context, string, *model.Item) error
+	Upsert(context.Context, []*model.Item) (int, int, error)
 	GetItem(context.Context, string) (*model.Item, error)
+	GetItems(context.Context, []string) (*ItemBatch, error)
 	GetItemBySKU(context.Context, int) (*model.Item, error)
+	GetItemsBySKUs(context.Context, []int) (*SKUBatch, error)
 	ListItems(context.Context) ([]*model.Item, error)
+	ListItemsFields(context.Context, []string) ([]*model.Item, error)
+	ListItemsByTags(context.Context, []string) ([]*model.Item, error)
 	ListSKUs(context.Context) (map[string]string, error)
+	ListSKUPage(context.Context, int, int) ([]*model.SkuEntry, error)
+	ListItemPage(context.Context, int, int, []string) ([]*model.Item, error)
+	ListChanges(context.Context, int) (*Changes, error)
+	LastSeq(context.Context) (int, error)
 	UpdateItem(context.Context, *model.Item) error
 	DeleteItem(context.Context, string) error
+	ReconcileSKU(context.Context) (bool, error)
+	ReserveSKUBlock(context.Context, int) (int, error)
+	Reindex(context.Context) (int, error)
+	Restore(context.Context, []*model.Item) (int, error)
+	ImportItems(context.Context, []*model.Item) (int, error)
+	SKUStats(context.Context) (*SKUStats, error)
+	AuditLog(ctx context.Context, action, itemID, user string, before, after *model.Item) error
+	ListAudit(context.Context, string) ([]*AuditEntry, error)
+	Ping(context.Context) error
+	CheckWrite(context.Context) error
+	Close() error
 }
 
 const (
 	skuDoc    = "Next$SKU"
 	nextField = "next"
-	startSKU  = 1000
 )
 
+// DefaultStartSKU is the first SKU of a new database,
+// unless NewClient is told otherwise
+const DefaultStartSKU = 1000
+
+// checkStart rejects a first SKU we couldn't hand out
+func checkStart(start int) error {
+	if start < 1 || start > MaxSKU {
+		return fmt.Errorf("invalid SKU start %d: must be between 1 and %d", start, MaxSKU)
+	}
+
+	return nil
+}
+
+// MaxSKU is the highest SKU we'll hand out, so a SKU always
+// fits in an int (and a GraphQL Int) even on 32-bit builds
+const (
+	MaxSKU    = math.MaxInt32
+	skuWarnAt = MaxSKU - 100000
+)
+
+// the orders ListItems can return items in
+const (
+	OrderSKU  = "sku"
+	OrderID   = "id"
+	OrderName = "name" // by name_sort, see SetCollation
+)
+
+func checkOrder(order string) error {
+	if order != OrderSKU && order != OrderID && order != OrderName {
+		return fmt.Errorf("invalid list order: %s", order)
+	}
+
+	return nil
+}
+
+// the ways AddItem can name a new item
+const (
+	IDUUID = "uuid"
+	IDSKU  = "sku"
+)
+
+func checkScheme(scheme string) error {
+	if scheme != IDUUID && scheme != IDSKU {
+		return fmt.Errorf("invalid ID scheme: %s", scheme)
+	}
+
+	return nil
+}
+
+// skuID is the document ID for an item with the IDSKU
+// scheme, so the item can be read by SKU without a query
+func skuID(sku int) string {
+	return fmt.Sprintf("item-%06d", sku)
+}
+
 type Client struct {
+	// allocated counts SKUs taken from the counter (see
+	// SKUStats); it's first so it's 64-bit aligned for
+	// atomic use on 32-bit platforms
+	allocated int64
+
 	fs   *firestore.Client
 	data *firestore.CollectionRef
 	util *firestore.CollectionRef
+
+	// order is how ListItems sorts, one of the Order
+	// constants
+	order string
+
+	// collation makes name_sort from the name
+	collation string
+
+	// skuIDs names new items after their SKU
+	skuIDs bool
+
+	// skus hands out SKUs with SKUBatched; if it's nil,
+	// each create bumps the counter itself
+	skus *skuBlocks
+
+	// sealer encrypts secret fields (see SetKey)
+	sealer *sealer
 }
 
-func NewClient(project, data, util string) (*Client, error) {
+// NewClient connects to Firestore; start is the first
+// SKU if there's no SKU counter yet, and is ignored if
+// there is one
+func NewClient(project, data, util string, start int) (*Client, error) {
 	if project == "" {
 		return nil, errors.New("no projectID")
 	}
 
+	if err := checkStart(start); err != nil {
+		return nil, err
+	}
+
+	// the SKU counter would show up in the item list as an
+	// item with no name, so they must be kept apart
+
+	if data == util {
+		return nil, fmt.Errorf("data and util collections are both %q", data)
+	}
+
 	ctx := context.Background()
 	client, err := firestore.NewClient(ctx, project)
 
@@ -50,23 +166,71 @@ func NewClient(project, data, util string) (*Client, error) {
 	}
 
 	c := Client{
-		fs:   client,
-		data: client.Collection(data),
-		util: client.Collection(util),
+		fs:        client,
+		data:      client.Collection(data),
+		util:      client.Collection(util),
+		order:     OrderSKU,
+		collation: CollateBinary,
 	}
 
-	if err = c.startSKU(ctx); err != nil {
+	if err = c.startSKU(ctx, start); err != nil {
 		return nil, err
 	}
 
 	return &c, nil
 }
 
-func (c *Client) Close() {
-	c.fs.Close()
+// Close closes the Firestore connection; the client can't
+// be used after
+func (c *Client) Close() error {
+	return c.fs.Close()
 }
 
-func (c *Client) startSKU(ctx context.Context) error {
+// SetListOrder picks the order ListItems uses, either
+// OrderSKU (the default) or OrderID
+func (c *Client) SetListOrder(order string) error {
+	if err := checkOrder(order); err != nil {
+		return err
+	}
+
+	c.order = order
+	return nil
+}
+
+// SetCollation picks how name_sort is made from the name
+// (see CollateBinary etc.); items written before a change
+// keep their old key until Reindex
+func (c *Client) SetCollation(collation string) error {
+	if err := checkCollation(collation); err != nil {
+		return err
+	}
+
+	c.collation = collation
+	return nil
+}
+
+// stored is what to write for an item: with its sort key,
+// and with its secrets sealed
+func (c *Client) stored(i *model.Item) (*model.Item, error) {
+	i.NameSort = sortKey(i.Name, c.collation)
+	return c.sealer.sealItem(i)
+}
+
+// SetIDScheme picks how AddItem names new items, either
+// IDUUID (the default) or IDSKU; items added before the
+// change keep their IDs
+func (c *Client) SetIDScheme(scheme string) error {
+	if err := checkScheme(scheme); err != nil {
+		return err
+	}
+
+	c.skuIDs = scheme == IDSKU
+	return nil
+}
+
+// startSKU creates the SKU counter at start, unless it's
+// there already (we mustn't hand out SKUs twice)
+func (c *Client) startSKU(ctx context.Context, start int) error {
 	ref := c.util.Doc(skuDoc)
 
 	return c.fs.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
@@ -77,7 +241,7 @@ func (c *Client) startSKU(ctx context.Context) error {
 				log.Println("no SKU doc, adding it")
 
 				data := map[string]interface{}{
-					nextField: startSKU,
+					nextField: start,
 				}
 
 				if err := tx.Create(ref, data); err != nil {
@@ -105,6 +269,73 @@ func (c *Client) startSKU(ctx context.Context) error {
 	})
 }
 
+// ReconcileSKU makes sure the counter is past the highest
+// SKU in use, in case an import or manual edit has put them
+// out of step; it returns true if it had to fix anything
+func (c *Client) ReconcileSKU(ctx context.Context) (bool, error) {
+	seqRef := c.util.Doc(skuDoc)
+	query := c.data.OrderBy("sku", firestore.Desc).Limit(1)
+	fixed := false
+
+	err := c.fs.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		fixed = false // in case the transaction is retried
+
+		next, err := getNext(seqRef, tx)
+
+		if err != nil {
+			return err
+		}
+
+		docs, err := tx.Documents(query).GetAll()
+
+		if err != nil || len(docs) == 0 {
+			return err
+		}
+
+		var i model.Item
+
+		if err = docs[0].DataTo(&i); err != nil {
+			return fmt.Errorf("item %s decode: %w", docs[0].Ref.ID, err)
+		}
+
+		if i.Sku < next {
+			return nil
+		}
+
+		log.Printf("SKU %s = %d but max is %d, repairing", nextField, next, i.Sku)
+		fixed = true
+
+		return tx.Update(seqRef, []firestore.Update{{Path: nextField, Value: i.Sku + 1}})
+	})
+
+	return fixed, err
+}
+
+// Ping is a cheap read-only check that we can reach
+// Firestore and read the SKU counter
+func (c *Client) Ping(ctx context.Context) error {
+	_, err := c.util.Doc(skuDoc).Get(ctx)
+
+	return err
+}
+
+// CheckWrite proves we can still write by rewriting the SKU
+// counter with the value it already has; it's transactional,
+// so if anything fails the counter is left as it was
+func (c *Client) CheckWrite(ctx context.Context) error {
+	seqRef := c.util.Doc(skuDoc)
+
+	return c.fs.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		next, err := getNext(seqRef, tx)
+
+		if err != nil {
+			return err
+		}
+
+		return tx.Update(seqRef, []firestore.Update{{Path: nextField, Value: next}})
+	})
+}
+
 func getNext(seqRef *firestore.DocumentRef, tx *firestore.Transaction) (int, error) {
 	seq, err := tx.Get(seqRef) // tx.Get, NOT docRef.Get!
 
@@ -124,44 +355,222 @@ func getNext(seqRef *firestore.DocumentRef, tx *firestore.Transaction) (int, err
 		return 0, fmt.Errorf("can't read %s", nextField)
 	}
 
+	// don't let the cast wrap on a 32-bit build
+
+	if val < 0 || val > MaxSKU {
+		return 0, fmt.Errorf("%s = %d: %w", nextField, val, ErrSKUExhausted)
+	}
+
 	return int(val), nil
 }
 
-func (c *Client) create(ctx context.Context, ref *firestore.DocumentRef, item *model.Item) error {
+// checkSKU refuses to allocate past MaxSKU and warns (every
+// 1000 SKUs, not on every call) as the counter gets close
+func checkSKU(next int) error {
+	if next > MaxSKU {
+		return fmt.Errorf("sku %d: %w", next, ErrSKUExhausted)
+	}
+
+	if next >= skuWarnAt && (MaxSKU-next)%1000 == 0 {
+		log.Printf("WARN SKU counter at %d, only %d left", next, MaxSKU-next)
+	}
+
+	return nil
+}
+
+// MaxSKUBlock is the most SKUs one call can reserve
+const MaxSKUBlock = 10000
+
+func checkBlock(n int) error {
+	if n < 1 || n > MaxSKUBlock {
+		return fmt.Errorf("can't reserve %d SKUs (1 to %d)", n, MaxSKUBlock)
+	}
+
+	return nil
+}
+
+// ReserveSKUBlock sets aside n consecutive SKUs, which no
+// item will be given, and returns the first of them
+func (c *Client) ReserveSKUBlock(ctx context.Context, n int) (int, error) {
+	if err := checkBlock(n); err != nil {
+		return 0, err
+	}
+
 	seqRef := c.util.Doc(skuDoc)
 
-	return c.fs.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+	var start int
+
+	err := c.fs.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
 		next, err := getNext(seqRef, tx)
 
 		if err != nil {
 			return err
 		}
 
+		if err = checkSKU(next + n - 1); err != nil {
+			return err
+		}
+
+		start = next
+
+		return tx.Update(seqRef, []firestore.Update{{Path: nextField, Value: next + n}})
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	c.took(n)
+
+	return start, nil
+}
+
+// create adds the item with the next SKU; if ref is nil,
+// the document is named after the SKU
+func (c *Client) create(ctx context.Context, ref *firestore.DocumentRef, item *model.Item) error {
+	seqRef := c.util.Doc(skuDoc)
+
+	// a batched SKU is ours already, so it's the same if
+	// the transaction runs again (and lost if it fails)
+
+	batched := 0
+
+	if c.skus != nil {
+		var err error
+
+		if batched, err = c.skus.take(ctx); err != nil {
+			return err
+		}
+	}
+
+	err := c.fs.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		var err error
+
+		next := batched
+
+		if next == 0 {
+			if next, err = getNext(seqRef, tx); err != nil {
+				return err
+			}
+
+			if err = checkSKU(next); err != nil {
+				return err
+			}
+		}
+
+		if item.Seq, err = c.claimSeq(tx); err != nil {
+			return err
+		}
+
 		item.Sku = next
 
+		// the transaction may run again with another SKU,
+		// so we mustn't keep the name from this attempt
+
+		doc := ref
+
+		if doc == nil {
+			doc = c.data.Doc(skuID(next))
+			item.ID = doc.ID
+		}
+
 		// if the transaction fails, this write will
 		// also fail, so we shouldn't waste SKUs
 
-		if err := tx.Update(seqRef, []firestore.Update{{Path: nextField, Value: next + 1}}); err != nil {
+		if batched == 0 {
+			if err := tx.Update(seqRef, []firestore.Update{{Path: nextField, Value: next + 1}}); err != nil {
+				return err
+			}
+		}
+
+		stored, err := c.stored(item)
+
+		if err != nil {
 			return err
 		}
 
 		// using Create here will prevent overwriting an
 		// existing offer with the same UUID
 
-		if err := tx.Create(ref, item); err != nil {
+		if err := tx.Create(doc, stored); err != nil {
+			return err
+		}
+
+		if err := tx.Create(c.logged(ctx, AuditCreate, doc.ID, nil, stored)); err != nil {
 			return err
 		}
 
-		return nil
+		// the ID may have been used before (CreateWithID)
+
+		return tx.Delete(c.tombRef(doc.ID))
 	})
+
+	// a batched SKU was counted with its block
+
+	if err == nil && batched == 0 {
+		c.took(1)
+	}
+
+	return err
 }
 
-var ErrNotFound = errors.New("not found")
+var (
+	ErrNotFound = errors.New("not found")
+	ErrExists   = errors.New("already exists")
+
+	// ErrDuplicate means an item being imported has a SKU
+	// that's taken; it's also ErrExists
+	ErrDuplicate = fmt.Errorf("duplicate SKU: %w", ErrExists)
+
+	ErrSKUExhausted = errors.New("no SKUs left")
+
+	// ErrAmbiguous means a lookup that should find one
+	// item found several, e.g. two with the same SKU after
+	// a bad import
+	ErrAmbiguous = errors.New("ambiguous")
+)
+
+type anyMatchKey struct{}
+
+// WithAnyMatch lets GetItemBySKU return the first of the
+// items with a SKU (by ID) rather than ErrAmbiguous
+func WithAnyMatch(ctx context.Context) context.Context {
+	return context.WithValue(ctx, anyMatchKey{}, true)
+}
+
+// AnyMatch reports whether the caller will take the first
+// of several matches (see WithAnyMatch)
+func AnyMatch(ctx context.Context) bool {
+	ok, _ := ctx.Value(anyMatchKey{}).(bool)
+	return ok
+}
+
+// ambiguous is the error for a SKU several items have;
+// it's logged, since it means the data needs fixing
+func ambiguous(sku int, ids []string) error {
+	log.Printf("WARNING: sku %d is on %d items: %s", sku, len(ids), strings.Join(ids, ", "))
+	return fmt.Errorf("sku %d is on %d items: %w", sku, len(ids), ErrAmbiguous)
+}
 
 func (c *Client) AddItem(ctx context.Context, i *model.Item) (string, error) {
 	var ref *firestore.DocumentRef
 
+	// SKUs are never reused, so an item named after one
+	// can only collide with an ID a client chose; trying
+	// again would just get the same SKU
+
+	if c.skuIDs {
+		if err := c.create(ctx, nil, i); err != nil {
+			if status.Code(err) == codes.AlreadyExists {
+				return "", fmt.Errorf("%s: %w", i.ID, ErrExists)
+			}
+
+			return "", err
+		}
+
+		return i.ID, nil
+	}
+
 add:
 	i.ID = uuid.New().String()
 	ref = c.data.Doc(i.ID)
@@ -180,6 +589,128 @@ add:
 	return i.ID, nil
 }
 
+// CreateWithID lets the client pick the document ID, e.g. so
+// that retries are idempotent; the SKU is still ours to assign
+func (c *Client) CreateWithID(ctx context.Context, id string, i *model.Item) error {
+	i.ID = id
+
+	if err := c.create(ctx, c.data.Doc(id), i); err != nil {
+		if status.Code(err) == codes.AlreadyExists {
+			return fmt.Errorf("%s: %w", id, ErrExists)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// Upsert creates or updates each item by its external key,
+// so an import can push the same batch more than once; each
+// item is its own transaction, so a failure part way through
+// leaves the earlier items written (and counted)
+func (c *Client) Upsert(ctx context.Context, items []*model.Item) (created, updated int, err error) {
+	for _, i := range items {
+		isNew, err := c.upsert(ctx, i)
+
+		if err != nil {
+			return created, updated, fmt.Errorf("upsert %s: %w", i.ExternalKey, err)
+		}
+
+		if isNew {
+			created++
+			c.took(1)
+		} else {
+			updated++
+		}
+	}
+
+	return created, updated, nil
+}
+
+func (c *Client) upsert(ctx context.Context, i *model.Item) (bool, error) {
+	seqRef := c.util.Doc(skuDoc)
+	query := c.data.Where("external_key", "==", i.ExternalKey).Limit(1)
+	isNew := false
+
+	err := c.fs.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		docs, err := tx.Documents(query).GetAll()
+
+		if err != nil {
+			return err
+		}
+
+		// an existing item keeps its ID and SKU
+
+		if len(docs) > 0 {
+			var old model.Item
+
+			if err = docs[0].DataTo(&old); err != nil {
+				return fmt.Errorf("item %s decode: %w", docs[0].Ref.ID, err)
+			}
+
+			isNew = false
+			i.ID, i.Sku = old.ID, old.Sku
+
+			if i.Seq, err = c.claimSeq(tx); err != nil {
+				return err
+			}
+
+			stored, err := c.stored(i)
+
+			if err != nil {
+				return err
+			}
+
+			if err = tx.Set(docs[0].Ref, stored); err != nil {
+				return err
+			}
+
+			return tx.Create(c.logged(ctx, AuditUpsert, i.ID, &old, stored))
+		}
+
+		next, err := getNext(seqRef, tx)
+
+		if err != nil {
+			return err
+		}
+
+		if err = checkSKU(next); err != nil {
+			return err
+		}
+
+		if i.Seq, err = c.claimSeq(tx); err != nil {
+			return err
+		}
+
+		isNew = true
+		i.ID = uuid.New().String()
+		i.Sku = next
+
+		if c.skuIDs {
+			i.ID = skuID(next)
+		}
+
+		stored, err := c.stored(i)
+
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Update(seqRef, []firestore.Update{{Path: nextField, Value: next + 1}}); err != nil {
+			return err
+		}
+
+		if err := tx.Create(c.data.Doc(i.ID), stored); err != nil {
+			return err
+		}
+
+		return tx.Create(c.logged(ctx, AuditUpsert, i.ID, nil, stored))
+	})
+
+	return isNew, err
+}
+
 func (c *Client) GetItem(ctx context.Context, id string) (*model.Item, error) {
 	doc, err := c.data.Doc(id).Get(ctx)
 
@@ -197,10 +728,27 @@ func (c *Client) GetItem(ctx context.Context, id string) (*model.Item, error) {
 		return nil, fmt.Errorf("item %s decode: %w", id, err)
 	}
 
+	c.sealer.openItem(ctx, &i)
+
 	return &i, nil
 }
 
 func (c *Client) GetItemBySKU(ctx context.Context, sku int) (*model.Item, error) {
+	// an item named after its SKU is one read away; if it's
+	// not there, it may be from before the scheme changed
+
+	if c.skuIDs {
+		i, err := c.GetItem(ctx, skuID(sku))
+
+		if err == nil && i.Sku == sku {
+			return i, nil
+		}
+
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+	}
+
 	query := c.data.Where("sku", "==", sku)
 	docs, err := query.Documents(ctx).GetAll()
 
@@ -213,6 +761,23 @@ func (c *Client) GetItemBySKU(ctx context.Context, sku int) (*model.Item, error)
 		return nil, fmt.Errorf("sku %d: %w", sku, ErrNotFound)
 	}
 
+	// the query has no order, so "first" is by ID, as in
+	// the memory backend
+
+	sort.Slice(docs, func(x, y int) bool {
+		return docs[x].Ref.ID < docs[y].Ref.ID
+	})
+
+	if len(docs) > 1 && !AnyMatch(ctx) {
+		ids := make([]string, 0, len(docs))
+
+		for _, d := range docs {
+			ids = append(ids, d.Ref.ID)
+		}
+
+		return nil, ambiguous(sku, ids)
+	}
+
 	var i model.Item
 
 	if err = docs[0].DataTo(&i); err != nil {
@@ -220,11 +785,108 @@ func (c *Client) GetItemBySKU(ctx context.Context, sku int) (*model.Item, error)
 		return nil, err
 	}
 
+	c.sealer.openItem(ctx, &i)
+
 	return &i, nil
 }
 
+// maxIn is Firestore's limit on values in an "in" query
+const maxIn = 10
+
+// GetItemsBySKUs looks up many SKUs with as few queries as
+// Firestore allows; SKUs that aren't found are just missing
+// from the result, and one on several items is an error of
+// its own, as GetItemBySKU would return
+func (c *Client) GetItemsBySKUs(ctx context.Context, skus []int) (*SKUBatch, error) {
+	var found []*model.Item
+
+	skips := skipLog{what: "GetItemsBySKUs"}
+
+	defer skips.done()
+
+	for len(skus) > 0 {
+		n := len(skus)
+
+		if n > maxIn {
+			n = maxIn
+		}
+
+		query := c.data.Where("sku", "in", skus[:n])
+		docs, err := query.Documents(ctx).GetAll()
+
+		if err != nil {
+			log.Printf("error finding skus %v: %s", skus[:n], err)
+			return nil, err
+		}
+
+		for _, doc := range docs {
+			var i model.Item
+
+			err = doc.DataTo(&i)
+			skips.add(doc.Ref.ID, err)
+
+			if err != nil {
+				continue
+			}
+
+			c.sealer.openItem(ctx, &i)
+			found = append(found, &i)
+		}
+
+		skus = skus[n:]
+	}
+
+	// with no order, each query's results are in ID order,
+	// and the queries have no SKUs in common
+
+	return bySKU(ctx, found), nil
+}
+
 func (c *Client) ListItems(ctx context.Context) ([]*model.Item, error) {
-	query := c.data.OrderBy(firestore.DocumentID, firestore.Asc)
+	return c.ListItemsFields(ctx, nil)
+}
+
+// ListItemsFields is ListItems reading only the given
+// (Firestore) fields, leaving the rest zero; no fields
+// means all of them
+func (c *Client) ListItemsFields(ctx context.Context, fields []string) ([]*model.Item, error) {
+	query := c.data.OrderBy("sku", firestore.Asc)
+
+	// an item with no name_sort (from before we had it)
+	// is left out of a list by name until Reindex
+
+	switch c.order {
+	case OrderID:
+		query = c.data.OrderBy(firestore.DocumentID, firestore.Asc)
+	case OrderName:
+		query = c.data.OrderBy("name_sort", firestore.Asc)
+	}
+
+	if len(fields) > 0 {
+		query = query.Select(fields...)
+	}
+
+	return c.list(ctx, query)
+}
+
+// MaxTags is the most tags we can filter by at once
+// (Firestore's limit for array-contains-any)
+const MaxTags = 10
+
+// ListItemsByTags finds the items that have any of the tags
+func (c *Client) ListItemsByTags(ctx context.Context, tags []string) ([]*model.Item, error) {
+	if len(tags) > MaxTags {
+		return nil, fmt.Errorf("too many tags: %d > %d", len(tags), MaxTags)
+	}
+
+	if len(tags) == 1 {
+		return c.list(ctx, c.data.Where("tags", "array-contains", tags[0]))
+	}
+
+	return c.list(ctx, c.data.Where("tags", "array-contains-any", tags))
+}
+
+func (c *Client) list(ctx context.Context, query firestore.Query) ([]*model.Item, error) {
 	docs, err := query.Documents(ctx).GetAll()
 
 	if err != nil {
@@ -232,15 +894,21 @@ func (c *Client) ListItems(ctx context.Context) ([]*model.Item, error) {
 	}
 
 	result := make([]*model.Item, 0, len(docs))
+	skips := skipLog{what: "list"}
+
+	defer skips.done()
 
 	for _, doc := range docs {
 		var i model.Item
 
-		if err = doc.DataTo(&i); err != nil {
-			log.Printf("item %s decode: %s", doc.Ref.ID, err)
+		err = doc.DataTo(&i)
+		skips.add(doc.Ref.ID, err)
+
+		if err != nil {
 			continue
 		}
 
+		c.sealer.openItem(ctx, &i)
 		result = append(result, &i)
 	}
 
@@ -256,12 +924,17 @@ func (c *Client) ListSKUs(ctx context.Context) (map[string]string, error) {
 	}
 
 	result := make(map[string]string, len(docs))
+	skips := skipLog{what: "ListSKUs"}
+
+	defer skips.done()
 
 	for _, doc := range docs {
 		var i model.Item
 
-		if err = doc.DataTo(&i); err != nil {
-			log.Printf("item %s decode: %s", doc.Ref.ID, err)
+		err = doc.DataTo(&i)
+		skips.add(doc.Ref.ID, err)
+
+		if err != nil {
 			continue
 		}
 
@@ -273,33 +946,146 @@ func (c *Client) ListSKUs(ctx context.Context) (map[string]string, error) {
 	return result, nil
 }
 
-func (c *Client) UpdateItem(ctx context.Context, i *model.Item) error {
-	ref := c.data.Doc(i.ID)
+// ListSKUPage returns up to limit entries with SKUs after
+// the given one, in SKU order; pass the last SKU of one
+// page to get the next
+func (c *Client) ListSKUPage(ctx context.Context, after, limit int) ([]*model.SkuEntry, error) {
+	query := c.data.Select("sku").OrderBy("sku", firestore.Asc).StartAfter(after).Limit(limit)
+	docs, err := query.Documents(ctx).GetAll()
 
-	// set can create or overwrite existing data
-	// so we need to see if it exists first
+	if err != nil {
+		return nil, err
+	}
 
-	if _, err := ref.Get(ctx); err != nil {
-		if status.Code(err) == codes.NotFound {
-			return fmt.Errorf("%s: %w", i.ID, ErrNotFound)
+	result := make([]*model.SkuEntry, 0, len(docs))
+	skips := skipLog{what: "ListSKUPage"}
+
+	defer skips.done()
+
+	for _, doc := range docs {
+		var i model.Item
+
+		err = doc.DataTo(&i)
+		skips.add(doc.Ref.ID, err)
+
+		if err != nil {
+			continue
 		}
 
-		return err
+		result = append(result, &model.SkuEntry{Sku: i.Sku, ID: doc.Ref.ID})
 	}
 
-	if _, err := ref.Set(ctx, i); err != nil {
-		return err
+	return result, nil
+}
+
+// ListItemPage returns up to limit items with SKUs after
+// the given one, in SKU order, reading only the given
+// fields (none means all of them); unlike a list cut into
+// pages, it reads no more than the page
+func (c *Client) ListItemPage(ctx context.Context, after, limit int, fields []string) ([]*model.Item, error) {
+	query := c.data.OrderBy("sku", firestore.Asc).StartAfter(after).Limit(limit)
+
+	if len(fields) > 0 {
+		query = query.Select(fields...)
 	}
 
-	return nil
+	return c.list(ctx, query)
+}
+
+func (c *Client) UpdateItem(ctx context.Context, i *model.Item) error {
+	ref := c.data.Doc(i.ID)
+
+	return c.fs.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		// set can create or overwrite existing data
+		// so we need to see if it exists first
+
+		doc, err := tx.Get(ref)
+
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				return fmt.Errorf("%s: %w", i.ID, ErrNotFound)
+			}
+
+			return err
+		}
+
+		seq, err := c.claimSeq(tx)
+
+		if err != nil {
+			return err
+		}
+
+		i.Seq = seq
+
+		stored, err := c.stored(i)
+
+		if err != nil {
+			return err
+		}
+
+		if err = tx.Set(ref, stored); err != nil {
+			return err
+		}
+
+		return tx.Create(c.logged(ctx, AuditUpdate, i.ID, storedItem(doc), stored))
+	})
 }
 
+// DeleteItem leaves a tombstone for sync clients; deleting
+// an item that isn't there does nothing
 func (c *Client) DeleteItem(ctx context.Context, id string) error {
-	_, err := c.data.Doc(id).Delete(ctx)
+	ref := c.data.Doc(id)
 
-	if err != nil {
-		return err
+	return c.fs.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				return nil
+			}
+
+			return err
+		}
+
+		seq, err := c.claimSeq(tx)
+
+		if err != nil {
+			return err
+		}
+
+		if err = tx.Delete(ref); err != nil {
+			return err
+		}
+
+		if err = tx.Create(c.logged(ctx, AuditDelete, id, storedItem(doc), nil)); err != nil {
+			return err
+		}
+
+		return tx.Set(c.tombRef(id), tombstone{ID: id, Seq: seq})
+	})
+}
+
+// RetryAfter is how long clients should wait before trying
+// again when Firestore is over quota or unavailable
+const RetryAfter = 5 * time.Second
+
+// Code is the gRPC code of err or of an error it wraps,
+// since Firestore errors are gRPC errors underneath;
+// context timeouts count as DeadlineExceeded
+func Code(err error) codes.Code {
+	if err == nil {
+		return codes.OK
 	}
 
-	return nil
+	var se interface{ GRPCStatus() *status.Status }
+
+	if errors.As(err, &se) {
+		return se.GRPCStatus().Code()
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return codes.DeadlineExceeded
+	}
+
+	return codes.Unknown
 }
@@ -0,0 +1,74 @@
+package db
+
+import (
+	"context"
+	"regexp"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+)
+
+// IndexProblem is a query we make that Firestore won't
+// run, most likely for want of an index
+type IndexProblem struct {
+	Query string
+	Err   error
+}
+
+// indexURL finds the link Firestore puts in the error to
+// create a missing index
+var indexURL = regexp.MustCompile(`https://console\.firebase\.google\.com/\S+`)
+
+// Hint is the URL to create the index, if Firestore gave
+// us one
+func (p IndexProblem) Hint() string {
+	return indexURL.FindString(p.Err.Error())
+}
+
+// indexQuery is one shape of query we make, for the check
+type indexQuery struct {
+	name  string
+	query firestore.Query
+}
+
+// indexQueries are the queries that need more than the
+// indexes Firestore makes by default (or that an index
+// exemption could break)
+func (c *Client) indexQueries() []indexQuery {
+	return []indexQuery{
+		{"changes", c.data.Where("seq", ">", 0).OrderBy("seq", firestore.Asc)},
+		{"list by name", c.data.OrderBy("name_sort", firestore.Asc)},
+		{"list by tags", c.data.Where("tags", "array-contains-any", []string{""})},
+		{"SKU page", c.data.Select("sku").OrderBy("sku", firestore.Asc).StartAfter(0)},
+		{"tombstones", c.util.Where(tombSeq, ">", 0)},
+		{"audit", c.audits().Where("item_id", "==", "")},
+	}
+}
+
+// CheckIndexes runs each kind of query we make once, with
+// Limit(1), so a missing index shows up at startup rather
+// than in the first real request; only errors that mean
+// the query can't run are returned, since anything else
+// (e.g. a timeout) would fail every query the same way
+func (c *Client) CheckIndexes(ctx context.Context) []IndexProblem {
+	return checkIndexes(ctx, c.indexQueries(), func(ctx context.Context, q firestore.Query) error {
+		_, err := q.Limit(1).Documents(ctx).GetAll()
+		return err
+	})
+}
+
+// checkIndexes is CheckIndexes with a way to run the
+// queries that a test can fake
+func checkIndexes(ctx context.Context, queries []indexQuery, run func(context.Context, firestore.Query) error) []IndexProblem {
+	var result []IndexProblem
+
+	for _, q := range queries {
+		err := run(ctx, q.query)
+
+		if c := Code(err); c == codes.FailedPrecondition || c == codes.InvalidArgument {
+			result = append(result, IndexProblem{q.name, err})
+		}
+	}
+
+	return result
+}
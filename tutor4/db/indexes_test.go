@@ -0,0 +1,47 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// TestCheckIndexes fakes Firestore's missing-index error
+// for one query and checks it comes back with its hint
+func TestCheckIndexes(t *testing.T) {
+	const url = "https://console.firebase.google.com/v1/r/project/tutor-dev/firestore/indexes?create_composite=Cg"
+
+	queries := []indexQuery{{name: "ok"}, {name: "no index"}, {name: "down"}}
+	errs := []error{
+		nil,
+		status.Error(codes.FailedPrecondition, "The query requires an index. You can create it here: "+url),
+		status.Error(codes.Unavailable, "no connection"),
+	}
+
+	n := 0
+
+	got := checkIndexes(context.Background(), queries, func(context.Context, firestore.Query) error {
+		n++
+		return errs[n-1]
+	})
+
+	if n != len(queries) {
+		t.Errorf("wanted %d queries run, got %d", len(queries), n)
+	}
+
+	if len(got) != 1 || got[0].Query != "no index" {
+		t.Fatalf("invalid problems: %#v", got)
+	}
+
+	if h := got[0].Hint(); h != url {
+		t.Errorf("wanted hint %s, got %s", url, h)
+	}
+
+	if h := (IndexProblem{"x", errors.New("no link")}).Hint(); h != "" {
+		t.Errorf("wanted no hint, got %s", h)
+	}
+}
@@ -0,0 +1,681 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"tutor4/graph/model"
+)
+
+// Memory is a DB that lives only in memory; it's good for
+// tests and demos, and behaves like the Firestore client
+// (same errors, same ordering) as far as it can
+//
+// like Firestore, it stores copies: what callers pass in
+// or get back is theirs to change, and it's safe for
+// concurrent use
+type Memory struct {
+	mu    sync.Mutex
+	data  map[string]*model.Item
+	next  int
+	seq   int
+	tombs map[string]int
+	order string
+
+	collation string
+
+	skuIDs bool
+	audit  []*AuditEntry
+
+	// allocated counts SKUs handed out or reserved
+	allocated int64
+}
+
+func NewMemory() *Memory {
+	m, _ := NewMemoryAt(DefaultStartSKU)
+	return m
+}
+
+// NewMemoryAt makes a Memory whose first SKU is start
+func NewMemoryAt(start int) (*Memory, error) {
+	if err := checkStart(start); err != nil {
+		return nil, err
+	}
+
+	return &Memory{
+		data:  make(map[string]*model.Item),
+		next:  start,
+		seq:   1,
+		tombs: make(map[string]int),
+		order: OrderSKU,
+
+		collation: CollateBinary,
+	}, nil
+}
+
+// SetListOrder picks the order ListItems uses, either
+// OrderSKU (the default), OrderID or OrderName
+func (m *Memory) SetListOrder(order string) error {
+	if err := checkOrder(order); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.order = order
+	return nil
+}
+
+// SetCollation picks how an item's name_sort is made
+// from its name; as with Firestore, items already stored
+// keep their old key until Reindex
+func (m *Memory) SetCollation(collation string) error {
+	if err := checkCollation(collation); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.collation = collation
+	return nil
+}
+
+// SetIDScheme picks how AddItem names new items, either
+// IDUUID (the default) or IDSKU
+func (m *Memory) SetIDScheme(scheme string) error {
+	if err := checkScheme(scheme); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.skuIDs = scheme == IDSKU
+	return nil
+}
+
+// add assumes the lock is held
+func (m *Memory) add(i *model.Item) error {
+	if err := checkSKU(m.next); err != nil {
+		return err
+	}
+
+	i.Sku = m.next
+	m.next++
+	m.allocated++
+	m.put(i)
+
+	delete(m.tombs, i.ID)
+
+	return nil
+}
+
+// put stores a copy of an item with the next change
+// number; it assumes the lock is held
+func (m *Memory) put(i *model.Item) {
+	i.NameSort = sortKey(i.Name, m.collation)
+	i.Seq = m.seq
+	m.data[i.ID] = clone(i)
+	m.seq++
+}
+
+// clone copies an item deeply enough that changing the
+// copy can't change the original
+func clone(i *model.Item) *model.Item {
+	if i == nil {
+		return nil
+	}
+
+	c := *i
+
+	if i.Tags != nil {
+		c.Tags = append([]string(nil), i.Tags...)
+	}
+
+	return &c
+}
+
+func (m *Memory) AddItem(_ context.Context, i *model.Item) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.skuIDs {
+		i.ID = skuID(m.next)
+
+		if _, ok := m.data[i.ID]; ok {
+			return "", fmt.Errorf("%s: %w", i.ID, ErrExists)
+		}
+
+		if err := m.add(i); err != nil {
+			return "", err
+		}
+
+		return i.ID, nil
+	}
+
+add:
+	i.ID = uuid.New().String()
+
+	if _, ok := m.data[i.ID]; ok {
+		goto add
+	}
+
+	if err := m.add(i); err != nil {
+		return "", err
+	}
+
+	return i.ID, nil
+}
+
+// AddItems adds all the items or none of them, which is
+// more than the Firestore client promises
+func (m *Memory) AddItems(_ context.Context, items []*model.Item) (int, error) {
+	if len(items) == 0 {
+		return 0, nil
+	}
+
+	if err := checkBlock(len(items)); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := checkSKU(m.next + len(items) - 1); err != nil {
+		return 0, err
+	}
+
+	for _, i := range items {
+		i.ID = uuid.New().String()
+
+		if m.skuIDs {
+			i.ID = skuID(m.next)
+		}
+
+		// add can't fail, we checked the last SKU above
+
+		_ = m.add(i)
+	}
+
+	return len(items), nil
+}
+
+func (m *Memory) CreateWithID(_ context.Context, id string, i *model.Item) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.data[id]; ok {
+		return fmt.Errorf("%s: %w", id, ErrExists)
+	}
+
+	i.ID = id
+
+	return m.add(i)
+}
+
+func (m *Memory) Upsert(_ context.Context, items []*model.Item) (created, updated int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+outer:
+	for _, i := range items {
+		for _, v := range m.data {
+			if v.ExternalKey == i.ExternalKey {
+				i.ID, i.Sku = v.ID, v.Sku
+				m.put(i)
+				updated++
+				continue outer
+			}
+		}
+
+		i.ID = uuid.New().String()
+
+		if m.skuIDs {
+			i.ID = skuID(m.next)
+		}
+
+		if err = m.add(i); err != nil {
+			return created, updated, fmt.Errorf("upsert %s: %w", i.ExternalKey, err)
+		}
+
+		created++
+	}
+
+	return created, updated, nil
+}
+
+// Restore writes items as they were, like Client.Restore
+func (m *Memory) Restore(_ context.Context, items []*model.Item) (int, error) {
+	if err := checkRestore(items); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, i := range items {
+		m.put(i)
+
+		delete(m.tombs, i.ID)
+	}
+
+	return len(items), nil
+}
+
+// ImportItems adds items keeping their SKUs, like
+// Client.ImportItems
+func (m *Memory) ImportItems(_ context.Context, items []*model.Item) (int, error) {
+	if len(items) == 0 {
+		return 0, nil
+	}
+
+	if err := checkImport(items); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	taken := make(map[int]bool, len(m.data))
+
+	for _, i := range m.data {
+		taken[i.Sku] = true
+	}
+
+	for _, i := range items {
+		if taken[i.Sku] {
+			return 0, fmt.Errorf("SKU %d: %w", i.Sku, ErrDuplicate)
+		}
+	}
+
+	for _, i := range items {
+		i.ID = uuid.New().String()
+
+		if m.skuIDs {
+			i.ID = skuID(i.Sku)
+		}
+
+		m.put(i)
+
+		delete(m.tombs, i.ID)
+
+		if i.Sku >= m.next {
+			m.next = i.Sku + 1
+		}
+	}
+
+	return len(items), nil
+}
+
+func (m *Memory) GetItem(_ context.Context, id string) (*model.Item, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if i, ok := m.data[id]; ok {
+		return clone(i), nil
+	}
+
+	return nil, fmt.Errorf("%s: %w", id, ErrNotFound)
+}
+
+func (m *Memory) GetItems(_ context.Context, ids []string) (*ItemBatch, error) {
+	if err := checkGetItems(ids); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return collectItems(ids, func(n int, i *model.Item) (bool, error) {
+		stored, ok := m.data[ids[n]]
+
+		if ok {
+			*i = *clone(stored)
+		}
+
+		return ok, nil
+	}), nil
+}
+
+func (m *Memory) GetItemBySKU(ctx context.Context, sku int) (*model.Item, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.skuIDs {
+		if i, ok := m.data[skuID(sku)]; ok && i.Sku == sku {
+			return clone(i), nil
+		}
+	}
+
+	found := m.list(func(i *model.Item) bool { return i.Sku == sku })
+
+	switch {
+	case len(found) == 0:
+		return nil, fmt.Errorf("sku %d: %w", sku, ErrNotFound)
+
+	case len(found) > 1 && !AnyMatch(ctx):
+		ids := make([]string, 0, len(found))
+
+		for _, i := range found {
+			ids = append(ids, i.ID)
+		}
+
+		return nil, ambiguous(sku, ids)
+	}
+
+	return found[0], nil
+}
+
+func (m *Memory) GetItemsBySKUs(ctx context.Context, skus []int) (*SKUBatch, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	want := make(map[int]bool, len(skus))
+
+	for _, sku := range skus {
+		want[sku] = true
+	}
+
+	found := m.list(func(i *model.Item) bool { return want[i.Sku] })
+
+	return bySKU(ctx, found), nil
+}
+
+// list assumes the lock is held, and orders by ID the
+// way Firestore orders by document ID
+func (m *Memory) list(keep func(*model.Item) bool) []*model.Item {
+	result := make([]*model.Item, 0, len(m.data))
+
+	for _, i := range m.data {
+		if keep(i) {
+			result = append(result, clone(i))
+		}
+	}
+
+	sort.Slice(result, func(x, y int) bool {
+		return result[x].ID < result[y].ID
+	})
+
+	return result
+}
+
+func (m *Memory) ListItems(_ context.Context) ([]*model.Item, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := m.list(func(*model.Item) bool { return true })
+
+	switch m.order {
+	case OrderSKU:
+		sort.Slice(result, func(x, y int) bool {
+			return result[x].Sku < result[y].Sku
+		})
+
+	case OrderName:
+		// stable, so equal keys stay in ID order like
+		// Firestore's tie-break on document ID
+
+		sort.SliceStable(result, func(x, y int) bool {
+			return result[x].NameSort < result[y].NameSort
+		})
+	}
+
+	return result, nil
+}
+
+// ListItemsFields returns whole items; there's nothing
+// to save by projecting in memory
+func (m *Memory) ListItemsFields(ctx context.Context, _ []string) ([]*model.Item, error) {
+	return m.ListItems(ctx)
+}
+
+func (m *Memory) ListItemsByTags(_ context.Context, tags []string) ([]*model.Item, error) {
+	if len(tags) > MaxTags {
+		return nil, fmt.Errorf("too many tags: %d > %d", len(tags), MaxTags)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keep := func(i *model.Item) bool {
+		for _, t := range i.Tags {
+			for _, u := range tags {
+				if t == u {
+					return true
+				}
+			}
+		}
+
+		return false
+	}
+
+	return m.list(keep), nil
+}
+
+func (m *Memory) ListSKUs(_ context.Context) (map[string]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := make(map[string]string, len(m.data))
+
+	for _, i := range m.data {
+		result[strconv.Itoa(i.Sku)] = i.ID
+	}
+
+	return result, nil
+}
+
+func (m *Memory) ListSKUPage(_ context.Context, after, limit int) ([]*model.SkuEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := make([]*model.SkuEntry, 0, limit)
+
+	for _, i := range m.data {
+		if i.Sku > after {
+			result = append(result, &model.SkuEntry{Sku: i.Sku, ID: i.ID})
+		}
+	}
+
+	sort.Slice(result, func(x, y int) bool {
+		return result[x].Sku < result[y].Sku
+	})
+
+	if len(result) > limit {
+		result = result[:limit]
+	}
+
+	return result, nil
+}
+
+// ListItemPage returns whole items, as ListItemsFields does
+func (m *Memory) ListItemPage(_ context.Context, after, limit int, _ []string) ([]*model.Item, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := m.list(func(i *model.Item) bool { return i.Sku > after })
+
+	sort.Slice(result, func(x, y int) bool {
+		return result[x].Sku < result[y].Sku
+	})
+
+	if len(result) > limit {
+		result = result[:limit]
+	}
+
+	return result, nil
+}
+
+func (m *Memory) UpdateItem(_ context.Context, i *model.Item) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.data[i.ID]; !ok {
+		return fmt.Errorf("%s: %w", i.ID, ErrNotFound)
+	}
+
+	m.put(i)
+
+	return nil
+}
+
+func (m *Memory) DeleteItem(_ context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.data[id]; ok {
+		delete(m.data, id)
+		m.tombs[id] = m.seq
+		m.seq++
+	}
+
+	return nil
+}
+
+func (m *Memory) ListChanges(_ context.Context, since int) (*Changes, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := Changes{
+		Items:   m.list(func(i *model.Item) bool { return i.Seq > since }),
+		Deleted: []string{},
+		NextSeq: since,
+	}
+
+	sort.Slice(result.Items, func(x, y int) bool {
+		return result.Items[x].Seq < result.Items[y].Seq
+	})
+
+	for _, i := range result.Items {
+		if i.Seq > result.NextSeq {
+			result.NextSeq = i.Seq
+		}
+	}
+
+	for id, seq := range m.tombs {
+		if seq > since {
+			result.Deleted = append(result.Deleted, id)
+
+			if seq > result.NextSeq {
+				result.NextSeq = seq
+			}
+		}
+	}
+
+	sort.Strings(result.Deleted)
+
+	return &result, nil
+}
+
+func (m *Memory) LastSeq(_ context.Context) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.seq - 1, nil
+}
+
+func (m *Memory) ReconcileSKU(_ context.Context) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fixed := false
+
+	for _, i := range m.data {
+		if i.Sku >= m.next {
+			m.next = i.Sku + 1
+			fixed = true
+		}
+	}
+
+	return fixed, nil
+}
+
+func (m *Memory) ReserveSKUBlock(_ context.Context, n int) (int, error) {
+	if err := checkBlock(n); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := checkSKU(m.next + n - 1); err != nil {
+		return 0, err
+	}
+
+	start := m.next
+	m.next += n
+	m.allocated += int64(n)
+
+	return start, nil
+}
+
+func (m *Memory) SKUStats(_ context.Context) (*SKUStats, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return newSKUStats(m.next, m.allocated), nil
+}
+
+// Reindex recomputes name_sort for every item, without
+// counting it as a change
+func (m *Memory) Reindex(_ context.Context) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+
+	for _, i := range m.data {
+		if key := sortKey(i.Name, m.collation); key != i.NameSort {
+			i.NameSort = key
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+func (m *Memory) AuditLog(_ context.Context, action, itemID, user string, before, after *model.Item) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.audit = append(m.audit, &AuditEntry{action, itemID, user, time.Now().UTC(), clone(before), clone(after)})
+	return nil
+}
+
+func (m *Memory) ListAudit(_ context.Context, itemID string) ([]*AuditEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := []*AuditEntry{}
+
+	for _, e := range m.audit {
+		if e.ItemID == itemID {
+			c := *e
+			c.Before, c.After = clone(e.Before), clone(e.After)
+			result = append(result, &c)
+		}
+	}
+
+	return result, nil
+}
+
+func (m *Memory) Ping(_ context.Context) error {
+	return nil
+}
+
+func (m *Memory) CheckWrite(_ context.Context) error {
+	return nil
+}
+
+// Close does nothing; there's no connection to close
+func (m *Memory) Close() error {
+	return nil
+}
@@ -0,0 +1,562 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"tutor4/graph/model"
+)
+
+// TestSKUOverflow makes sure we stop at MaxSKU rather than
+// wrapping around into negative or reused SKUs
+func TestSKUOverflow(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	m.next = MaxSKU
+
+	i := &model.Item{Name: "last"}
+
+	if _, err := m.AddItem(ctx, i); err != nil {
+		t.Fatalf("last SKU: %s", err)
+	}
+
+	if i.Sku != MaxSKU {
+		t.Errorf("wanted SKU %d, got %d", MaxSKU, i.Sku)
+	}
+
+	_, err := m.AddItem(ctx, &model.Item{Name: "too many"})
+
+	if !errors.Is(err, ErrSKUExhausted) {
+		t.Errorf("wanted %v, got %v", ErrSKUExhausted, err)
+	}
+
+	if n := len(m.data); n != 1 {
+		t.Errorf("wanted 1 item stored, got %d", n)
+	}
+}
+
+// TestMemoryChanges checks change numbers and tombstones
+func TestMemoryChanges(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	a, b := &model.Item{Name: "a"}, &model.Item{Name: "b"}
+
+	for _, i := range []*model.Item{a, b} {
+		if _, err := m.AddItem(ctx, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c, err := m.ListChanges(ctx, 0)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(c.Items) != 2 || c.Items[0].ID != a.ID || c.NextSeq != b.Seq {
+		t.Errorf("invalid changes: %#v", c)
+	}
+
+	since := c.NextSeq
+
+	if err = m.UpdateItem(ctx, &model.Item{ID: a.ID, Name: "a2", Sku: a.Sku}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = m.DeleteItem(ctx, b.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if c, err = m.ListChanges(ctx, since); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(c.Items) != 1 || c.Items[0].Name != "a2" || len(c.Deleted) != 1 || c.Deleted[0] != b.ID {
+		t.Errorf("invalid changes: %#v", c)
+	}
+}
+
+// TestMemoryListOrder lists by SKU, then by ID
+func TestMemoryListOrder(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	for _, n := range []string{"a", "b", "c", "d"} {
+		if _, err := m.AddItem(ctx, &model.Item{Name: n}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	table := []struct {
+		order string
+		less  func(x, y *model.Item) bool
+	}{
+		{OrderSKU, func(x, y *model.Item) bool { return x.Sku < y.Sku }},
+		{OrderID, func(x, y *model.Item) bool { return x.ID < y.ID }},
+	}
+
+	for _, st := range table {
+		if err := m.SetListOrder(st.order); err != nil {
+			t.Fatal(err)
+		}
+
+		items, err := m.ListItems(ctx)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 1; i < len(items); i++ {
+			if !st.less(items[i-1], items[i]) {
+				t.Errorf("%s: out of order at %d", st.order, i)
+			}
+		}
+	}
+
+	// a page is in SKU order, whatever the list order
+
+	page, err := m.ListItemPage(ctx, 1000, 2, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(page) != 2 || page[0].Sku != 1001 || page[1].Sku != 1002 {
+		t.Errorf("invalid page: %v", page)
+	}
+
+	if err := m.SetListOrder("price"); err == nil {
+		t.Errorf("invalid order accepted")
+	}
+}
+
+// TestReserveSKUBlock reserves blocks at the same time as
+// items are added; no SKU should be handed out twice
+func TestReserveSKUBlock(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+
+	used := make(map[int]bool)
+
+	take := func(sku int) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if used[sku] {
+			t.Errorf("SKU %d used twice", sku)
+		}
+
+		used[sku] = true
+	}
+
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+
+		go func() {
+			defer wg.Done()
+
+			start, err := m.ReserveSKUBlock(ctx, 100)
+
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			for sku := start; sku < start+100; sku++ {
+				take(sku)
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+
+			i := &model.Item{Name: "x"}
+
+			if _, err := m.AddItem(ctx, i); err != nil {
+				t.Error(err)
+				return
+			}
+
+			take(i.Sku)
+		}()
+	}
+
+	wg.Wait()
+
+	if len(used) != 1010 {
+		t.Errorf("wanted 1010 SKUs, got %d", len(used))
+	}
+
+	for _, n := range []int{0, -1, MaxSKUBlock + 1} {
+		if _, err := m.ReserveSKUBlock(ctx, n); err == nil {
+			t.Errorf("reserved %d SKUs", n)
+		}
+	}
+}
+
+// TestSKUIDs names items after their SKUs, and still finds
+// an item added before the switch
+func TestSKUIDs(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	old := &model.Item{Name: "old"}
+
+	if _, err := m.AddItem(ctx, old); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.SetIDScheme(IDSKU); err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := m.AddItem(ctx, &model.Item{Name: "new"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id != "item-001001" {
+		t.Errorf("invalid ID: %s", id)
+	}
+
+	for _, want := range []*model.Item{old, m.data[id]} {
+		i, err := m.GetItemBySKU(ctx, want.Sku)
+
+		if err != nil || i.ID != want.ID {
+			t.Errorf("sku %d: got %v, %v", want.Sku, i, err)
+		}
+	}
+
+	// a client took the next item's ID
+
+	if err = m.CreateWithID(ctx, "item-001003", &model.Item{Name: "mine"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = m.AddItem(ctx, &model.Item{Name: "next"}); !errors.Is(err, ErrExists) {
+		t.Errorf("wanted %v, got %v", ErrExists, err)
+	}
+
+	if err = m.SetIDScheme("random"); err == nil {
+		t.Errorf("invalid scheme accepted")
+	}
+}
+
+// TestMemoryAddItems adds more than one write's worth of
+// items and checks the SKUs are consecutive
+func TestMemoryAddItems(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	if _, err := m.AddItem(ctx, &model.Item{Name: "first"}); err != nil {
+		t.Fatal(err)
+	}
+
+	items := make([]*model.Item, maxBatch+10)
+
+	for n := range items {
+		items[n] = &model.Item{Name: "bulk"}
+	}
+
+	n, err := m.AddItems(ctx, items)
+
+	if err != nil || n != len(items) {
+		t.Fatalf("added %d: %v", n, err)
+	}
+
+	for n, i := range items {
+		if i.Sku != DefaultStartSKU+1+n || i.ID == "" {
+			t.Errorf("item %d: invalid ID or SKU: %#v", n, i)
+		}
+	}
+
+	m.next = MaxSKU
+
+	if _, err = m.AddItems(ctx, items[:2]); !errors.Is(err, ErrSKUExhausted) {
+		t.Errorf("wanted %v, got %v", ErrSKUExhausted, err)
+	}
+
+	if len(m.data) != len(items)+1 {
+		t.Errorf("invalid item count: %d", len(m.data))
+	}
+}
+
+// TestMemoryImportTombstone imports an item with the ID
+// of a deleted one, whose tombstone must go
+func TestMemoryImportTombstone(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	if err := m.SetIDScheme(IDSKU); err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := m.AddItem(ctx, &model.Item{Name: "gone"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = m.DeleteItem(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = m.ImportItems(ctx, []*model.Item{{Name: "back", Sku: DefaultStartSKU}}); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := m.ListChanges(ctx, 0)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(changes.Deleted) != 0 || len(changes.Items) != 1 || changes.Items[0].ID != id {
+		t.Errorf("invalid changes: %#v", changes)
+	}
+}
+
+// TestMemoryImportItems imports items with their own SKUs,
+// one past the counter, and then one that's taken
+func TestMemoryImportItems(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	if _, err := m.AddItem(ctx, &model.Item{Name: "first"}); err != nil {
+		t.Fatal(err)
+	}
+
+	items := []*model.Item{{Name: "old", Sku: 500}, {Name: "far", Sku: 5000}}
+
+	if n, err := m.ImportItems(ctx, items); err != nil || n != 2 {
+		t.Fatalf("imported %d: %v", n, err)
+	}
+
+	for _, i := range items {
+		got, err := m.GetItemBySKU(ctx, i.Sku)
+
+		if err != nil || got.Name != i.Name || got.ID == "" {
+			t.Errorf("SKU %d: got %#v, %v", i.Sku, got, err)
+		}
+	}
+
+	id, err := m.AddItem(ctx, &model.Item{Name: "next"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if i, _ := m.GetItem(ctx, id); i.Sku != 5001 {
+		t.Errorf("counter not moved: new SKU %d", i.Sku)
+	}
+
+	table := [][]*model.Item{
+		{{Name: "taken", Sku: DefaultStartSKU}},
+		{{Name: "new", Sku: 7000}, {Name: "twice", Sku: 7000}},
+	}
+
+	for _, tt := range table {
+		if _, err = m.ImportItems(ctx, tt); !errors.Is(err, ErrDuplicate) || !errors.Is(err, ErrExists) {
+			t.Errorf("%s: wanted %v, got %v", tt[len(tt)-1].Name, ErrDuplicate, err)
+		}
+	}
+
+	if _, err = m.ImportItems(ctx, []*model.Item{{Name: "none"}}); err == nil {
+		t.Errorf("imported an item without a SKU")
+	}
+
+	if len(m.data) != 4 {
+		t.Errorf("invalid item count: %d", len(m.data))
+	}
+}
+
+// TestMemoryImportRace imports the same SKU from many
+// goroutines at once; only one may get it
+func TestMemoryImportRace(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	const writers = 10
+
+	var wg sync.WaitGroup
+
+	errs := make(chan error, writers)
+
+	for n := 0; n < writers; n++ {
+		wg.Add(1)
+
+		go func(n int) {
+			defer wg.Done()
+
+			_, err := m.ImportItems(ctx, []*model.Item{{Name: fmt.Sprintf("writer %d", n), Sku: 1042}})
+			errs <- err
+		}(n)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	won := 0
+
+	for err := range errs {
+		switch {
+		case err == nil:
+			won++
+		case !errors.Is(err, ErrDuplicate):
+			t.Errorf("wanted %v, got %v", ErrDuplicate, err)
+		}
+	}
+
+	if won != 1 || len(m.data) != 1 {
+		t.Errorf("%d imports won, %d items", won, len(m.data))
+	}
+}
+
+// TestMemoryConcurrent reads and writes from many
+// goroutines; run it with -race. Each write keeps Name,
+// ExternalKey and the tag equal, so a torn read would show,
+// and readers scribble on what they get back, which mustn't
+// reach the store
+func TestMemoryConcurrent(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	i := &model.Item{Name: "v0", ExternalKey: "v0", Tags: []string{"v0"}}
+
+	if _, err := m.AddItem(ctx, i); err != nil {
+		t.Fatal(err)
+	}
+
+	id := i.ID
+
+	var wg sync.WaitGroup
+
+	for w := 0; w < 4; w++ {
+		wg.Add(2)
+
+		go func(w int) {
+			defer wg.Done()
+
+			for n := 0; n < 200; n++ {
+				v := fmt.Sprintf("v%d-%d", w, n)
+				_ = m.UpdateItem(ctx, &model.Item{ID: id, Name: v, ExternalKey: v, Tags: []string{v}})
+			}
+		}(w)
+
+		go func() {
+			defer wg.Done()
+
+			for n := 0; n < 200; n++ {
+				got, err := m.GetItem(ctx, id)
+
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				if got.Name != got.ExternalKey || len(got.Tags) != 1 || got.Tags[0] != got.Name {
+					t.Errorf("torn read: %#v", got)
+				}
+
+				got.Name = "mine"
+				got.Tags[0] = "mine"
+
+				items, _ := m.ListItems(ctx)
+				items[0].ExternalKey = "mine"
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	got, _ := m.GetItem(ctx, id)
+
+	if got.Name == "mine" || got.ExternalKey == "mine" || got.Tags[0] == "mine" {
+		t.Errorf("a caller changed the store: %#v", got)
+	}
+}
+
+// TestSKUStart opens the memory backend at a different
+// first SKU, and rejects ones we couldn't hand out
+func TestSKUStart(t *testing.T) {
+	ctx := context.Background()
+	d, err := Open("memory", map[string]string{"skuStart": "100000"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i := &model.Item{Name: "first"}
+
+	if _, err = d.AddItem(ctx, i); err != nil {
+		t.Fatal(err)
+	}
+
+	if i.Sku != 100000 {
+		t.Errorf("wanted SKU 100000, got %d", i.Sku)
+	}
+
+	for _, s := range []string{"0", "-5", "x", fmt.Sprint(int64(MaxSKU) + 1)} {
+		if _, err = Open("memory", map[string]string{"skuStart": s}); err == nil {
+			t.Errorf("%s: invalid start accepted", s)
+		}
+	}
+}
+
+// TestMemoryAmbiguousSKU gives two items the same SKU, as
+// a bad import could
+func TestMemoryAmbiguousSKU(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	a, b := &model.Item{Name: "a"}, &model.Item{Name: "b"}
+
+	for _, i := range []*model.Item{a, b} {
+		if _, err := m.AddItem(ctx, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m.data[b.ID].Sku = a.Sku
+
+	if _, err := m.GetItemBySKU(ctx, a.Sku); !errors.Is(err, ErrAmbiguous) {
+		t.Errorf("wanted %v, got %v", ErrAmbiguous, err)
+	}
+
+	first := a.ID
+
+	if b.ID < first {
+		first = b.ID
+	}
+
+	i, err := m.GetItemBySKU(WithAnyMatch(ctx), a.Sku)
+
+	if err != nil || i.ID != first {
+		t.Errorf("any: wanted %s, got %v, %v", first, i, err)
+	}
+
+	// the same goes for a batch
+
+	batch, err := m.GetItemsBySKUs(ctx, []int{a.Sku, a.Sku + 1})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(batch.Items) != 0 || !errors.Is(batch.Errors[a.Sku], ErrAmbiguous) {
+		t.Errorf("batch: got %v, %v", batch.Items, batch.Errors)
+	}
+
+	batch, _ = m.GetItemsBySKUs(WithAnyMatch(ctx), []int{a.Sku})
+
+	if len(batch.Errors) != 0 || batch.Items[a.Sku] == nil || batch.Items[a.Sku].ID != first {
+		t.Errorf("any batch: got %v, %v", batch.Items, batch.Errors)
+	}
+}
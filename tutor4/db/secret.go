@@ -0,0 +1,218 @@
+package db
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+
+	"tutor4/graph/model"
+)
+
+// an item field tagged api:"secret" is stored encrypted
+// (AES-GCM) so Firestore never sees the plaintext; only a
+// context marked WithSecrets gets it back decrypted, and
+// everyone else gets the field empty
+//
+// the sealed form is a prefix and the base64 nonce and
+// ciphertext, e.g. "enc:v1:..."; the field name is bound
+// in as extra data, so a value can't be moved to another
+// secret field
+const sealedPrefix = "enc:v1:"
+
+var ErrNoKey = errors.New("no encryption key")
+
+// secretFields are the indexes of the secret fields of
+// model.Item, which must be strings
+var secretFields = findSecrets(reflect.TypeOf(model.Item{}))
+
+func findSecrets(t reflect.Type) []int {
+	var result []int
+
+	for n := 0; n < t.NumField(); n++ {
+		f := t.Field(n)
+
+		if f.Tag.Get("api") != "secret" {
+			continue
+		}
+
+		if f.Type.Kind() != reflect.String {
+			panic(fmt.Sprintf("secret field %s isn't a string", f.Name))
+		}
+
+		result = append(result, n)
+	}
+
+	return result
+}
+
+type secretsKey struct{}
+
+// WithSecrets lets the caller read secret fields
+func WithSecrets(ctx context.Context) context.Context {
+	return context.WithValue(ctx, secretsKey{}, true)
+}
+
+// CanSee says if the caller may read secret fields
+func CanSee(ctx context.Context) bool {
+	ok, _ := ctx.Value(secretsKey{}).(bool)
+	return ok
+}
+
+type sealer struct {
+	aead cipher.AEAD
+}
+
+// newSealer takes an AES key of 16, 24 or 32 bytes
+func newSealer(key []byte) (*sealer, error) {
+	block, err := aes.NewCipher(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &sealer{aead}, nil
+}
+
+func (s *sealer) seal(field, plain string) (string, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	out := s.aead.Seal(nonce, nonce, []byte(plain), []byte(field))
+
+	return sealedPrefix + base64.StdEncoding.EncodeToString(out), nil
+}
+
+func (s *sealer) open(field, sealed string) (string, error) {
+	if !strings.HasPrefix(sealed, sealedPrefix) {
+		return "", fmt.Errorf("%s isn't sealed", field)
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(sealed[len(sealedPrefix):])
+
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", field, err)
+	}
+
+	n := s.aead.NonceSize()
+
+	if len(raw) < n {
+		return "", fmt.Errorf("%s is too short", field)
+	}
+
+	plain, err := s.aead.Open(nil, raw[:n], raw[n:], []byte(field))
+
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", field, err)
+	}
+
+	return string(plain), nil
+}
+
+// sealItem returns what to store for i: i itself if it has
+// no secrets, or else a copy with them sealed, so that the
+// caller still has the plaintext
+func (s *sealer) sealItem(i *model.Item) (*model.Item, error) {
+	if i == nil {
+		return nil, nil
+	}
+
+	v := reflect.ValueOf(i).Elem()
+	var out reflect.Value
+
+	for _, n := range secretFields {
+		plain := v.Field(n).String()
+
+		if plain == "" {
+			continue
+		}
+
+		name := v.Type().Field(n).Name
+
+		if s == nil {
+			return nil, fmt.Errorf("%s: %w", name, ErrNoKey)
+		}
+
+		sealed, err := s.seal(name, plain)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !out.IsValid() {
+			c := *i
+			out = reflect.ValueOf(&c).Elem()
+		}
+
+		out.Field(n).SetString(sealed)
+	}
+
+	if !out.IsValid() {
+		return i, nil
+	}
+
+	return out.Addr().Interface().(*model.Item), nil
+}
+
+// openItem decrypts the secrets of an item we've read if
+// the caller may see them, and otherwise clears them; one
+// we can't decrypt is logged and cleared, so the rest of
+// the item can still be read
+func (s *sealer) openItem(ctx context.Context, i *model.Item) {
+	if i == nil {
+		return
+	}
+
+	v := reflect.ValueOf(i).Elem()
+	reveal := s != nil && CanSee(ctx)
+
+	for _, n := range secretFields {
+		f := v.Field(n)
+
+		if f.String() == "" {
+			continue
+		}
+
+		if !reveal {
+			f.SetString("")
+			continue
+		}
+
+		name := v.Type().Field(n).Name
+		plain, err := s.open(name, f.String())
+
+		if err != nil {
+			log.Printf("ERROR item %s: %s", i.ID, err)
+		}
+
+		f.SetString(plain)
+	}
+}
+
+// SetKey turns on encryption of secret fields; without a
+// key, writing one fails with ErrNoKey
+func (c *Client) SetKey(key []byte) error {
+	s, err := newSealer(key)
+
+	if err != nil {
+		return fmt.Errorf("invalid encryption key: %w", err)
+	}
+
+	c.sealer = s
+	return nil
+}
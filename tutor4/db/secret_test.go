@@ -0,0 +1,82 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"tutor4/graph/model"
+)
+
+// TestSealItem round-trips a secret field and checks what
+// we'd store is ciphertext
+func TestSealItem(t *testing.T) {
+	s, err := newSealer(bytes.Repeat([]byte{7}, 32))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i := &model.Item{ID: "x", Name: "widget", SupplierCost: "12.50"}
+	stored, err := s.sealItem(i)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stored == i || i.SupplierCost != "12.50" {
+		t.Fatalf("sealed the caller's item: %#v", i)
+	}
+
+	if !strings.HasPrefix(stored.SupplierCost, sealedPrefix) || strings.Contains(stored.SupplierCost, "12.50") {
+		t.Errorf("not ciphertext: %s", stored.SupplierCost)
+	}
+
+	if stored.Name != "widget" {
+		t.Errorf("sealed too much: %#v", stored)
+	}
+
+	read := *stored
+	s.openItem(WithSecrets(context.Background()), &read)
+
+	if read.SupplierCost != "12.50" {
+		t.Errorf("invalid round trip: %s", read.SupplierCost)
+	}
+
+	read = *stored
+	s.openItem(context.Background(), &read)
+
+	if read.SupplierCost != "" {
+		t.Errorf("revealed without permission: %s", read.SupplierCost)
+	}
+
+	// the wrong key (or a tampered value) reads as empty
+
+	other, _ := newSealer(bytes.Repeat([]byte{8}, 32))
+	read = *stored
+	other.openItem(WithSecrets(context.Background()), &read)
+
+	if read.SupplierCost != "" {
+		t.Errorf("opened with the wrong key: %s", read.SupplierCost)
+	}
+
+	// no secrets, nothing to copy
+
+	plain := &model.Item{Name: "plain"}
+
+	if out, _ := s.sealItem(plain); out != plain {
+		t.Errorf("copied an item with no secrets")
+	}
+
+	var none *sealer
+
+	if _, err = none.sealItem(i); !errors.Is(err, ErrNoKey) {
+		t.Errorf("wanted %v, got %v", ErrNoKey, err)
+	}
+
+	if _, err = newSealer([]byte("short")); err == nil {
+		t.Errorf("accepted a bad key")
+	}
+}
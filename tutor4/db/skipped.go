@@ -0,0 +1,37 @@
+package db
+
+import "log"
+
+// maxDecodeLogs is how many bad documents one read logs
+// before it just counts them; a bad import could leave
+// thousands, and one line each would bury everything else
+const maxDecodeLogs = 5
+
+// skipLog tracks the documents a read couldn't decode
+type skipLog struct {
+	what    string
+	skipped int
+	total   int
+}
+
+// add counts a document that decoded (err is nil) or not
+func (s *skipLog) add(id string, err error) {
+	s.total++
+
+	if err == nil {
+		return
+	}
+
+	s.skipped++
+
+	if s.skipped <= maxDecodeLogs {
+		log.Printf("item %s decode: %s", id, err)
+	}
+}
+
+// done logs a summary line if anything was skipped
+func (s *skipLog) done() {
+	if s.skipped > 0 {
+		log.Printf("WARN %s: skipped %d of %d documents", s.what, s.skipped, s.total)
+	}
+}
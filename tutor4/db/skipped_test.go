@@ -0,0 +1,57 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestSkipLog feeds in lots of bad documents and checks
+// the log stays short
+func TestSkipLog(t *testing.T) {
+	var buf bytes.Buffer
+
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	s := skipLog{what: "list"}
+	bad := errors.New("bad document")
+
+	for n := 0; n < 3000; n++ {
+		var err error
+
+		if n%3 != 0 {
+			err = bad
+		}
+
+		s.add(fmt.Sprintf("doc-%d", n), err)
+	}
+
+	s.done()
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+
+	if len(lines) != maxDecodeLogs+1 {
+		t.Fatalf("wanted %d lines, got %d", maxDecodeLogs+1, len(lines))
+	}
+
+	if last := lines[len(lines)-1]; !strings.Contains(last, "skipped 2000 of 3000 documents") {
+		t.Errorf("invalid summary: %s", last)
+	}
+
+	// a clean read logs nothing
+
+	buf.Reset()
+
+	s = skipLog{what: "list"}
+	s.add("ok", nil)
+	s.done()
+
+	if buf.Len() != 0 {
+		t.Errorf("logged a clean read: %s", buf.String())
+	}
+}
@@ -0,0 +1,93 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// the ways the Firestore client can hand out SKUs
+const (
+	// SKUTransaction reads and bumps the SKU counter in
+	// the transaction that creates each item
+	SKUTransaction = "transaction"
+
+	// SKUBatched reserves skuChunk SKUs at a time and
+	// hands them out in-process, so creates don't all
+	// contend for the counter document
+	//
+	// the counter is bumped when a chunk is reserved, so
+	// it's always ahead of what we've used and there's
+	// nothing to write back; but whatever's left of the
+	// chunk when the process dies is never used, so there
+	// are gaps in the SKUs, and with several instances
+	// SKUs are no longer in the order items were created;
+	// it also can't use the last few SKUs before MaxSKU
+	//
+	// each create still claims a change number in its
+	// transaction, since those must be in commit order, so
+	// creates still queue on the change counter document;
+	// batching saves the SKU counter's read and write, but
+	// doesn't let creates run in parallel
+	SKUBatched = "batched"
+)
+
+// skuChunk is how many SKUs SKUBatched reserves at once
+const skuChunk = 1000
+
+func checkSKUMode(mode string) error {
+	if mode != SKUTransaction && mode != SKUBatched {
+		return fmt.Errorf("invalid SKU mode: %s", mode)
+	}
+
+	return nil
+}
+
+// skuBlocks hands out SKUs from blocks it reserves as it
+// needs them
+type skuBlocks struct {
+	mu      sync.Mutex
+	next    int // the next SKU to hand out
+	end     int // one past the last of the block
+	size    int
+	reserve func(context.Context, int) (int, error)
+}
+
+// take returns the next SKU, reserving a block if we've
+// run out; only one caller reserves at a time, and the
+// rest wait for it
+func (s *skuBlocks) take(ctx context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.next == s.end {
+		start, err := s.reserve(ctx, s.size)
+
+		if err != nil {
+			return 0, err
+		}
+
+		s.next, s.end = start, start+s.size
+	}
+
+	sku := s.next
+	s.next++
+
+	return sku, nil
+}
+
+// SetSKUMode picks how AddItem gets SKUs, either
+// SKUTransaction (the default) or SKUBatched
+func (c *Client) SetSKUMode(mode string) error {
+	if err := checkSKUMode(mode); err != nil {
+		return err
+	}
+
+	c.skus = nil
+
+	if mode == SKUBatched {
+		c.skus = &skuBlocks{size: skuChunk, reserve: c.ReserveSKUBlock}
+	}
+
+	return nil
+}
@@ -0,0 +1,119 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"tutor4/graph/model"
+)
+
+// TestSKUBlocks takes SKUs from several goroutines; they
+// must all differ, and come from as few blocks as can be
+func TestSKUBlocks(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	reserved := 0
+
+	s := skuBlocks{size: 10, reserve: func(ctx context.Context, n int) (int, error) {
+		reserved++ // under s.mu
+		return m.ReserveSKUBlock(ctx, n)
+	}}
+
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		seen = map[int]bool{}
+	)
+
+	for g := 0; g < 5; g++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for n := 0; n < 19; n++ {
+				sku, err := s.take(ctx)
+
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				mu.Lock()
+
+				if seen[sku] {
+					t.Errorf("SKU %d handed out twice", sku)
+				}
+
+				seen[sku] = true
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if len(seen) != 95 || reserved != 10 {
+		t.Errorf("wanted 95 SKUs from 10 blocks, got %d from %d", len(seen), reserved)
+	}
+
+	// the counter is past everything we handed out
+
+	if sku, _ := m.ReserveSKUBlock(ctx, 1); sku != DefaultStartSKU+100 {
+		t.Errorf("wanted counter at %d, got %d", DefaultStartSKU+100, sku)
+	}
+
+	if err := checkSKUMode("random"); err == nil {
+		t.Errorf("invalid SKU mode accepted")
+	}
+}
+
+// BenchmarkSKUAllocation creates items in parallel with
+// each SKU mode; it needs a Firestore emulator, in
+// FIRESTORE_EMULATOR_HOST, and is skipped otherwise
+//
+// both modes still take turns on the change counter, so
+// expect batched to be only a little faster
+func BenchmarkSKUAllocation(b *testing.B) {
+	if os.Getenv("FIRESTORE_EMULATOR_HOST") == "" {
+		b.Skip("no Firestore emulator")
+	}
+
+	ctx := context.Background()
+
+	for _, mode := range []string{SKUTransaction, SKUBatched} {
+		mode := mode
+
+		b.Run(mode, func(b *testing.B) {
+			// fresh collections, so each run starts with
+			// new counters
+
+			name := fmt.Sprintf("bench-%s-%d", mode, time.Now().UnixNano())
+			c, err := NewClient("tutor-dev", name, name+"-util", DefaultStartSKU)
+
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			defer c.Close()
+
+			if err = c.SetSKUMode(mode); err != nil {
+				b.Fatal(err)
+			}
+
+			b.ResetTimer()
+
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := c.AddItem(ctx, &model.Item{Name: "bench"}); err != nil {
+						b.Error(err)
+					}
+				}
+			})
+		})
+	}
+}
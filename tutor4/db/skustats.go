@@ -0,0 +1,56 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+)
+
+// SKUStats is how fast SKUs are being used up, so an
+// operator can see how long the rest will last
+type SKUStats struct {
+	// Next is the SKU the counter will hand out next
+	Next int `json:"next"`
+
+	// AllocatedSinceStart is how many SKUs this process
+	// has taken from the counter, whether for new items or
+	// reserved (with SKUBatched, a whole block at a time)
+	AllocatedSinceStart int64 `json:"allocatedSinceStart"`
+
+	// Remaining is how many SKUs are left, including Next
+	Remaining int `json:"remaining"`
+}
+
+func newSKUStats(next int, allocated int64) *SKUStats {
+	remaining := MaxSKU - next + 1
+
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	return &SKUStats{Next: next, AllocatedSinceStart: allocated, Remaining: remaining}
+}
+
+// took counts n SKUs taken from the counter
+func (c *Client) took(n int) {
+	atomic.AddInt64(&c.allocated, int64(n))
+}
+
+// SKUStats reads the SKU counter; the count is only of
+// what this process allocated, as other instances share
+// the counter
+func (c *Client) SKUStats(ctx context.Context) (*SKUStats, error) {
+	doc, err := c.util.Doc(skuDoc).Get(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	next, ok := doc.Data()[nextField].(int64)
+
+	if !ok {
+		return nil, fmt.Errorf("can't read %s %s", skuDoc, nextField)
+	}
+
+	return newSKUStats(int(next), atomic.LoadInt64(&c.allocated)), nil
+}
@@ -0,0 +1,173 @@
+package db
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"tutor4/graph/model"
+)
+
+// slowDB logs any call to the underlying DB that takes
+// longer than the threshold; it's not tracing, just a
+// cheap way to spot Firestore latency
+type slowDB struct {
+	DB
+	threshold time.Duration
+}
+
+// WithSlowLog wraps d so slow calls are logged; a zero
+// threshold means there's nothing to do
+func WithSlowLog(d DB, threshold time.Duration) DB {
+	if threshold <= 0 {
+		return d
+	}
+
+	return &slowDB{DB: d, threshold: threshold}
+}
+
+func (s *slowDB) timed(start time.Time, method string, arg interface{}) {
+	if took := time.Since(start); took > s.threshold {
+		log.Printf("WARN slow %s(%v) took %s", method, arg, took)
+	}
+}
+
+func (s *slowDB) AddItem(ctx context.Context, i *model.Item) (string, error) {
+	defer s.timed(time.Now(), "AddItem", i.Name)
+	return s.DB.AddItem(ctx, i)
+}
+
+func (s *slowDB) AddItems(ctx context.Context, items []*model.Item) (int, error) {
+	defer s.timed(time.Now(), "AddItems", len(items))
+	return s.DB.AddItems(ctx, items)
+}
+
+func (s *slowDB) CreateWithID(ctx context.Context, id string, i *model.Item) error {
+	defer s.timed(time.Now(), "CreateWithID", id)
+	return s.DB.CreateWithID(ctx, id, i)
+}
+
+func (s *slowDB) Upsert(ctx context.Context, items []*model.Item) (int, int, error) {
+	defer s.timed(time.Now(), "Upsert", len(items))
+	return s.DB.Upsert(ctx, items)
+}
+
+func (s *slowDB) GetItem(ctx context.Context, id string) (*model.Item, error) {
+	defer s.timed(time.Now(), "GetItem", id)
+	return s.DB.GetItem(ctx, id)
+}
+
+func (s *slowDB) GetItems(ctx context.Context, ids []string) (*ItemBatch, error) {
+	defer s.timed(time.Now(), "GetItems", len(ids))
+	return s.DB.GetItems(ctx, ids)
+}
+
+func (s *slowDB) GetItemBySKU(ctx context.Context, sku int) (*model.Item, error) {
+	defer s.timed(time.Now(), "GetItemBySKU", sku)
+	return s.DB.GetItemBySKU(ctx, sku)
+}
+
+func (s *slowDB) GetItemsBySKUs(ctx context.Context, skus []int) (*SKUBatch, error) {
+	defer s.timed(time.Now(), "GetItemsBySKUs", skus)
+	return s.DB.GetItemsBySKUs(ctx, skus)
+}
+
+func (s *slowDB) ListItems(ctx context.Context) ([]*model.Item, error) {
+	defer s.timed(time.Now(), "ListItems", "")
+	return s.DB.ListItems(ctx)
+}
+
+func (s *slowDB) ListItemsFields(ctx context.Context, fields []string) ([]*model.Item, error) {
+	defer s.timed(time.Now(), "ListItemsFields", fields)
+	return s.DB.ListItemsFields(ctx, fields)
+}
+
+func (s *slowDB) ListItemsByTags(ctx context.Context, tags []string) ([]*model.Item, error) {
+	defer s.timed(time.Now(), "ListItemsByTags", tags)
+	return s.DB.ListItemsByTags(ctx, tags)
+}
+
+func (s *slowDB) ListSKUs(ctx context.Context) (map[string]string, error) {
+	defer s.timed(time.Now(), "ListSKUs", "")
+	return s.DB.ListSKUs(ctx)
+}
+
+func (s *slowDB) ListSKUPage(ctx context.Context, after, limit int) ([]*model.SkuEntry, error) {
+	defer s.timed(time.Now(), "ListSKUPage", after)
+	return s.DB.ListSKUPage(ctx, after, limit)
+}
+
+func (s *slowDB) ListItemPage(ctx context.Context, after, limit int, fields []string) ([]*model.Item, error) {
+	defer s.timed(time.Now(), "ListItemPage", after)
+	return s.DB.ListItemPage(ctx, after, limit, fields)
+}
+
+func (s *slowDB) ListChanges(ctx context.Context, since int) (*Changes, error) {
+	defer s.timed(time.Now(), "ListChanges", since)
+	return s.DB.ListChanges(ctx, since)
+}
+
+func (s *slowDB) UpdateItem(ctx context.Context, i *model.Item) error {
+	defer s.timed(time.Now(), "UpdateItem", i.ID)
+	return s.DB.UpdateItem(ctx, i)
+}
+
+func (s *slowDB) DeleteItem(ctx context.Context, id string) error {
+	defer s.timed(time.Now(), "DeleteItem", id)
+	return s.DB.DeleteItem(ctx, id)
+}
+
+func (s *slowDB) LastSeq(ctx context.Context) (int, error) {
+	defer s.timed(time.Now(), "LastSeq", "")
+	return s.DB.LastSeq(ctx)
+}
+
+func (s *slowDB) AuditLog(ctx context.Context, action, itemID, user string, before, after *model.Item) error {
+	defer s.timed(time.Now(), "AuditLog", itemID)
+	return s.DB.AuditLog(ctx, action, itemID, user, before, after)
+}
+
+func (s *slowDB) ListAudit(ctx context.Context, itemID string) ([]*AuditEntry, error) {
+	defer s.timed(time.Now(), "ListAudit", itemID)
+	return s.DB.ListAudit(ctx, itemID)
+}
+
+func (s *slowDB) ReconcileSKU(ctx context.Context) (bool, error) {
+	defer s.timed(time.Now(), "ReconcileSKU", "")
+	return s.DB.ReconcileSKU(ctx)
+}
+
+func (s *slowDB) ReserveSKUBlock(ctx context.Context, n int) (int, error) {
+	defer s.timed(time.Now(), "ReserveSKUBlock", n)
+	return s.DB.ReserveSKUBlock(ctx, n)
+}
+
+func (s *slowDB) SKUStats(ctx context.Context) (*SKUStats, error) {
+	defer s.timed(time.Now(), "SKUStats", "")
+	return s.DB.SKUStats(ctx)
+}
+
+func (s *slowDB) Reindex(ctx context.Context) (int, error) {
+	defer s.timed(time.Now(), "Reindex", "")
+	return s.DB.Reindex(ctx)
+}
+
+func (s *slowDB) Restore(ctx context.Context, items []*model.Item) (int, error) {
+	defer s.timed(time.Now(), "Restore", len(items))
+	return s.DB.Restore(ctx, items)
+}
+
+func (s *slowDB) ImportItems(ctx context.Context, items []*model.Item) (int, error) {
+	defer s.timed(time.Now(), "ImportItems", len(items))
+	return s.DB.ImportItems(ctx, items)
+}
+
+func (s *slowDB) Ping(ctx context.Context) error {
+	defer s.timed(time.Now(), "Ping", "")
+	return s.DB.Ping(ctx)
+}
+
+func (s *slowDB) CheckWrite(ctx context.Context) error {
+	defer s.timed(time.Now(), "CheckWrite", "")
+	return s.DB.CheckWrite(ctx)
+}
@@ -0,0 +1,53 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"tutor4/graph/model"
+)
+
+// sleepyDB only implements what the test calls
+type sleepyDB struct {
+	DB
+	delay time.Duration
+}
+
+func (s *sleepyDB) GetItem(_ context.Context, id string) (*model.Item, error) {
+	time.Sleep(s.delay)
+	return &model.Item{ID: id}, nil
+}
+
+func TestSlowLog(t *testing.T) {
+	var buf bytes.Buffer
+
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	d := WithSlowLog(&sleepyDB{delay: 20 * time.Millisecond}, 10*time.Millisecond)
+
+	if _, err := d.GetItem(context.Background(), "abc"); err != nil {
+		t.Fatal(err)
+	}
+
+	if out := buf.String(); !strings.Contains(out, "slow GetItem(abc)") {
+		t.Errorf("invalid log: %q", out)
+	}
+
+	buf.Reset()
+
+	d = WithSlowLog(&sleepyDB{}, time.Second)
+
+	if _, err := d.GetItem(context.Background(), "abc"); err != nil {
+		t.Fatal(err)
+	}
+
+	if out := buf.String(); out != "" {
+		t.Errorf("invalid log: %q", out)
+	}
+}
@@ -0,0 +1,144 @@
+package db
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"tutor4/graph/model"
+)
+
+// staleDB serves ListItems from a snapshot that may be up
+// to ttl old, trading freshness for fewer Firestore reads;
+// our own writes drop the snapshot, but writes made by
+// other instances won't show up until it expires
+//
+// LastSeq comes from the snapshot too, so it always
+// describes the list we'd return
+type staleDB struct {
+	DB
+	ttl time.Duration
+
+	mu    sync.Mutex
+	items []*model.Item
+	seq   int
+	at    time.Time
+}
+
+// WithStaleList wraps d so item lists may be cached for
+// ttl; zero means every list goes to the DB
+func WithStaleList(d DB, ttl time.Duration) DB {
+	if ttl <= 0 {
+		return d
+	}
+
+	return &staleDB{DB: d, ttl: ttl}
+}
+
+func (s *staleDB) invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.items = nil
+}
+
+// ListItems holds the lock while it reads so that a burst
+// of requests after the snapshot expires makes one read,
+// not one each; callers must not modify what it returns
+func (s *staleDB) ListItems(ctx context.Context) ([]*model.Item, error) {
+	// the snapshot has no secrets in it, so callers who may
+	// see them always read
+
+	if CanSee(ctx) {
+		return s.DB.ListItems(ctx)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.fresh() {
+		return s.items, nil
+	}
+
+	// we read the change number first, so a write that
+	// lands in between makes it look older, not newer
+
+	seq, err := s.DB.LastSeq(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := s.DB.ListItems(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	s.items, s.seq, s.at = items, seq, time.Now()
+
+	return items, nil
+}
+
+// fresh assumes the lock is held
+func (s *staleDB) fresh() bool {
+	return s.items != nil && time.Since(s.at) < s.ttl
+}
+
+func (s *staleDB) LastSeq(ctx context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.fresh() {
+		return s.seq, nil
+	}
+
+	return s.DB.LastSeq(ctx)
+}
+
+func (s *staleDB) AddItem(ctx context.Context, i *model.Item) (string, error) {
+	defer s.invalidate()
+	return s.DB.AddItem(ctx, i)
+}
+
+func (s *staleDB) AddItems(ctx context.Context, items []*model.Item) (int, error) {
+	defer s.invalidate()
+	return s.DB.AddItems(ctx, items)
+}
+
+func (s *staleDB) CreateWithID(ctx context.Context, id string, i *model.Item) error {
+	defer s.invalidate()
+	return s.DB.CreateWithID(ctx, id, i)
+}
+
+func (s *staleDB) Upsert(ctx context.Context, items []*model.Item) (int, int, error) {
+	defer s.invalidate()
+	return s.DB.Upsert(ctx, items)
+}
+
+func (s *staleDB) UpdateItem(ctx context.Context, i *model.Item) error {
+	defer s.invalidate()
+	return s.DB.UpdateItem(ctx, i)
+}
+
+func (s *staleDB) DeleteItem(ctx context.Context, id string) error {
+	defer s.invalidate()
+	return s.DB.DeleteItem(ctx, id)
+}
+
+func (s *staleDB) Restore(ctx context.Context, items []*model.Item) (int, error) {
+	defer s.invalidate()
+	return s.DB.Restore(ctx, items)
+}
+
+func (s *staleDB) ImportItems(ctx context.Context, items []*model.Item) (int, error) {
+	defer s.invalidate()
+	return s.DB.ImportItems(ctx, items)
+}
+
+// Reindex doesn't take a change number, so a cached
+// list by name wouldn't know it was out of date
+func (s *staleDB) Reindex(ctx context.Context) (int, error) {
+	defer s.invalidate()
+	return s.DB.Reindex(ctx)
+}
@@ -0,0 +1,82 @@
+package db
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"tutor4/graph/model"
+)
+
+// countingDB counts the calls that reach the "database"
+type countingDB struct {
+	DB
+	lists int
+	gets  int32
+	delay time.Duration
+}
+
+func (c *countingDB) GetItem(_ context.Context, id string) (*model.Item, error) {
+	atomic.AddInt32(&c.gets, 1)
+	time.Sleep(c.delay)
+
+	return &model.Item{ID: id}, nil
+}
+
+func (c *countingDB) ListItems(_ context.Context) ([]*model.Item, error) {
+	c.lists++
+	return []*model.Item{}, nil
+}
+
+func (c *countingDB) LastSeq(_ context.Context) (int, error) {
+	return c.lists, nil
+}
+
+func (c *countingDB) AddItem(_ context.Context, i *model.Item) (string, error) {
+	return "x", nil
+}
+
+func TestStaleList(t *testing.T) {
+	ctx := context.Background()
+	c := &countingDB{}
+	d := WithStaleList(c, 50*time.Millisecond)
+
+	for i := 0; i < 5; i++ {
+		if _, err := d.ListItems(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if c.lists != 1 {
+		t.Errorf("cached: wanted 1 read, got %d", c.lists)
+	}
+
+	// a write of our own drops the snapshot
+
+	if _, err := d.AddItem(ctx, &model.Item{Name: "x"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := d.ListItems(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if c.lists != 2 {
+		t.Errorf("after write: wanted 2 reads, got %d", c.lists)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+
+	if _, err := d.ListItems(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if c.lists != 3 {
+		t.Errorf("expired: wanted 3 reads, got %d", c.lists)
+	}
+
+	if WithStaleList(c, 0) != DB(c) {
+		t.Errorf("zero ttl should not wrap")
+	}
+}
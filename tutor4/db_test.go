@@ -0,0 +1,603 @@
+package tutor4
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+
+	"tutor4/db"
+	"tutor4/graph/model"
+)
+
+var (
+	errShouldFail = errors.New("mock should fail")
+	errInvalid    = errors.New("invalid operation")
+)
+
+// mockDB is not thread-safe; we expect to run
+// UTs one at a time or with their own mock
+type mockDB struct {
+	data    map[string]*model.Item
+	next    int
+	fail    bool
+	failErr error // what to fail with, if not errShouldFail
+	batches int
+	delay   time.Duration
+	seq     int
+	tombs   map[string]int
+	fields  []string // the last projection asked for
+	audit   []*db.AuditEntry
+	noAudit bool            // AuditLog fails
+	broken  map[string]bool // IDs of items that don't decode
+	closed  int             // how many times Close was called
+	pages   int             // how many times ListItemPage was called
+}
+
+func (m *mockDB) failure() error {
+	if m.failErr != nil {
+		return m.failErr
+	}
+
+	return errShouldFail
+}
+
+func (m *mockDB) AddItem(_ context.Context, i *model.Item) (string, error) {
+	if m.fail {
+		return "", m.failure()
+	}
+
+	if m.data == nil {
+		m.data = make(map[string]*model.Item)
+		m.next = 1000
+	} else if i.ID != "" {
+		return "", errInvalid
+	}
+
+add:
+	i.ID = uuid.New().String()
+
+	if _, ok := m.data[i.ID]; ok {
+		goto add
+	}
+
+	i.Sku = m.next
+	m.put(i)
+
+	m.next++
+
+	return i.ID, nil
+}
+
+func (m *mockDB) AddItems(ctx context.Context, items []*model.Item) (int, error) {
+	for n, i := range items {
+		if _, err := m.AddItem(ctx, i); err != nil {
+			return n, err
+		}
+	}
+
+	return len(items), nil
+}
+
+func (m *mockDB) CreateWithID(_ context.Context, id string, i *model.Item) error {
+	if m.fail {
+		return m.failure()
+	}
+
+	if m.data == nil {
+		m.data = make(map[string]*model.Item)
+		m.next = 1000
+	}
+
+	if _, ok := m.data[id]; ok {
+		return fmt.Errorf("%s: %w", id, db.ErrExists)
+	}
+
+	i.ID = id
+	i.Sku = m.next
+	m.put(i)
+
+	m.next++
+
+	return nil
+}
+
+func (m *mockDB) Upsert(ctx context.Context, items []*model.Item) (created, updated int, err error) {
+	if m.fail {
+		return 0, 0, m.failure()
+	}
+
+	if m.data == nil {
+		m.data = make(map[string]*model.Item)
+		m.next = 1000
+	}
+
+outer:
+	for _, i := range items {
+		for _, v := range m.data {
+			if v.ExternalKey == i.ExternalKey {
+				i.ID, i.Sku = v.ID, v.Sku
+				m.put(i)
+				updated++
+				continue outer
+			}
+		}
+
+		i.ID = ""
+
+		if _, err := m.AddItem(ctx, i); err != nil {
+			return created, updated, err
+		}
+
+		created++
+	}
+
+	return created, updated, nil
+}
+
+func (m *mockDB) GetItem(_ context.Context, id string) (*model.Item, error) {
+	if m.fail {
+		return nil, m.failure()
+	}
+
+	if i, ok := m.data[id]; ok {
+		return i, nil
+	}
+
+	return nil, db.ErrNotFound
+}
+
+func (m *mockDB) GetItems(_ context.Context, ids []string) (*db.ItemBatch, error) {
+	if m.fail {
+		return nil, m.failure()
+	}
+
+	result := db.ItemBatch{Items: []*model.Item{}, Errors: []db.ItemError{}}
+
+	for _, id := range ids {
+		i, ok := m.data[id]
+
+		switch {
+		case m.broken[id]:
+			result.Errors = append(result.Errors, db.ItemError{ID: id, Error: "decode: bad document"})
+		case !ok:
+			result.Errors = append(result.Errors, db.ItemError{ID: id, Error: db.ErrNotFound.Error()})
+		default:
+			result.Items = append(result.Items, i)
+		}
+	}
+
+	return &result, nil
+}
+
+// GetItemBySKU returns ErrAmbiguous for a SKU on several
+// items, unless the context allows any of them
+func (m *mockDB) GetItemBySKU(ctx context.Context, sku int) (*model.Item, error) {
+	if m.fail {
+		return nil, m.failure()
+	}
+
+	var found *model.Item
+
+	for _, v := range m.data {
+		if v.Sku != sku {
+			continue
+		}
+
+		if found != nil && !db.AnyMatch(ctx) {
+			return nil, fmt.Errorf("sku %d: %w", sku, db.ErrAmbiguous)
+		}
+
+		found = v
+	}
+
+	if found == nil {
+		return nil, db.ErrNotFound
+	}
+
+	return found, nil
+}
+
+// GetItemsBySKUs is GetItemBySKU for each SKU, in one call
+func (m *mockDB) GetItemsBySKUs(ctx context.Context, skus []int) (*db.SKUBatch, error) {
+	if m.fail {
+		return nil, m.failure()
+	}
+
+	m.batches++
+
+	result := db.SKUBatch{Items: map[int]*model.Item{}, Errors: map[int]error{}}
+
+	for _, sku := range skus {
+		i, err := m.GetItemBySKU(ctx, sku)
+
+		switch {
+		case errors.Is(err, db.ErrNotFound):
+		case err != nil:
+			result.Errors[sku] = err
+		default:
+			result.Items[sku] = i
+		}
+	}
+
+	return &result, nil
+}
+
+func (m *mockDB) ListItems(ctx context.Context) ([]*model.Item, error) {
+	if m.fail {
+		return nil, m.failure()
+	}
+
+	// like the real thing, a slow read gives up when the
+	// request does
+
+	select {
+	case <-time.After(m.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	result := make([]*model.Item, 0, len(m.data))
+
+	for _, i := range m.data {
+		result = append(result, i)
+	}
+
+	// in SKU order, like the real thing
+
+	sort.Slice(result, func(x, y int) bool {
+		return result[x].Sku < result[y].Sku
+	})
+
+	return result, nil
+}
+
+// ListItemsFields records the projection but ignores it
+func (m *mockDB) ListItemsFields(ctx context.Context, fields []string) ([]*model.Item, error) {
+	m.fields = fields
+	return m.ListItems(ctx)
+}
+
+func (m *mockDB) ListItemsByTags(_ context.Context, tags []string) ([]*model.Item, error) {
+	if m.fail {
+		return nil, m.failure()
+	}
+
+	result := make([]*model.Item, 0, len(m.data))
+
+	for _, i := range m.data {
+	outer:
+		for _, t := range i.Tags {
+			for _, u := range tags {
+				if t == u {
+					result = append(result, i)
+					break outer
+				}
+			}
+		}
+	}
+
+	return result, nil
+}
+
+func (m *mockDB) ListSKUs(_ context.Context) (map[string]string, error) {
+	if m.fail {
+		return nil, m.failure()
+	}
+
+	result := make(map[string]string, len(m.data))
+
+	for _, i := range m.data {
+		result[strconv.Itoa(i.Sku)] = i.ID
+	}
+
+	return result, nil
+}
+
+// ListSKUPage sorts by SKU, as Firestore would
+func (m *mockDB) ListSKUPage(_ context.Context, after, limit int) ([]*model.SkuEntry, error) {
+	if m.fail {
+		return nil, m.failure()
+	}
+
+	result := make([]*model.SkuEntry, 0, len(m.data))
+
+	for _, i := range m.data {
+		if i.Sku > after {
+			result = append(result, &model.SkuEntry{Sku: i.Sku, ID: i.ID})
+		}
+	}
+
+	sort.Slice(result, func(x, y int) bool {
+		return result[x].Sku < result[y].Sku
+	})
+
+	if len(result) > limit {
+		result = result[:limit]
+	}
+
+	return result, nil
+}
+
+// ListItemPage sorts by SKU, as Firestore would, and
+// returns whole items
+func (m *mockDB) ListItemPage(_ context.Context, after, limit int, fields []string) ([]*model.Item, error) {
+	if m.fail {
+		return nil, m.failure()
+	}
+
+	m.pages++
+	m.fields = fields
+
+	result := make([]*model.Item, 0, len(m.data))
+
+	for _, i := range m.data {
+		if i.Sku > after {
+			result = append(result, i)
+		}
+	}
+
+	sort.Slice(result, func(x, y int) bool {
+		return result[x].Sku < result[y].Sku
+	})
+
+	if len(result) > limit {
+		result = result[:limit]
+	}
+
+	return result, nil
+}
+
+func (m *mockDB) UpdateItem(_ context.Context, i *model.Item) error {
+	if m.fail {
+		return m.failure()
+	}
+
+	if m.data == nil {
+		return errInvalid
+	}
+
+	m.put(i)
+
+	return nil
+}
+
+func (m *mockDB) DeleteItem(_ context.Context, id string) error {
+	if m.fail {
+		return m.failure()
+	}
+
+	if m.data == nil {
+		return errInvalid
+	}
+
+	if m.tombs == nil {
+		m.tombs = make(map[string]int)
+	}
+
+	if _, ok := m.data[id]; ok {
+		delete(m.data, id)
+		m.seq++
+		m.tombs[id] = m.seq
+	}
+
+	return nil
+}
+
+// put stores an item with the next change number and
+// clears any tombstone for it
+func (m *mockDB) put(i *model.Item) {
+	if m.tombs == nil {
+		m.tombs = make(map[string]int)
+	}
+
+	m.seq++
+	i.Seq = m.seq
+	m.data[i.ID] = i
+
+	delete(m.tombs, i.ID)
+}
+
+func (m *mockDB) ListChanges(_ context.Context, since int) (*db.Changes, error) {
+	if m.fail {
+		return nil, m.failure()
+	}
+
+	result := db.Changes{Items: []*model.Item{}, Deleted: []string{}, NextSeq: since}
+
+	for _, i := range m.data {
+		if i.Seq > since {
+			result.Items = append(result.Items, i)
+		}
+	}
+
+	sort.Slice(result.Items, func(x, y int) bool {
+		return result.Items[x].Seq < result.Items[y].Seq
+	})
+
+	for id, seq := range m.tombs {
+		if seq > since {
+			result.Deleted = append(result.Deleted, id)
+		}
+	}
+
+	if since < m.seq {
+		result.NextSeq = m.seq
+	}
+
+	return &result, nil
+}
+
+func (m *mockDB) LastSeq(_ context.Context) (int, error) {
+	if m.fail {
+		return 0, m.failure()
+	}
+
+	return m.seq, nil
+}
+
+func (m *mockDB) ReconcileSKU(_ context.Context) (bool, error) {
+	if m.fail {
+		return false, m.failure()
+	}
+
+	max := 0
+
+	for _, i := range m.data {
+		if i.Sku > max {
+			max = i.Sku
+		}
+	}
+
+	if m.next > max {
+		return false, nil
+	}
+
+	m.next = max + 1
+
+	return true, nil
+}
+
+func (m *mockDB) ReserveSKUBlock(_ context.Context, n int) (int, error) {
+	if m.fail {
+		return 0, m.failure()
+	}
+
+	if m.data == nil {
+		m.data = make(map[string]*model.Item)
+		m.next = 1000
+	}
+
+	start := m.next
+	m.next += n
+
+	return start, nil
+}
+
+func (m *mockDB) Reindex(_ context.Context) (int, error) {
+	if m.fail {
+		return 0, m.failure()
+	}
+
+	return len(m.data), nil
+}
+
+func (m *mockDB) Restore(_ context.Context, items []*model.Item) (int, error) {
+	if m.fail {
+		return 0, m.failure()
+	}
+
+	if m.data == nil {
+		m.data = make(map[string]*model.Item)
+		m.next = 1000
+	}
+
+	for _, i := range items {
+		m.put(i)
+	}
+
+	return len(items), nil
+}
+
+func (m *mockDB) ImportItems(_ context.Context, items []*model.Item) (int, error) {
+	if m.fail {
+		return 0, m.failure()
+	}
+
+	if m.data == nil {
+		m.data = make(map[string]*model.Item)
+		m.next = 1000
+	}
+
+	for _, i := range items {
+		for _, v := range m.data {
+			if v.Sku == i.Sku {
+				return 0, fmt.Errorf("SKU %d: %w", i.Sku, db.ErrDuplicate)
+			}
+		}
+	}
+
+	for _, i := range items {
+		i.ID = uuid.New().String()
+		m.put(i)
+
+		if i.Sku >= m.next {
+			m.next = i.Sku + 1
+		}
+	}
+
+	return len(items), nil
+}
+
+func (m *mockDB) SKUStats(_ context.Context) (*db.SKUStats, error) {
+	if m.fail {
+		return nil, m.failure()
+	}
+
+	return &db.SKUStats{Next: m.next, Remaining: db.MaxSKU - m.next + 1}, nil
+}
+
+func (m *mockDB) AuditLog(_ context.Context, action, itemID, user string, before, after *model.Item) error {
+	if m.fail || m.noAudit {
+		return m.failure()
+	}
+
+	m.audit = append(m.audit, &db.AuditEntry{Action: action, ItemID: itemID, User: user, Before: before, After: after})
+	return nil
+}
+
+func (m *mockDB) ListAudit(_ context.Context, itemID string) ([]*db.AuditEntry, error) {
+	if m.fail {
+		return nil, m.failure()
+	}
+
+	result := []*db.AuditEntry{}
+
+	for _, e := range m.audit {
+		if e.ItemID == itemID {
+			result = append(result, e)
+		}
+	}
+
+	return result, nil
+}
+
+func (m *mockDB) Ping(_ context.Context) error {
+	if m.fail {
+		return m.failure()
+	}
+
+	return nil
+}
+
+func (m *mockDB) CheckWrite(_ context.Context) error {
+	if m.fail {
+		return m.failure()
+	}
+
+	return nil
+}
+
+func (m *mockDB) Close() error {
+	m.closed++
+	return nil
+}
+
+func (m *mockDB) preload() {
+	if m.data == nil {
+		m.data = make(map[string]*model.Item)
+		m.next = 1000
+	}
+
+	for i := 1; i < 10; i++ {
+		id := uuid.New().String()
+		item := model.Item{ID: id, Name: fmt.Sprintf("item-%d", i), Sku: m.next}
+
+		m.data[id] = &item
+		m.next++
+	}
+}
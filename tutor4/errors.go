@@ -0,0 +1,87 @@
+package tutor4
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"google.golang.org/grpc/codes"
+
+	"tutor4/db"
+	"tutor4/graph/model"
+)
+
+// statusFor picks the HTTP status for an error from the
+// DB, looking first for our own errors and then at the
+// gRPC code underneath; anything we don't recognize is
+// our fault, which includes FailedPrecondition (it's what
+// Firestore says when an index is missing, and it's not a
+// conditional request that failed)
+func statusFor(err error) int {
+	var ve *model.ValidationError
+
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, db.ErrExists), errors.Is(err, db.ErrAmbiguous):
+		return http.StatusConflict
+	case errors.As(err, &ve):
+		return http.StatusUnprocessableEntity
+	}
+
+	switch db.Code(err) {
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.InvalidArgument:
+		return http.StatusBadRequest
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	}
+
+	return http.StatusInternalServerError
+}
+
+// dbCodes are the error codes dbError sends for each
+// status, the same as GraphQL's where it has one
+var dbCodes = map[int]string{
+	http.StatusNotFound:           "not_found",
+	http.StatusConflict:           "conflict",
+	http.StatusBadRequest:         "invalid_input",
+	http.StatusForbidden:          "forbidden",
+	http.StatusTooManyRequests:    "rate_limited",
+	http.StatusGatewayTimeout:     "timeout",
+	http.StatusServiceUnavailable: "unavailable",
+}
+
+// dbError is jsonError for errors from the DB; if the
+// problem is temporary, it tells the client when to retry
+func dbError(w http.ResponseWriter, err error) {
+	var ve *model.ValidationError
+
+	if errors.As(err, &ve) {
+		writeError(w, http.StatusUnprocessableEntity, apiError{ve.Code, ve.Message, ve.Field})
+		return
+	}
+
+	status := statusFor(err)
+
+	if status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", strconv.Itoa(int(db.RetryAfter.Seconds())))
+	}
+
+	code, ok := dbCodes[status]
+
+	if !ok {
+		code = "internal"
+	}
+
+	jsonError(w, status, code, err.Error())
+}
@@ -0,0 +1,119 @@
+package tutor4
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"tutor4/db"
+	"tutor4/graph/model"
+)
+
+// TestDBErrorsWithMocks drives each gRPC code through
+// both REST and GraphQL
+func TestDBErrorsWithMocks(t *testing.T) {
+	table := []struct {
+		code   codes.Code
+		status int
+		retry  string
+		rest   string
+		gql    interface{}
+	}{
+		{codes.NotFound, http.StatusNotFound, "", "not_found", nil},
+		{codes.ResourceExhausted, http.StatusTooManyRequests, "5", "rate_limited", "rate_limited"},
+		{codes.DeadlineExceeded, http.StatusGatewayTimeout, "", "timeout", "timeout"},
+		{codes.Unavailable, http.StatusServiceUnavailable, "5", "unavailable", "unavailable"},
+		{codes.FailedPrecondition, http.StatusInternalServerError, "", "internal", nil},
+		{codes.Internal, http.StatusInternalServerError, "", "internal", nil},
+	}
+
+	for _, tt := range table {
+		err := fmt.Errorf("wrapped: %w", status.Error(tt.code, "oops"))
+		d := &mockDB{fail: true, failErr: err}
+		a := app{router: mux.NewRouter(), db: d, noAuth: true}
+
+		a.addRoutes()
+
+		r := httptest.NewRequest("GET", "http://who-cares/items", nil)
+		w := httptest.NewRecorder()
+
+		a.router.ServeHTTP(w, r)
+
+		resp := w.Result()
+
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: invalid response: %d", tt.code, resp.StatusCode)
+		}
+
+		if retry := resp.Header.Get("Retry-After"); retry != tt.retry {
+			t.Errorf("%s: invalid Retry-After: %q", tt.code, retry)
+		}
+
+		var body struct {
+			Error apiError `json:"error"`
+		}
+
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Error.Code != tt.rest {
+			t.Errorf("%s: invalid body: %#v, %v", tt.code, body, err)
+		}
+
+		r = httptest.NewRequest("POST", "http://who-cares/graphql", strings.NewReader(`{"query":"{items {name}}"}`))
+		w = httptest.NewRecorder()
+
+		r.Header.Set("Content-Type", "application/json")
+		a.router.ServeHTTP(w, r)
+
+		var result struct {
+			Errors []struct {
+				Extensions map[string]interface{}
+			}
+		}
+
+		if err := json.NewDecoder(w.Result().Body).Decode(&result); err != nil {
+			t.Fatalf("%s: %s", tt.code, err)
+		}
+
+		if len(result.Errors) != 1 || result.Errors[0].Extensions["code"] != tt.gql {
+			t.Errorf("%s: invalid GraphQL errors: %#v", tt.code, result.Errors)
+		}
+	}
+}
+
+func TestStatusFor(t *testing.T) {
+	table := []struct {
+		err    error
+		status int
+	}{
+		{db.ErrNotFound, http.StatusNotFound},
+		{fmt.Errorf("item x: %w", db.ErrNotFound), http.StatusNotFound},
+		{fmt.Errorf("item x: %w", db.ErrExists), http.StatusConflict},
+		{&model.ValidationError{Field: "name", Code: "name_required"}, http.StatusUnprocessableEntity},
+		{status.Error(codes.NotFound, "x"), http.StatusNotFound},
+		{fmt.Errorf("get: %w", status.Error(codes.AlreadyExists, "x")), http.StatusConflict},
+		{status.Error(codes.Aborted, "x"), http.StatusConflict},
+		{status.Error(codes.InvalidArgument, "x"), http.StatusBadRequest},
+		{status.Error(codes.FailedPrecondition, "x"), http.StatusInternalServerError},
+		{status.Error(codes.PermissionDenied, "x"), http.StatusForbidden},
+		{fmt.Errorf("a: %w", fmt.Errorf("b: %w", status.Error(codes.ResourceExhausted, "x"))), http.StatusTooManyRequests},
+		{status.Error(codes.DeadlineExceeded, "x"), http.StatusGatewayTimeout},
+		{fmt.Errorf("list: %w", context.DeadlineExceeded), http.StatusGatewayTimeout},
+		{status.Error(codes.Unavailable, "x"), http.StatusServiceUnavailable},
+		{status.Error(codes.Internal, "x"), http.StatusInternalServerError},
+		{errors.New("plain"), http.StatusInternalServerError},
+	}
+
+	for _, tt := range table {
+		if got := statusFor(tt.err); got != tt.status {
+			t.Errorf("%v: wanted %d, got %d", tt.err, tt.status, got)
+		}
+	}
+}
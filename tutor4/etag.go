@@ -0,0 +1,60 @@
+package tutor4
+
+import (
+	"net/http"
+	"strings"
+)
+
+// weakETag makes a weak entity-tag (RFC 7232), for a
+// representation we can say is equivalent but not that
+// it's the same bytes, e.g. one derived from a change
+// number; the tag itself mustn't contain a quote
+func weakETag(tag string) string {
+	return `W/"` + tag + `"`
+}
+
+// noneMatch reports whether If-None-Match matches etag,
+// i.e. that the client already has it; the comparison is
+// weak, as RFC 7232 requires for If-None-Match, so W/"x"
+// matches "x"
+//
+// the header may be *, which matches anything, or a list
+// of entity-tags, possibly over several header lines; we
+// stop at anything malformed, as not matching is safe
+func noneMatch(r *http.Request, etag string) bool {
+	want := opaqueTag(etag)
+
+	for _, h := range r.Header["If-None-Match"] {
+		for h = strings.TrimLeft(h, " \t,"); h != ""; h = strings.TrimLeft(h, " \t,") {
+			if h[0] == '*' {
+				return true
+			}
+
+			h = strings.TrimPrefix(h, "W/")
+
+			if h == "" || h[0] != '"' {
+				break
+			}
+
+			end := strings.IndexByte(h[1:], '"')
+
+			if end < 0 {
+				break
+			}
+
+			if h[:end+2] == want {
+				return true
+			}
+
+			h = h[end+2:]
+		}
+	}
+
+	return false
+}
+
+// opaqueTag is the quoted part of an entity-tag, which is
+// all weak comparison looks at
+func opaqueTag(etag string) string {
+	return strings.TrimPrefix(etag, "W/")
+}
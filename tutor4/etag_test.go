@@ -0,0 +1,43 @@
+package tutor4
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+// TestNoneMatch parses If-None-Match with weak comparison
+func TestNoneMatch(t *testing.T) {
+	table := []struct {
+		name   string
+		header []string
+		etag   string
+		match  bool
+	}{
+		{"strong", []string{`"items-5"`}, `"items-5"`, true},
+		{"weak both", []string{`W/"items-5"`}, `W/"items-5"`, true},
+		{"weak header", []string{`W/"items-5"`}, `"items-5"`, true},
+		{"weak etag", []string{`"items-5"`}, `W/"items-5"`, true},
+		{"other", []string{`"items-4"`}, `W/"items-5"`, false},
+		{"unquoted", []string{`items-5`}, `W/"items-5"`, false},
+		{"list", []string{`"a", W/"items-5" ,"b"`}, `W/"items-5"`, true},
+		{"list miss", []string{`"a", W/"items-4"`}, `W/"items-5"`, false},
+		{"lines", []string{`"a"`, `W/"items-5"`}, `W/"items-5"`, true},
+		{"comma in tag", []string{`"items-5,x"`}, `W/"items-5"`, false},
+		{"star", []string{`*`}, `W/"items-5"`, true},
+		{"bare weak", []string{`W/`}, `W/"items-5"`, false},
+		{"unterminated", []string{`"items-5`}, `W/"items-5"`, false},
+		{"none", nil, `W/"items-5"`, false},
+	}
+
+	for _, st := range table {
+		r := httptest.NewRequest("GET", "http://who-cares/items", nil)
+
+		for _, h := range st.header {
+			r.Header.Add("If-None-Match", h)
+		}
+
+		if got := noneMatch(r, st.etag); got != st.match {
+			t.Errorf("%s: wanted %t, got %t", st.name, st.match, got)
+		}
+	}
+}
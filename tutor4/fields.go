@@ -0,0 +1,182 @@
+package tutor4
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"tutor4/graph/model"
+)
+
+// skuDisplay is a computed field, the SKU as people see it
+// (e.g. PRD-0001042); it needs -sku-prefix
+const skuDisplay = "skuDisplay"
+
+// itemField is where a JSON field of an item lives in the
+// struct, and what it's called in Firestore
+type itemField struct {
+	index  int
+	stored string
+}
+
+var itemFields = findFields(reflect.TypeOf(model.Item{}))
+
+func findFields(t reflect.Type) map[string]itemField {
+	result := make(map[string]itemField, t.NumField())
+
+	for n := 0; n < t.NumField(); n++ {
+		f := t.Field(n)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		stored := strings.Split(f.Tag.Get("firestore"), ",")[0]
+
+		if name == "" || name == "-" {
+			continue
+		}
+
+		result[name] = itemField{n, stored}
+	}
+
+	return result
+}
+
+// formatSKU is the display form of a SKU
+func (a *app) formatSKU(sku int) string {
+	return fmt.Sprintf("%s%0*d", a.skuPrefix, a.skuWidth, sku)
+}
+
+// queryFields reads ?fields=id,name for a list; it returns
+// the fields asked for and the Firestore fields to read
+// for them, or nil if the caller wants whole items
+func (a *app) queryFields(r *http.Request) (fields, stored []string, err error) {
+	q := r.URL.Query().Get("fields")
+
+	if q == "" {
+		return nil, nil, nil
+	}
+
+	seen := map[string]bool{}
+
+	for _, f := range strings.Split(q, ",") {
+		var s string
+
+		if f == skuDisplay {
+			if a.skuPrefix == "" {
+				return nil, nil, fmt.Errorf("%s needs a SKU prefix", skuDisplay)
+			}
+
+			s = "sku"
+		} else if itf, ok := itemFields[f]; ok {
+			s = itf.stored
+		} else {
+			return nil, nil, fmt.Errorf("unknown field %q", f)
+		}
+
+		if !seen[f] {
+			fields = append(fields, f)
+		}
+
+		seen[f] = true
+
+		if !seen["$"+s] {
+			stored = append(stored, s)
+		}
+
+		seen["$"+s] = true
+	}
+
+	sort.Strings(stored)
+
+	return fields, stored, nil
+}
+
+// hasField reports whether f is one of the fields
+func hasField(fields []string, f string) bool {
+	for _, g := range fields {
+		if g == f {
+			return true
+		}
+	}
+
+	return false
+}
+
+// skuStrings sends the SKU of every item in a JSON
+// response as a string (-sku-string), since JavaScript
+// reads every number as a float; Firestore always stores
+// an integer, and we take either in a request
+func skuStrings(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bw := bufferWriter{header: http.Header{}}
+
+		next.ServeHTTP(&bw, r)
+
+		if body, ok := bw.decode(); ok && stringSKUs(body) {
+			bw.replace(body)
+		}
+
+		bw.flush(w)
+	})
+}
+
+// stringSKUs makes the SKU of every item in a JSON value
+// a string, wherever it is (as dropFields finds them); it
+// reports whether it changed any
+func stringSKUs(v interface{}) bool {
+	changed := false
+
+	switch v := v.(type) {
+	case []interface{}:
+		for _, e := range v {
+			changed = stringSKUs(e) || changed
+		}
+
+	case map[string]interface{}:
+		_, id := v["id"]
+
+		if sku, ok := v["sku"].(json.Number); ok && id {
+			v["sku"] = sku.String()
+			changed = true
+		}
+
+		for k, e := range v {
+			if k != "sku" {
+				changed = stringSKUs(e) || changed
+			}
+		}
+	}
+
+	return changed
+}
+
+// shape keeps only the given fields of each item, adding
+// any computed ones
+func (a *app) shape(items []*model.Item, fields []string) []map[string]interface{} {
+	result := make([]map[string]interface{}, 0, len(items))
+
+	for _, i := range items {
+		v := reflect.ValueOf(i).Elem()
+		m := make(map[string]interface{}, len(fields))
+
+		for _, f := range fields {
+			if f == skuDisplay {
+				m[f] = a.formatSKU(i.Sku)
+				continue
+			}
+
+			if f == "sku" && a.skuString {
+				m[f] = strconv.Itoa(i.Sku)
+				continue
+			}
+
+			m[f] = v.Field(itemFields[f].index).Interface()
+		}
+
+		result = append(result, m)
+	}
+
+	return result
+}
@@ -0,0 +1,77 @@
+package tutor4
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// TestListFieldsWithMocks projects the list, with and
+// without a SKU prefix for skuDisplay
+func TestListFieldsWithMocks(t *testing.T) {
+	table := []struct {
+		prefix string
+		query  string
+		status int
+		first  map[string]interface{}
+		stored []string
+	}{
+		{"PRD-", "id,name,skuDisplay", http.StatusOK, map[string]interface{}{"name": "item-1", "skuDisplay": "PRD-0001000"}, []string{"id", "name", "sku"}},
+		{"", "name,sku", http.StatusOK, map[string]interface{}{"name": "item-1", "sku": 1000.0}, []string{"name", "sku"}},
+		{"", "name,skuDisplay", http.StatusBadRequest, nil, nil},
+		{"", "name,price", http.StatusBadRequest, nil, nil},
+	}
+
+	for _, st := range table {
+		d := new(mockDB)
+		a := app{router: mux.NewRouter(), db: d}
+
+		if err := a.fromArgs([]string{"-no-auth", "-sku-prefix", st.prefix}); err != nil {
+			t.Fatal(err)
+		}
+
+		d.preload()
+		a.addRoutes()
+
+		r := httptest.NewRequest("GET", "http://who-cares/items?fields="+st.query, nil)
+		w := httptest.NewRecorder()
+
+		a.router.ServeHTTP(w, r)
+
+		if w.Code != st.status {
+			t.Errorf("%s: invalid response: %d", st.query, w.Code)
+			continue
+		}
+
+		if st.status != http.StatusOK {
+			continue
+		}
+
+		var result []map[string]interface{}
+
+		if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(result) != 9 {
+			t.Fatalf("%s: invalid count: %d", st.query, len(result))
+		}
+
+		// the IDs are random
+
+		first := result[0]
+		delete(first, "id")
+
+		if !reflect.DeepEqual(first, st.first) {
+			t.Errorf("%s: invalid item: %v", st.query, first)
+		}
+
+		if !reflect.DeepEqual(d.fields, st.stored) {
+			t.Errorf("%s: invalid projection: %v", st.query, d.fields)
+		}
+	}
+}
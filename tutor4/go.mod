@@ -8,5 +8,9 @@ require (
 	github.com/google/uuid v1.1.2
 	github.com/gorilla/mux v1.6.1
 	github.com/vektah/gqlparser/v2 v2.1.0
+	golang.org/x/crypto v0.0.0-20201016220609-9e8e0b390897
+	golang.org/x/sync v0.0.0-20201020160332-67f06af15bc9
+	golang.org/x/text v0.3.3
+	google.golang.org/api v0.29.0
 	google.golang.org/grpc v1.32.0
 )
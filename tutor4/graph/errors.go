@@ -0,0 +1,61 @@
+package graph
+
+import (
+	"context"
+	"errors"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+	"google.golang.org/grpc/codes"
+
+	"tutor4/db"
+	"tutor4/graph/model"
+)
+
+var ErrForbidden = errors.New("forbidden")
+
+// dbCodes are the DB errors a client can do something
+// about, so we tell them which it was
+var dbCodes = map[codes.Code]string{
+	codes.ResourceExhausted: "rate_limited",
+	codes.DeadlineExceeded:  "timeout",
+	codes.Unavailable:       "unavailable",
+}
+
+// ErrorPresenter adds the code and field of a validation
+// error to the GraphQL error's extensions, so clients get
+// the same code they would from the REST API; temporary
+// DB errors get a code and a retry time (in seconds)
+func ErrorPresenter(ctx context.Context, err error) *gqlerror.Error {
+	gqlErr := graphql.DefaultErrorPresenter(ctx, err)
+
+	var ve *model.ValidationError
+
+	if errors.As(err, &ve) {
+		gqlErr.Message = ve.Message
+		extend(gqlErr, "code", ve.Code)
+		extend(gqlErr, "field", ve.Field)
+
+		return gqlErr
+	}
+
+	c := db.Code(err)
+
+	if code, ok := dbCodes[c]; ok {
+		extend(gqlErr, "code", code)
+
+		if c != codes.DeadlineExceeded {
+			extend(gqlErr, "retryAfter", int(db.RetryAfter.Seconds()))
+		}
+	}
+
+	return gqlErr
+}
+
+func extend(e *gqlerror.Error, key string, value interface{}) {
+	if e.Extensions == nil {
+		e.Extensions = make(map[string]interface{})
+	}
+
+	e.Extensions[key] = value
+}
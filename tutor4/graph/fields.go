@@ -0,0 +1,41 @@
+package graph
+
+import (
+	"context"
+
+	"github.com/99designs/gqlgen/graphql"
+)
+
+// storedAs maps the Item fields in the schema to the
+// Firestore fields they're read from
+var storedAs = map[string]string{
+	"id":   "id",
+	"name": "name",
+	"sku":  "sku",
+	"tags": "tags",
+	"seq":  "seq",
+}
+
+// itemFields lists the Firestore fields the query selects
+// on an item, so we don't read (and pay for) the rest; it
+// returns nil, meaning all of them, if there's a field we
+// don't know how to project
+func itemFields(ctx context.Context) []string {
+	var fields []string
+
+	for _, f := range graphql.CollectFieldsCtx(ctx, nil) {
+		if f.Name == "__typename" {
+			continue
+		}
+
+		name, ok := storedAs[f.Name]
+
+		if !ok {
+			return nil
+		}
+
+		fields = append(fields, name)
+	}
+
+	return fields
+}
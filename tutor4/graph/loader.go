@@ -0,0 +1,104 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"tutor4/db"
+	"tutor4/graph/model"
+)
+
+// loaderWait is how long we collect SKUs before fetching;
+// gqlgen resolves sibling fields concurrently, so they all
+// show up well within this window
+const loaderWait = 2 * time.Millisecond
+
+type loaderKey struct{}
+
+// skuLoader batches the SKU lookups made while resolving
+// one request into a single backend call, so a list of N
+// items doesn't become N queries
+type skuLoader struct {
+	client db.DB
+
+	mu    sync.Mutex
+	batch *skuBatch
+}
+
+type skuBatch struct {
+	skus  []int
+	done  chan struct{}
+	found *db.SKUBatch
+	err   error
+}
+
+// LoaderMiddleware gives each request its own loader, so
+// nothing is cached or shared between requests
+func LoaderMiddleware(client db.DB, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l := skuLoader{client: client}
+		ctx := context.WithValue(r.Context(), loaderKey{}, &l)
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// itemBySKU uses the request's loader if there is one
+func (r *Resolver) itemBySKU(ctx context.Context, sku int) (*model.Item, error) {
+	if l, ok := ctx.Value(loaderKey{}).(*skuLoader); ok {
+		return l.load(ctx, sku)
+	}
+
+	return r.Client.GetItemBySKU(ctx, sku)
+}
+
+func (l *skuLoader) load(ctx context.Context, sku int) (*model.Item, error) {
+	l.mu.Lock()
+
+	b := l.batch
+
+	if b == nil {
+		b = &skuBatch{done: make(chan struct{})}
+		l.batch = b
+
+		go l.fetch(ctx, b)
+	}
+
+	b.skus = append(b.skus, sku)
+	l.mu.Unlock()
+
+	<-b.done
+
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	// a SKU on several items fails on its own, as it
+	// would without the loader
+
+	if err, ok := b.found.Errors[sku]; ok {
+		return nil, err
+	}
+
+	if item, ok := b.found.Items[sku]; ok {
+		return item, nil
+	}
+
+	return nil, fmt.Errorf("sku %d: %w", sku, db.ErrNotFound)
+}
+
+func (l *skuLoader) fetch(ctx context.Context, b *skuBatch) {
+	time.Sleep(loaderWait)
+
+	// once we take the batch, later loads start a new one
+
+	l.mu.Lock()
+	l.batch = nil
+	l.mu.Unlock()
+
+	b.found, b.err = l.client.GetItemsBySKUs(ctx, b.skus)
+	close(b.done)
+}
@@ -1,7 +1,104 @@
 package model
 
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// fields tagged api:"readonly" are assigned by the server
+// and ignored (or rejected) in client input
+//
+// REST and GraphQL both send this type, so every field in
+// the GraphQL schema is always in the JSON (tags as [] if
+// there are none), and the REST body has the same shape
+// plus the REST-only fields
 type Item struct {
-	ID   string `json:"id" firestore:"id"`
-	Name string `json:"name" firestore:"name"`
-	Sku  int    `json:"sku" firestore:"sku"`
+	ID   string   `json:"id" firestore:"id" api:"readonly"`
+	Name string   `json:"name" firestore:"name"`
+	Sku  int      `json:"sku" firestore:"sku" api:"readonly"`
+	Tags []string `json:"tags" firestore:"tags,omitempty"`
+
+	// ExternalKey is the item's ID in some other system,
+	// which lets an import match up items it sent before
+	ExternalKey string `json:"externalKey,omitempty" firestore:"external_key,omitempty"`
+
+	// Seq is the change number of the last write
+	Seq int `json:"seq" firestore:"seq,omitempty" api:"readonly"`
+
+	// SupplierCost is what we pay for the item; it's stored
+	// encrypted and only editors get to see it
+	SupplierCost string `json:"supplierCost,omitempty" firestore:"supplier_cost,omitempty" api:"secret"`
+
+	// NameSort is the name as we sort it (see -collation);
+	// it's computed on write and never sent to clients
+	NameSort string `json:"-" firestore:"name_sort,omitempty"`
+}
+
+// itemJSON is Item without its JSON methods
+type itemJSON Item
+
+func (i Item) MarshalJSON() ([]byte, error) {
+	if i.Tags == nil {
+		i.Tags = []string{}
+	}
+
+	return json.Marshal(itemJSON(i))
+}
+
+// UnmarshalJSON takes the SKU as a number or a string,
+// since a server with -sku-string sends it as a string
+func (i *Item) UnmarshalJSON(b []byte) error {
+	v := struct {
+		*itemJSON
+		Sku *json.Number `json:"sku"`
+	}{itemJSON: (*itemJSON)(i)}
+
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+
+	if v.Sku == nil {
+		return nil
+	}
+
+	sku, err := strconv.Atoi(v.Sku.String())
+
+	if err != nil {
+		return fmt.Errorf("invalid sku %q", v.Sku.String())
+	}
+
+	i.Sku = sku
+
+	return nil
+}
+
+var ErrEmptyTag = errors.New("empty tag")
+
+// CleanTags drops duplicate tags (keeping the first) and
+// rejects empty ones
+func (i *Item) CleanTags() error {
+	if len(i.Tags) == 0 {
+		i.Tags = nil
+		return nil
+	}
+
+	seen := make(map[string]bool, len(i.Tags))
+	tags := make([]string, 0, len(i.Tags))
+
+	for _, t := range i.Tags {
+		if t == "" {
+			return &ValidationError{Field: "tags", Code: "empty_tag", Message: "tags can't be empty", Err: ErrEmptyTag}
+		}
+
+		if !seen[t] {
+			seen[t] = true
+			tags = append(tags, t)
+		}
+	}
+
+	i.Tags = tags
+
+	return nil
 }
@@ -3,5 +3,11 @@
 package model
 
 type NewItem struct {
-	Name string `json:"name"`
+	Name string   `json:"name"`
+	Tags []string `json:"tags"`
+}
+
+type SkuEntry struct {
+	Sku int    `json:"sku"`
+	ID  string `json:"id"`
 }
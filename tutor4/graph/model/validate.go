@@ -0,0 +1,53 @@
+package model
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// MaxNameLen is the longest name we accept, in characters
+const MaxNameLen = 200
+
+// ValidationError says what's wrong with client input; the
+// code is stable for clients to match on and the same for
+// REST and GraphQL, the message is for people to read
+type ValidationError struct {
+	Field   string
+	Code    string
+	Message string
+	Err     error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+func ValidateName(name string) error {
+	if name == "" {
+		return &ValidationError{Field: "name", Code: "name_required", Message: "name is required"}
+	}
+
+	if utf8.RuneCountInString(name) > MaxNameLen {
+		return &ValidationError{
+			Field:   "name",
+			Code:    "name_too_long",
+			Message: fmt.Sprintf("name is longer than %d characters", MaxNameLen),
+		}
+	}
+
+	return nil
+}
+
+// Validate checks the fields a client may set and cleans
+// up the tags; it's called before every write
+func (i *Item) Validate() error {
+	if err := ValidateName(i.Name); err != nil {
+		return err
+	}
+
+	return i.CleanTags()
+}
@@ -1,6 +1,11 @@
 package graph
 
-import "tutor4/db"
+import (
+	"context"
+
+	"tutor4/db"
+	"tutor4/graph/model"
+)
 
 // This file will not be regenerated automatically.
 //
@@ -8,4 +13,23 @@ import "tutor4/db"
 
 type Resolver struct {
 	Client db.DB
+
+	// OnCreate (if set) is told about each new item
+	OnCreate func(*model.Item)
+
+	// CanWrite (if set) says whether the caller may make
+	// changes; if not, mutations fail
+	CanWrite func(context.Context) bool
+
+	// MaxItems (if set) caps how many items the items
+	// query returns, so a huge catalog can't exhaust
+	// memory; it's a guardrail, not pagination
+	MaxItems int
 }
+
+// page sizes for the skus query; the cursor is just the
+// last SKU of the previous page
+const (
+	defaultSKUPage = 100
+	maxSKUPage     = 1000
+)
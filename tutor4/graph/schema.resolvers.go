@@ -6,17 +6,25 @@ package graph
 import (
 	"context"
 	"fmt"
+	"log"
+	"strconv"
+	"tutor4/db"
 	"tutor4/graph/generated"
 	"tutor4/graph/model"
 )
 
 func (r *mutationResolver) CreateItem(ctx context.Context, input model.NewItem) (*model.Item, error) {
-	if input.Name == "" {
-		return nil, fmt.Errorf("no name")
+	if r.CanWrite != nil && !r.CanWrite(ctx) {
+		return nil, ErrForbidden
 	}
 
 	item := model.Item{
 		Name: input.Name,
+		Tags: input.Tags,
+	}
+
+	if err := item.Validate(); err != nil {
+		return nil, err
 	}
 
 	_, err := r.Client.AddItem(ctx, &item)
@@ -25,21 +33,30 @@ func (r *mutationResolver) CreateItem(ctx context.Context, input model.NewItem)
 		return nil, err
 	}
 
+	if r.OnCreate != nil {
+		r.OnCreate(&item)
+	}
+
 	return &item, nil
 }
 
 func (r *queryResolver) Items(ctx context.Context) ([]*model.Item, error) {
-	items, err := r.Client.ListItems(ctx)
+	items, err := r.Client.ListItemsFields(ctx, itemFields(ctx))
 
 	if err != nil {
 		return nil, err
 	}
 
+	if r.MaxItems > 0 && len(items) > r.MaxItems {
+		log.Printf("WARN items query truncated from %d to %d", len(items), r.MaxItems)
+		items = items[:r.MaxItems]
+	}
+
 	return items, nil
 }
 
 func (r *queryResolver) Item(ctx context.Context, sku int) (*model.Item, error) {
-	item, err := r.Client.GetItemBySKU(ctx, sku)
+	item, err := r.itemBySKU(ctx, sku)
 
 	if err != nil {
 		return nil, err
@@ -48,6 +65,34 @@ func (r *queryResolver) Item(ctx context.Context, sku int) (*model.Item, error)
 	return item, nil
 }
 
+func (r *queryResolver) Skus(ctx context.Context, first *int, after *string) ([]*model.SkuEntry, error) {
+	limit, start := defaultSKUPage, 0
+
+	if first != nil {
+		if *first < 0 || *first > maxSKUPage {
+			return nil, fmt.Errorf("first must be between 0 and %d", maxSKUPage)
+		}
+
+		limit = *first
+	}
+
+	if after != nil {
+		sku, err := strconv.Atoi(*after)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid cursor %q", *after)
+		}
+
+		start = sku
+	}
+
+	return r.Client.ListSKUPage(ctx, start, limit)
+}
+
+func (r *queryResolver) Changes(ctx context.Context, sinceSeq int) (*db.Changes, error) {
+	return r.Client.ListChanges(ctx, sinceSeq)
+}
+
 // Mutation returns generated.MutationResolver implementation.
 func (r *Resolver) Mutation() generated.MutationResolver { return &mutationResolver{r} }
 
@@ -0,0 +1,96 @@
+package tutor4
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+
+	"tutor4/db"
+)
+
+// maxIDLen is the longest item ID we take, in bytes;
+// Firestore allows 1500, but nothing we make is close
+const maxIDLen = 256
+
+// skuIDPattern is what db names items with -id-scheme sku
+var skuIDPattern = regexp.MustCompile(`^item-[0-9]{6,}$`)
+
+// checkID rejects an ID Firestore can't use as a document
+// ID, which would otherwise fail deep in the SDK with an
+// error that makes no sense to the client
+//
+// with -strict-ids, it must also look like an ID we'd
+// make, which rules out IDs chosen by clients (PUT with
+// If-None-Match: *)
+func (a *app) checkID(id string) error {
+	switch {
+	case id == "":
+		return fmt.Errorf("id is required")
+
+	case len(id) > maxIDLen:
+		return fmt.Errorf("id is longer than %d bytes", maxIDLen)
+
+	case !utf8.ValidString(id):
+		return fmt.Errorf("id isn't valid UTF-8")
+
+	case strings.ContainsRune(id, '/'), id == ".", id == "..":
+		return fmt.Errorf("id can't be a path")
+
+	case strings.HasPrefix(id, "__") && strings.HasSuffix(id, "__"):
+		return fmt.Errorf("id is reserved")
+	}
+
+	if !a.strictIDs {
+		return nil
+	}
+
+	if a.idScheme == db.IDSKU {
+		if !skuIDPattern.MatchString(id) {
+			return fmt.Errorf("id must be item-<sku>")
+		}
+
+		return nil
+	}
+
+	// Parse takes other forms too (e.g. with braces), but
+	// we only ever make the canonical one
+
+	if u, err := uuid.Parse(id); err != nil || u.String() != id {
+		return fmt.Errorf("id must be a UUID")
+	}
+
+	return nil
+}
+
+// pathID is the {id} in the route; if it's not valid,
+// it sends a 400 and returns false
+func (a *app) pathID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id := mux.Vars(r)["id"]
+
+	if err := a.checkID(id); err != nil {
+		writeError(w, http.StatusBadRequest, apiError{"invalid_id", "invalid id: " + err.Error(), "id"})
+		return "", false
+	}
+
+	return id, true
+}
+
+// pathSKU is the {sku} in the route; if it's not a SKU we
+// could have made, it sends a 400 and returns false
+func pathSKU(w http.ResponseWriter, r *http.Request) (int, bool) {
+	sku, err := strconv.Atoi(mux.Vars(r)["sku"])
+
+	if err != nil || sku < 1 || sku > db.MaxSKU {
+		msg := fmt.Sprintf("invalid sku: must be between 1 and %d", db.MaxSKU)
+		writeError(w, http.StatusBadRequest, apiError{"invalid_sku", msg, "sku"})
+		return 0, false
+	}
+
+	return sku, true
+}
@@ -0,0 +1,96 @@
+package tutor4
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"tutor4/db"
+)
+
+// TestCheckID checks IDs with and without -strict-ids
+func TestCheckID(t *testing.T) {
+	table := []struct {
+		id     string
+		scheme string
+		strict bool
+		ok     bool
+	}{
+		{"my-id", db.IDUUID, false, true},
+		{"", db.IDUUID, false, false},
+		{strings.Repeat("x", maxIDLen+1), db.IDUUID, false, false},
+		{"a/b", db.IDUUID, false, false},
+		{"..", db.IDUUID, false, false},
+		{"__name__", db.IDUUID, false, false},
+		{"bad\xff", db.IDUUID, false, false},
+		{"6ba7b810-9dad-11d1-80b4-00c04fd430c8", db.IDUUID, true, true},
+		{"6ba7b810-9dad-11d1-80b4-00c04fd430c", db.IDUUID, true, false},
+		{"6BA7B810-9DAD-11D1-80B4-00C04FD430C8", db.IDUUID, true, false},
+		{"{6ba7b810-9dad-11d1-80b4-00c04fd430c8}", db.IDUUID, true, false},
+		{"my-id", db.IDUUID, true, false},
+		{"item-001000", db.IDSKU, true, true},
+		{"item-1000", db.IDSKU, true, false},
+		{"6ba7b810-9dad-11d1-80b4-00c04fd430c8", db.IDSKU, true, false},
+	}
+
+	for _, st := range table {
+		a := app{idScheme: st.scheme, strictIDs: st.strict}
+
+		if err := a.checkID(st.id); (err == nil) != st.ok {
+			t.Errorf("%q (%s, strict %t): wanted ok %t, got %v", st.id, st.scheme, st.strict, st.ok, err)
+		}
+	}
+}
+
+// TestPathIDWithMocks sends bad IDs and SKUs, which must
+// be rejected before they reach the DB
+func TestPathIDWithMocks(t *testing.T) {
+	d := &mockDB{fail: true} // any DB call is a 500
+	a := app{router: mux.NewRouter(), db: d, noAuth: true, idScheme: db.IDUUID, strictIDs: true}
+
+	a.addRoutes()
+
+	table := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{"GET", "/items/not-a-uuid", ""},
+		{"GET", "/items/6ba7b810-9dad-11d1-80b4", ""},
+		{"PUT", "/items/not-a-uuid", `{"name":"x"}`},
+		{"PATCH", "/items/not-a-uuid", `{"name":"x"}`},
+		{"DELETE", "/items/not-a-uuid", ""},
+		{"POST", "/items/not-a-uuid/duplicate", ""},
+		{"GET", "/items/" + strings.Repeat("x", maxIDLen+1), ""},
+		{"GET", "/skus/0", ""},
+		{"GET", "/skus/-1", ""},
+		{"GET", "/skus/2147483648", ""},
+		{"GET", "/skus/99999999999999999999", ""},
+	}
+
+	for _, st := range table {
+		r := httptest.NewRequest(st.method, "http://who-cares"+st.path, strings.NewReader(st.body))
+		w := httptest.NewRecorder()
+
+		r.Header.Set("Content-Type", mergePatchType)
+		a.router.ServeHTTP(w, r)
+
+		if resp := w.Result(); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s %.40s: wanted 400, got %d", st.method, st.path, resp.StatusCode)
+		}
+	}
+
+	// a good one gets as far as the DB
+
+	r := httptest.NewRequest("GET", "http://who-cares/items/6ba7b810-9dad-11d1-80b4-00c04fd430c8", nil)
+	w := httptest.NewRecorder()
+
+	a.router.ServeHTTP(w, r)
+
+	if resp := w.Result(); resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("valid ID: wanted 500, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,35 @@
+package tutor4
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"tutor4/db"
+)
+
+// indexCheckTimeout bounds the startup index check, so a
+// slow backend delays startup but can't stop it
+const indexCheckTimeout = 10 * time.Second
+
+// indexChecker is a backend that can tell us at startup
+// about indexes its queries need but don't have
+type indexChecker interface {
+	CheckIndexes(context.Context) []db.IndexProblem
+}
+
+// warnIndexes runs the index check and logs what it
+// finds; it's only a warning, since the queries that fail
+// may not be ones this deployment uses
+func warnIndexes(ic indexChecker) {
+	ctx, cancel := context.WithTimeout(context.Background(), indexCheckTimeout)
+	defer cancel()
+
+	for _, p := range ic.CheckIndexes(ctx) {
+		log.Printf("WARNING: MISSING INDEX? query %q failed: %s", p.Query, p.Err)
+
+		if h := p.Hint(); h != "" {
+			log.Printf("WARNING: create the index for %q at %s", p.Query, h)
+		}
+	}
+}
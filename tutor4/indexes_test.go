@@ -0,0 +1,45 @@
+package tutor4
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"tutor4/db"
+)
+
+type mockIndexes []db.IndexProblem
+
+func (m mockIndexes) CheckIndexes(context.Context) []db.IndexProblem {
+	return m
+}
+
+// TestWarnIndexes logs a missing index with the link to
+// create it
+func TestWarnIndexes(t *testing.T) {
+	var buf bytes.Buffer
+
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	const url = "https://console.firebase.google.com/v1/r/project/x/firestore/indexes?create_composite=Cg"
+
+	warnIndexes(mockIndexes{{Query: "list by name", Err: errors.New("requires an index: " + url)}})
+
+	out := buf.String()
+
+	if !strings.Contains(out, `"list by name" failed: requires an index`) || !strings.Contains(out, "at "+url) {
+		t.Errorf("invalid log: %s", out)
+	}
+
+	buf.Reset()
+	warnIndexes(mockIndexes{})
+
+	if buf.Len() != 0 {
+		t.Errorf("wanted no log, got %s", buf.String())
+	}
+}
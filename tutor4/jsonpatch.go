@@ -0,0 +1,194 @@
+package tutor4
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"tutor4/graph/model"
+)
+
+const jsonPatchType = "application/json-patch+json"
+
+// patchOp is one operation of an RFC 6902 JSON patch; a
+// missing value is nil, but a JSON null isn't
+type patchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+// patchError is why a JSON patch can't be applied, as
+// we'll send it
+type patchError struct {
+	status int
+	apiError
+}
+
+func badOp(n int, code, field, format string, args ...interface{}) *patchError {
+	msg := fmt.Sprintf("op %d: ", n) + fmt.Sprintf(format, args...)
+	return &patchError{http.StatusUnprocessableEntity, apiError{code, msg, field}}
+}
+
+// jsonPatch applies ops to an item as JSON; it only goes
+// as deep as an item does, so a path is a field, e.g.
+// /name, or an element of a list, e.g. /tags/0 or /tags/-
+// to add at the end
+//
+// we take add, remove, replace and test; ops are applied
+// in order and the first that fails stops the patch, so
+// the caller must only keep the result if it succeeds
+func jsonPatch(doc map[string]interface{}, ops []patchOp) *patchError {
+	for n, op := range ops {
+		name, index, err := patchPath(op.Path)
+
+		if err != nil {
+			return badOp(n, "invalid_path", "", "%s", err)
+		}
+
+		if _, ok := itemFields[name]; !ok {
+			return badOp(n, "invalid_path", "", "no field %q", name)
+		}
+
+		if readOnly(name) {
+			return badOp(n, "read_only", name, "%s can't be changed", name)
+		}
+
+		var value interface{}
+
+		switch op.Op {
+		case "add", "replace", "test":
+			if op.Value == nil {
+				return badOp(n, "invalid_input", name, "%s needs a value", op.Op)
+			}
+
+			if err = json.Unmarshal(op.Value, &value); err != nil {
+				return badOp(n, "invalid_input", name, "%s", err)
+			}
+
+		case "remove":
+		default:
+			return badOp(n, "unsupported_op", name, "unsupported op %q", op.Op)
+		}
+
+		if index == "" {
+			err = patchField(doc, op.Op, name, value)
+		} else {
+			err = patchElement(doc, op.Op, name, index, value)
+		}
+
+		if err == errTestFailed {
+			return &patchError{http.StatusConflict, apiError{"test_failed", fmt.Sprintf("op %d: %s doesn't match", n, op.Path), name}}
+		}
+
+		if err != nil {
+			return badOp(n, "invalid_path", name, "%s: %s", op.Path, err)
+		}
+	}
+
+	return nil
+}
+
+var errTestFailed = errors.New("test failed")
+
+// patchPath splits a JSON pointer (RFC 6901) into the
+// field and the list index, if there is one
+func patchPath(path string) (name, index string, err error) {
+	if !strings.HasPrefix(path, "/") {
+		return "", "", fmt.Errorf("invalid path %q", path)
+	}
+
+	parts := strings.Split(path[1:], "/")
+
+	if len(parts) > 2 {
+		return "", "", fmt.Errorf("path %q is too deep", path)
+	}
+
+	r := strings.NewReplacer("~1", "/", "~0", "~")
+	name = r.Replace(parts[0])
+
+	if len(parts) == 2 {
+		index = r.Replace(parts[1])
+	}
+
+	return name, index, nil
+}
+
+// readOnly is whether a client can't set an item field
+func readOnly(name string) bool {
+	for _, f := range describe(model.Item{}).Fields {
+		if f.Name == name {
+			return f.ReadOnly
+		}
+	}
+
+	return false
+}
+
+func patchField(doc map[string]interface{}, op, name string, value interface{}) error {
+	current, ok := doc[name]
+
+	if !ok && op != "add" {
+		return fmt.Errorf("no value to %s", op)
+	}
+
+	switch op {
+	case "add", "replace":
+		doc[name] = value
+	case "remove":
+		delete(doc, name)
+	case "test":
+		if !reflect.DeepEqual(current, value) {
+			return errTestFailed
+		}
+	}
+
+	return nil
+}
+
+func patchElement(doc map[string]interface{}, op, name, index string, value interface{}) error {
+	list, ok := doc[name].([]interface{})
+
+	if !ok {
+		return fmt.Errorf("%s isn't a list", name)
+	}
+
+	// - is the end of the list, which only add can use
+
+	i := len(list)
+
+	if index != "-" || op != "add" {
+		var err error
+
+		if i, err = strconv.Atoi(index); err != nil || i < 0 || i > len(list) {
+			return fmt.Errorf("invalid index %q", index)
+		}
+
+		if i == len(list) && op != "add" {
+			return fmt.Errorf("no element %d", i)
+		}
+	}
+
+	switch op {
+	case "add":
+		list = append(list, nil)
+		copy(list[i+1:], list[i:])
+		list[i] = value
+	case "replace":
+		list[i] = value
+	case "remove":
+		list = append(list[:i], list[i+1:]...)
+	case "test":
+		if !reflect.DeepEqual(list[i], value) {
+			return errTestFailed
+		}
+	}
+
+	doc[name] = list
+
+	return nil
+}
@@ -0,0 +1,185 @@
+package tutor4
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// caller is who made the request; logging puts an empty
+// one in the context so that it can see what auth (which
+// runs inside it) learned about the request
+type caller struct {
+	user string
+	role string
+}
+
+type ctxKey int
+
+const callerKey ctxKey = iota
+
+func callerFrom(ctx context.Context) *caller {
+	if c, ok := ctx.Value(callerKey).(*caller); ok {
+		return c
+	}
+
+	return nil
+}
+
+func withCaller(r *http.Request) (*http.Request, *caller) {
+	if c := callerFrom(r.Context()); c != nil {
+		return r, c
+	}
+
+	c := new(caller)
+
+	return r.WithContext(context.WithValue(r.Context(), callerKey, c)), c
+}
+
+// statusWriter remembers what the handler sent, since
+// that's not otherwise visible to the middleware
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (s *statusWriter) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusWriter) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += n
+
+	return n, err
+}
+
+// xorshift is a cheap random number generator for log
+// sampling, which doesn't need a good one; it's safe for
+// concurrent use without a lock
+type xorshift struct {
+	state uint64
+}
+
+// float returns a number in [0, 1)
+func (x *xorshift) float() float64 {
+	for {
+		old := atomic.LoadUint64(&x.state)
+		s := old
+
+		if s == 0 {
+			s = uint64(time.Now().UnixNano()) | 1
+		}
+
+		s ^= s << 13
+		s ^= s >> 7
+		s ^= s << 17
+
+		if atomic.CompareAndSwapUint64(&x.state, old, s) {
+			return float64(s>>11) / (1 << 53)
+		}
+	}
+}
+
+// sampled decides whether to log a request that's done;
+// with -log-sample, we log only that fraction of requests
+// that went well, but always those that failed (5xx) or
+// were slower than -log-slow
+func (a *app) sampled(status int, took time.Duration) bool {
+	switch {
+	case a.logDrop <= 0, status >= 500:
+		return true
+	case a.logSlow > 0 && took >= a.logSlow:
+		return true
+	case a.logDrop >= 1:
+		return false
+	}
+
+	return a.logRand.float() >= a.logDrop
+}
+
+// clfLog has no prefix, since CLF has its own timestamp
+var clfLog = log.New(os.Stderr, "", 0)
+
+const clfTime = "02/Jan/2006:15:04:05 -0700"
+
+// logCLF writes an access log in the Common Log Format:
+//
+//	host ident authuser [date] "request" status bytes
+func (a *app) logCLF(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r, c := withCaller(r)
+		sw := statusWriter{ResponseWriter: w}
+		start := time.Now()
+
+		next.ServeHTTP(&sw, r)
+
+		if !a.sampled(sw.status, time.Since(start)) {
+			return
+		}
+
+		clfLog.Printf("%s - %s [%s] %q %d %s",
+			a.clientIP(r), dash(c.user), start.Format(clfTime),
+			fmt.Sprintf("%s %s %s", r.Method, r.URL.RequestURI(), r.Proto),
+			sw.status, dash(sw.bytes))
+	})
+}
+
+// clientIP is the address of whoever sent the request;
+// behind a proxy that's in X-Forwarded-For (the leftmost
+// entry) or X-Real-IP, but we only believe those headers
+// with -trust-proxy, since anyone can send them
+func (a *app) clientIP(r *http.Request) string {
+	if a.trustProxy {
+		fwd := r.Header.Get("X-Forwarded-For")
+
+		if i := strings.IndexByte(fwd, ','); i >= 0 {
+			fwd = fwd[:i]
+		}
+
+		for _, h := range []string{fwd, r.Header.Get("X-Real-IP")} {
+			if ip := net.ParseIP(strings.TrimSpace(h)); ip != nil {
+				return ip.String()
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// dash is how CLF shows a missing field
+func dash(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		if v != "" {
+			return v
+		}
+	case int:
+		if v != 0 {
+			return fmt.Sprint(v)
+		}
+	}
+
+	return "-"
+}
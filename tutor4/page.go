@@ -0,0 +1,174 @@
+package tutor4
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"tutor4/graph/model"
+)
+
+// maxPageLimit is the most items one page of the list
+// can ask for, as with the GraphQL skus query
+const maxPageLimit = 1000
+
+// page is one page of the list, from ?limit=N&offset=M or
+// ?limit=N&after=SKU; the body stays a plain array and
+// the paging is in the headers (X-Total-Count, X-Page-Limit
+// and Link)
+//
+// an offset is a position, so an item deleted from an
+// earlier page moves the rest up and one is skipped; a
+// cursor is the SKU of the last item the client has seen,
+// and the next page is the SKUs after it, which works
+// even if that item's gone (like the GraphQL skus query)
+//
+// a cursor page of the whole collection is read on its
+// own, so it has no X-Total-Count; a filtered list is
+// read whole and cut, whichever way it's paged
+type page struct {
+	limit, offset int
+
+	cursor bool
+	after  int
+
+	// read means the DB read just the page, and one more
+	// item if there is one, rather than the whole list
+	read bool
+}
+
+// parsePage reads the page asked for; ok is false if
+// there's no ?limit, i.e. the client wants the whole list
+func parsePage(r *http.Request) (pg page, ok bool, err error) {
+	q := r.URL.Query()
+
+	if q.Get("limit") == "" {
+		if q.Get("offset") != "" || q.Get("after") != "" {
+			return pg, false, fmt.Errorf("offset and after need a limit")
+		}
+
+		return pg, false, nil
+	}
+
+	pg.limit, err = strconv.Atoi(q.Get("limit"))
+
+	if err != nil || pg.limit < 1 || pg.limit > maxPageLimit {
+		return pg, false, fmt.Errorf("limit must be between 1 and %d", maxPageLimit)
+	}
+
+	if q.Get("offset") != "" && q.Get("after") != "" {
+		return pg, false, fmt.Errorf("use offset or after, not both")
+	}
+
+	if s := q.Get("offset"); s != "" {
+		pg.offset, err = strconv.Atoi(s)
+
+		if err != nil || pg.offset < 0 {
+			return pg, false, fmt.Errorf("offset must be a position in the list")
+		}
+	}
+
+	if s := q.Get("after"); s != "" {
+		pg.cursor = true
+		pg.after, err = strconv.Atoi(s)
+
+		if err != nil || pg.after < 0 {
+			return pg, false, fmt.Errorf("after must be a SKU (0 for the first page)")
+		}
+	}
+
+	return pg, true, nil
+}
+
+// cut returns the page's part of the list, which is empty
+// past the end, and whether there's more after it; a
+// cursor page is in SKU order, whatever order the list
+// is in
+func (pg page) cut(items []*model.Item) (part []*model.Item, more bool) {
+	start := pg.offset
+
+	if pg.read {
+		start = 0
+	} else if pg.cursor {
+		items = append([]*model.Item(nil), items...)
+
+		sort.Slice(items, func(x, y int) bool {
+			return items[x].Sku < items[y].Sku
+		})
+
+		start = sort.Search(len(items), func(n int) bool {
+			return items[n].Sku > pg.after
+		})
+	}
+
+	if start >= len(items) {
+		return []*model.Item{}, false
+	}
+
+	end := start + pg.limit
+
+	if end > len(items) {
+		end = len(items)
+	}
+
+	return items[start:end], end < len(items)
+}
+
+// sendPage sets the paging headers for the page and
+// returns its part of the list
+func (a *app) sendPage(w http.ResponseWriter, r *http.Request, pg page, items []*model.Item) []*model.Item {
+	h := w.Header()
+
+	if !pg.read {
+		h.Set("X-Total-Count", strconv.Itoa(len(items)))
+	}
+
+	h.Set("X-Page-Limit", strconv.Itoa(pg.limit))
+
+	part, more := pg.cut(items)
+
+	// a cursor only goes forward
+
+	if pg.cursor {
+		if more {
+			last := strconv.Itoa(part[len(part)-1].Sku)
+			h.Set("Link", a.pageLink(r, "after", last, "next"))
+		}
+
+		return part
+	}
+
+	var links []string
+
+	if more {
+		next := pg.offset + pg.limit
+		links = append(links, a.pageLink(r, "offset", strconv.Itoa(next), "next"))
+	}
+
+	if pg.offset > 0 {
+		prev := pg.offset - pg.limit
+
+		if prev < 0 {
+			prev = 0
+		}
+
+		links = append(links, a.pageLink(r, "offset", strconv.Itoa(prev), "prev"))
+	}
+
+	if len(links) > 0 {
+		h.Set("Link", strings.Join(links, ", "))
+	}
+
+	return part
+}
+
+// pageLink is a Link header entry (RFC 8288) for another
+// page, keeping the rest of the query (e.g. tags)
+func (a *app) pageLink(r *http.Request, param, value, rel string) string {
+	q := r.URL.Query()
+	q.Set(param, value)
+
+	return fmt.Sprintf(`<%s>; rel="%s"`, a.location(r, r.URL.Path+"?"+q.Encode()), rel)
+}
@@ -0,0 +1,186 @@
+package tutor4
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"tutor4/graph/model"
+)
+
+// TestListPagesWithMocks walks the list two pages at a
+// time by following the Link header
+func TestListPagesWithMocks(t *testing.T) {
+	d := new(mockDB)
+	a := app{router: mux.NewRouter(), db: d, noAuth: true}
+
+	d.preload()
+	a.addRoutes()
+
+	get := func(url string) (*http.Response, []*model.Item) {
+		r := httptest.NewRequest("GET", url, nil)
+		w := httptest.NewRecorder()
+
+		a.router.ServeHTTP(w, r)
+
+		resp := w.Result()
+
+		var items []*model.Item
+
+		if resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(&items); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		return resp, items
+	}
+
+	resp, items := get("http://who-cares/items?limit=5")
+
+	if resp.StatusCode != http.StatusOK || len(items) != 5 || items[0].Sku != 1000 {
+		t.Fatalf("first page: invalid response %d, %d items", resp.StatusCode, len(items))
+	}
+
+	if c, l := resp.Header.Get("X-Total-Count"), resp.Header.Get("X-Page-Limit"); c != "9" || l != "5" {
+		t.Errorf("first page: invalid count %q or limit %q", c, l)
+	}
+
+	next := `<http://who-cares/items?limit=5&offset=5>; rel="next"`
+
+	if link := resp.Header.Get("Link"); link != next {
+		t.Fatalf("first page: wanted Link %s, got %s", next, link)
+	}
+
+	resp, items = get("http://who-cares/items?limit=5&offset=5")
+
+	if resp.StatusCode != http.StatusOK || len(items) != 4 || items[0].Sku != 1005 {
+		t.Fatalf("second page: invalid response %d, %d items", resp.StatusCode, len(items))
+	}
+
+	if c := resp.Header.Get("X-Total-Count"); c != "9" {
+		t.Errorf("second page: invalid count %q", c)
+	}
+
+	prev := `<http://who-cares/items?limit=5&offset=0>; rel="prev"`
+
+	if link := resp.Header.Get("Link"); link != prev {
+		t.Errorf("second page: wanted Link %s, got %s", prev, link)
+	}
+
+	// without a limit, it's the whole list as before
+
+	if resp, items = get("http://who-cares/items"); len(items) != 9 || resp.Header.Get("X-Total-Count") != "" {
+		t.Errorf("no page: invalid response, %d items", len(items))
+	}
+
+	for _, q := range []string{"limit=0", "limit=1001", "limit=x", "limit=5&offset=-1", "offset=5"} {
+		if resp, _ = get("http://who-cares/items?" + q); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: wanted 400, got %d", q, resp.StatusCode)
+		}
+	}
+
+	// paging keeps the rest of the query
+
+	resp, _ = get("http://who-cares/items?fields=id&limit=3&offset=3")
+
+	if link := resp.Header.Get("Link"); !strings.Contains(link, "fields=id") || !strings.Contains(link, "offset=6") {
+		t.Errorf("invalid Link: %s", link)
+	}
+}
+
+// TestListCursorWithMocks deletes the item a cursor points
+// at between pages; the next page must carry on after it,
+// where an offset would skip items
+func TestListCursorWithMocks(t *testing.T) {
+	d := new(mockDB)
+	a := app{router: mux.NewRouter(), db: d, noAuth: true}
+
+	d.preload()
+	a.addRoutes()
+
+	do := func(method, url string) (*http.Response, []*model.Item) {
+		r := httptest.NewRequest(method, url, nil)
+		w := httptest.NewRecorder()
+
+		a.router.ServeHTTP(w, r)
+
+		resp := w.Result()
+
+		var items []*model.Item
+
+		if method == "GET" && resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(&items); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		return resp, items
+	}
+
+	skus := func(items []*model.Item) (result []int) {
+		for _, i := range items {
+			result = append(result, i.Sku)
+		}
+
+		return result
+	}
+
+	resp, items := do("GET", "http://who-cares/items?limit=3&after=0")
+
+	if got := fmt.Sprint(skus(items)); got != "[1000 1001 1002]" {
+		t.Fatalf("first page: got %s", got)
+	}
+
+	next := `<http://who-cares/items?after=1002&limit=3>; rel="next"`
+
+	if link := resp.Header.Get("Link"); link != next {
+		t.Fatalf("first page: wanted Link %s, got %s", next, link)
+	}
+
+	// delete the cursor item and one before it
+
+	for _, i := range items[1:] {
+		if resp, _ = do("DELETE", "http://who-cares/items/"+i.ID); resp.StatusCode != http.StatusOK {
+			t.Fatalf("delete %d: %d", i.Sku, resp.StatusCode)
+		}
+	}
+
+	resp, items = do("GET", "http://who-cares/items?limit=3&after=1002")
+
+	if got := fmt.Sprint(skus(items)); got != "[1003 1004 1005]" {
+		t.Errorf("second page: got %s", got)
+	}
+
+	// it's read as a page, not cut from the whole list,
+	// so there's no total
+
+	if c := resp.Header.Get("X-Total-Count"); c != "" || d.pages != 2 {
+		t.Errorf("second page: count %q after %d page reads", c, d.pages)
+	}
+
+	// the offset for the same page now skips two items
+
+	if _, items = do("GET", "http://who-cares/items?limit=3&offset=3"); skus(items)[0] != 1005 {
+		t.Errorf("offset page: got %v", skus(items))
+	}
+
+	// the last page has no next link
+
+	resp, items = do("GET", "http://who-cares/items?limit=3&after=1005")
+
+	if len(items) != 3 || resp.Header.Get("Link") != "" {
+		t.Errorf("last page: got %v, Link %q", skus(items), resp.Header.Get("Link"))
+	}
+
+	for _, q := range []string{"after=5", "limit=3&after=x", "limit=3&after=-1", "limit=3&after=5&offset=1"} {
+		if resp, _ = do("GET", "http://who-cares/items?"+q); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: wanted 400, got %d", q, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package tutor4
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"tutor4/db"
+	"tutor4/graph/model"
+)
+
+const mergePatchType = "application/merge-patch+json"
+
+// mergePatch applies an RFC 7386 merge patch to the target:
+// members of the patch replace those in the target, null
+// removes them, and objects are merged recursively
+func mergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+
+	if !ok {
+		return patch
+	}
+
+	t, ok := target.(map[string]interface{})
+
+	if !ok {
+		t = make(map[string]interface{}, len(p))
+	}
+
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+			continue
+		}
+
+		t[k] = mergePatch(t[k], v)
+	}
+
+	return t
+}
+
+// readOnlyChange finds a read-only field the patch would
+// change, if there is one; setting it to its current value
+// is harmless, so we allow that (and a SKU may be given as
+// a number or a string either way)
+func readOnlyChange(current, patch map[string]interface{}) string {
+	for _, f := range describe(model.Item{}).Fields {
+		if v, ok := patch[f.Name]; ok && f.ReadOnly && scalar(v) != scalar(current[f.Name]) {
+			return f.Name
+		}
+	}
+
+	return ""
+}
+
+// scalar is the text of a JSON value, so 1000 and "1000"
+// come out the same
+func scalar(v interface{}) string {
+	if f, ok := v.(float64); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+
+	return fmt.Sprint(v)
+}
+
+// patch updates part of an item, with either a merge patch
+// or a JSON patch; there's no version to check, so a
+// concurrent write between our read and write may be
+// lost, just as with PUT
+func (a *app) patch(w http.ResponseWriter, r *http.Request) {
+	id, ok := a.pathID(w, r)
+
+	if !ok {
+		return
+	}
+
+	var (
+		patch map[string]interface{}
+		ops   []patchOp
+	)
+
+	switch ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct {
+	case mergePatchType:
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil || patch == nil {
+			jsonError(w, http.StatusBadRequest, "invalid_input", "a merge patch must be a JSON object")
+			return
+		}
+
+	case jsonPatchType:
+		if err := json.NewDecoder(r.Body).Decode(&ops); err != nil || ops == nil {
+			jsonError(w, http.StatusBadRequest, "invalid_input", "a JSON patch must be a list of ops")
+			return
+		}
+
+	default:
+		w.Header().Set("Accept-Patch", mergePatchType+", "+jsonPatchType)
+		jsonError(w, http.StatusUnsupportedMediaType, "unsupported_patch", "PATCH takes "+mergePatchType+" or "+jsonPatchType)
+		return
+	}
+
+	// the patch applies to what's stored now, not to a read
+	// shared with other callers that may predate it
+
+	stored, err := a.dbFor(r).GetItem(db.WithFresh(r.Context()), id)
+
+	if err != nil {
+		dbError(w, err)
+		return
+	}
+
+	var current map[string]interface{}
+
+	if err = roundTrip(stored, &current); err != nil {
+		jsonError(w, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+
+	var patched interface{} = current
+
+	if ops != nil {
+		if perr := jsonPatch(current, ops); perr != nil {
+			writeError(w, perr.status, perr.apiError)
+			return
+		}
+	} else {
+		if name := readOnlyChange(current, patch); name != "" {
+			writeError(w, http.StatusBadRequest, apiError{"read_only", fmt.Sprintf("%s can't be changed", name), name})
+			return
+		}
+
+		patched = mergePatch(current, patch)
+	}
+
+	var item model.Item
+
+	if err = roundTrip(patched, &item); err != nil {
+		jsonError(w, http.StatusBadRequest, "invalid_input", err.Error())
+		return
+	}
+
+	if err = item.Validate(); err != nil {
+		invalidItem(w, err)
+		return
+	}
+
+	item.ID, item.Sku = stored.ID, stored.Sku
+
+	if err = a.dbFor(r).UpdateItem(r.Context(), &item); err != nil {
+		dbError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	_ = json.NewEncoder(w).Encode(item)
+}
+
+// roundTrip copies from one type to another through JSON
+func roundTrip(from, to interface{}) error {
+	b, err := json.Marshal(from)
+
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, to)
+}
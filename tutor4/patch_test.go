@@ -0,0 +1,102 @@
+package tutor4
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"tutor4/graph/model"
+)
+
+// TestPatchWithMocks applies merge patches to an item
+// with a name and tags
+func TestPatchWithMocks(t *testing.T) {
+	table := []struct {
+		name   string
+		ctype  string
+		body   string
+		status int
+		want   string // the item's name and tags after
+	}{
+		{"set", mergePatchType, `{"name":"new"}`, http.StatusOK, "new [a b]"},
+		{"clear", mergePatchType, `{"tags":null}`, http.StatusOK, "old []"},
+		{"both", mergePatchType, `{"name":"new","tags":["c"]}`, http.StatusOK, "new [c]"},
+		{"same-sku", mergePatchType, `{"sku":1000,"name":"new"}`, http.StatusOK, "new [a b]"},
+		{"id", mergePatchType, `{"id":"other"}`, http.StatusBadRequest, "old [a b]"},
+		{"sku", mergePatchType, `{"sku":2000}`, http.StatusBadRequest, "old [a b]"},
+		{"no-name", mergePatchType, `{"name":null}`, http.StatusUnprocessableEntity, "old [a b]"},
+		{"not-object", mergePatchType, `["name"]`, http.StatusBadRequest, "old [a b]"},
+		{"json", "application/json", `{"name":"new"}`, http.StatusUnsupportedMediaType, "old [a b]"},
+		{"op-replace", jsonPatchType, `[{"op":"replace","path":"/name","value":"new"}]`, http.StatusOK, "new [a b]"},
+		{"op-tags", jsonPatchType, `[{"op":"add","path":"/tags/-","value":"c"},{"op":"remove","path":"/tags/0"}]`, http.StatusOK, "old [b c]"},
+		{"op-insert", jsonPatchType, `[{"op":"add","path":"/tags/1","value":"c"}]`, http.StatusOK, "old [a c b]"},
+		{"op-remove", jsonPatchType, `[{"op":"remove","path":"/tags"}]`, http.StatusOK, "old []"},
+		{"op-test", jsonPatchType, `[{"op":"test","path":"/name","value":"old"},{"op":"replace","path":"/name","value":"new"}]`, http.StatusOK, "new [a b]"},
+		{"op-test-fails", jsonPatchType, `[{"op":"test","path":"/name","value":"other"},{"op":"replace","path":"/name","value":"new"}]`, http.StatusConflict, "old [a b]"},
+		{"op-id", jsonPatchType, `[{"op":"replace","path":"/id","value":"other"}]`, http.StatusUnprocessableEntity, "old [a b]"},
+		{"op-sku", jsonPatchType, `[{"op":"replace","path":"/name","value":"new"},{"op":"remove","path":"/sku"}]`, http.StatusUnprocessableEntity, "old [a b]"},
+		{"op-unknown", jsonPatchType, `[{"op":"replace","path":"/price","value":1}]`, http.StatusUnprocessableEntity, "old [a b]"},
+		{"op-index", jsonPatchType, `[{"op":"replace","path":"/tags/2","value":"c"}]`, http.StatusUnprocessableEntity, "old [a b]"},
+		{"op-move", jsonPatchType, `[{"op":"move","from":"/name","path":"/externalKey"}]`, http.StatusUnprocessableEntity, "old [a b]"},
+		{"op-no-name", jsonPatchType, `[{"op":"remove","path":"/name"}]`, http.StatusUnprocessableEntity, "old [a b]"},
+		{"op-not-list", jsonPatchType, `{"op":"remove","path":"/name"}`, http.StatusBadRequest, "old [a b]"},
+	}
+
+	for _, st := range table {
+		t.Run(st.name, func(t *testing.T) {
+			item := &model.Item{ID: "x", Name: "old", Sku: 1000, Tags: []string{"a", "b"}}
+			d := &mockDB{data: map[string]*model.Item{"x": item}, next: 1001}
+			a := app{router: mux.NewRouter(), db: d, noAuth: true}
+
+			a.addRoutes()
+
+			r := httptest.NewRequest("PATCH", "http://who-cares/items/x", strings.NewReader(st.body))
+			w := httptest.NewRecorder()
+
+			r.Header.Set("Content-Type", st.ctype)
+			a.router.ServeHTTP(w, r)
+
+			if w.Code != st.status {
+				t.Errorf("invalid response: %d %s", w.Code, w.Body)
+			}
+
+			got := d.data["x"]
+			tags := got.Tags
+
+			if tags == nil {
+				tags = []string{}
+			}
+
+			if s := fmt.Sprint(got.Name, " ", tags); s != st.want {
+				t.Errorf("wanted %s, got %s", st.want, s)
+			}
+
+			if got.Sku != 1000 {
+				t.Errorf("SKU changed to %d", got.Sku)
+			}
+		})
+	}
+}
+
+// TestPatchMissingWithMocks patches an item that isn't there
+func TestPatchMissingWithMocks(t *testing.T) {
+	d := new(mockDB)
+	a := app{router: mux.NewRouter(), db: d, noAuth: true}
+
+	d.preload()
+	a.addRoutes()
+
+	r := httptest.NewRequest("PATCH", "http://who-cares/items/nope", strings.NewReader(`{"name":"new"}`))
+	w := httptest.NewRecorder()
+
+	r.Header.Set("Content-Type", mergePatchType)
+	a.router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("invalid response: %d", w.Code)
+	}
+}
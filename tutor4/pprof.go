@@ -0,0 +1,49 @@
+package tutor4
+
+import (
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+)
+
+// pprofPrefix is where -pprof mounts the profiler
+const pprofPrefix = "/debug/pprof/"
+
+// addPprof mounts the profiler on its own subrouter, so it
+// can't shadow our routes; it skips basic auth (and the
+// handler timeouts, since a profile takes a while), so it
+// only answers on loopback
+func (a *app) addPprof() {
+	p := a.router.PathPrefix(pprofPrefix).Subrouter()
+	p.Use(localOnly)
+
+	p.HandleFunc("/cmdline", pprof.Cmdline)
+	p.HandleFunc("/profile", pprof.Profile)
+	p.HandleFunc("/symbol", pprof.Symbol)
+	p.HandleFunc("/trace", pprof.Trace)
+
+	// the index also serves the named profiles, e.g. heap
+
+	p.PathPrefix("/").HandlerFunc(pprof.Index)
+}
+
+func (a *app) isPprof(r *http.Request) bool {
+	return a.pprof && strings.HasPrefix(r.URL.Path, pprofPrefix)
+}
+
+// localOnly refuses anything that didn't come from this
+// machine, including requests passed on by a local proxy
+func localOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		ip := net.ParseIP(host)
+
+		if err != nil || ip == nil || !ip.IsLoopback() || r.Header.Get("X-Forwarded-For") != "" {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,48 @@
+package tutor4
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// TestPprofWithMocks checks the profiler is only there with
+// -pprof, only for local callers, and doesn't shadow items
+func TestPprofWithMocks(t *testing.T) {
+	table := []struct {
+		args   []string
+		path   string
+		remote string
+		status int
+	}{
+		{[]string{"-pprof"}, "/debug/pprof/", "127.0.0.1:1234", http.StatusOK},
+		{[]string{"-pprof"}, "/debug/pprof/heap", "[::1]:1234", http.StatusOK},
+		{[]string{"-pprof"}, "/debug/pprof/", "192.0.2.1:1234", http.StatusForbidden},
+		{[]string{"-pprof"}, "/items", "127.0.0.1:1234", http.StatusUnauthorized},
+		{nil, "/debug/pprof/", "127.0.0.1:1234", http.StatusNotFound},
+	}
+
+	for _, st := range table {
+		d := new(mockDB)
+		a := app{router: mux.NewRouter(), db: d}
+
+		if err := a.fromArgs(st.args); err != nil {
+			t.Fatal(err)
+		}
+
+		d.preload()
+		a.addRoutes()
+
+		r := httptest.NewRequest("GET", "http://who-cares"+st.path, nil)
+		r.RemoteAddr = st.remote
+		w := httptest.NewRecorder()
+
+		a.router.ServeHTTP(w, r)
+
+		if w.Code != st.status {
+			t.Errorf("%v %s from %s: invalid response: %d", st.args, st.path, st.remote, w.Code)
+		}
+	}
+}
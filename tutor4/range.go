@@ -0,0 +1,99 @@
+package tutor4
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"tutor4/graph/model"
+)
+
+// rangeUnit is the unit for Range on a list (RFC 7233
+// lets us make up our own), as some grid libraries ask
+// for rows with Range: items=0-49
+const rangeUnit = "items"
+
+// itemRange is an inclusive range of list positions;
+// last is -1 for an open range (items=10-)
+type itemRange struct {
+	first, last int
+}
+
+// parseRange reads Range: items=first-last; ok is false if
+// there's no range we understand, in which case (as RFC
+// 7233 allows) we ignore it and send the whole list
+//
+// we don't do several ranges at once, or a suffix range
+// (items=-10), which we'd have to send as multipart
+func parseRange(r *http.Request) (rg itemRange, ok bool) {
+	h := r.Header.Get("Range")
+
+	if !strings.HasPrefix(h, rangeUnit+"=") {
+		return rg, false
+	}
+
+	spec := strings.TrimSpace(h[len(rangeUnit)+1:])
+	dash := strings.IndexByte(spec, '-')
+
+	if dash < 1 {
+		return rg, false
+	}
+
+	first, err := strconv.Atoi(spec[:dash])
+
+	if err != nil || first < 0 {
+		return rg, false
+	}
+
+	rg = itemRange{first, -1}
+
+	if rest := spec[dash+1:]; rest != "" {
+		last, err := strconv.Atoi(rest)
+
+		if err != nil || last < first {
+			return rg, false
+		}
+
+		rg.last = last
+	}
+
+	return rg, true
+}
+
+// slice picks the range out of the list, clipping it at
+// the end; ok is false if it starts past the end, which
+// includes any range of an empty list
+func (rg itemRange) slice(items []*model.Item) (part []*model.Item, last int, ok bool) {
+	if rg.first >= len(items) {
+		return nil, 0, false
+	}
+
+	last = rg.last
+
+	if last < 0 || last >= len(items) {
+		last = len(items) - 1
+	}
+
+	return items[rg.first : last+1], last, true
+}
+
+// sendRange sets up a 206 for the range and returns the
+// part to send, or sends 416 and returns false
+func sendRange(w http.ResponseWriter, rg itemRange, items []*model.Item) ([]*model.Item, bool) {
+	part, last, ok := rg.slice(items)
+
+	if !ok {
+		w.Header().Set("Content-Range", fmt.Sprintf("%s */%d", rangeUnit, len(items)))
+
+		msg := fmt.Sprintf("range starts at %d but there are %d items", rg.first, len(items))
+		jsonError(w, http.StatusRequestedRangeNotSatisfiable, "invalid_range", msg)
+		return nil, false
+	}
+
+	w.Header().Set("Content-Range", fmt.Sprintf("%s %d-%d/%d", rangeUnit, rg.first, last, len(items)))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusPartialContent)
+
+	return part, true
+}
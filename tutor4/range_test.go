@@ -0,0 +1,109 @@
+package tutor4
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"tutor4/graph/model"
+)
+
+// TestParseRange reads the ranges we take and ignores
+// the rest
+func TestParseRange(t *testing.T) {
+	table := []struct {
+		header string
+		want   itemRange
+		ok     bool
+	}{
+		{"items=0-49", itemRange{0, 49}, true},
+		{"items=10-", itemRange{10, -1}, true},
+		{"items=5-5", itemRange{5, 5}, true},
+		{"items=5-4", itemRange{}, false},
+		{"items=-10", itemRange{}, false},
+		{"items=0-4,10-14", itemRange{}, false},
+		{"items=x-4", itemRange{}, false},
+		{"bytes=0-49", itemRange{}, false},
+		{"", itemRange{}, false},
+	}
+
+	for _, st := range table {
+		r := httptest.NewRequest("GET", "http://who-cares/items", nil)
+
+		if st.header != "" {
+			r.Header.Set("Range", st.header)
+		}
+
+		got, ok := parseRange(r)
+
+		if ok != st.ok || ok && got != st.want {
+			t.Errorf("%q: wanted %v %t, got %v %t", st.header, st.want, st.ok, got, ok)
+		}
+	}
+}
+
+// TestListRangeWithMocks asks for slices of the list,
+// including one past the end
+func TestListRangeWithMocks(t *testing.T) {
+	d := new(mockDB)
+	a := app{router: mux.NewRouter(), db: d, noAuth: true}
+
+	d.preload()
+	a.addRoutes()
+
+	table := []struct {
+		header  string
+		status  int
+		content string // Content-Range
+		skus    []int
+	}{
+		{"items=0-2", http.StatusPartialContent, "items 0-2/9", []int{1000, 1001, 1002}},
+		{"items=7-20", http.StatusPartialContent, "items 7-8/9", []int{1007, 1008}},
+		{"items=8-", http.StatusPartialContent, "items 8-8/9", []int{1008}},
+		{"items=9-10", http.StatusRequestedRangeNotSatisfiable, "items */9", nil},
+		{"items=3-1", http.StatusOK, "", nil}, // ignored
+	}
+
+	for _, st := range table {
+		r := httptest.NewRequest("GET", "http://who-cares/items", nil)
+		w := httptest.NewRecorder()
+
+		r.Header.Set("Range", st.header)
+		a.router.ServeHTTP(w, r)
+
+		resp := w.Result()
+
+		if resp.StatusCode != st.status {
+			t.Errorf("%s: wanted %d, got %d", st.header, st.status, resp.StatusCode)
+			continue
+		}
+
+		if got := resp.Header.Get("Content-Range"); got != st.content {
+			t.Errorf("%s: wanted Content-Range %q, got %q", st.header, st.content, got)
+		}
+
+		if st.status != http.StatusPartialContent {
+			continue
+		}
+
+		var items []*model.Item
+
+		if err := json.NewDecoder(resp.Body).Decode(&items); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(items) != len(st.skus) {
+			t.Errorf("%s: wanted %d items, got %d", st.header, len(st.skus), len(items))
+			continue
+		}
+
+		for n, i := range items {
+			if i.Sku != st.skus[n] {
+				t.Errorf("%s: %d: wanted SKU %d, got %d", st.header, n, st.skus[n], i.Sku)
+			}
+		}
+	}
+}
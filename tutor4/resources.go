@@ -0,0 +1,198 @@
+package tutor4
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"tutor4/db"
+)
+
+// a resource is a path we serve items under, e.g. /offers,
+// and the collection they're kept in; each has its own
+// DB, so its own SKU counter
+type resource struct {
+	path       string
+	collection string
+	db         db.DB
+}
+
+var resourcePattern = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+
+// reservedPaths are taken by routes that aren't resources
+var reservedPaths = map[string]bool{
+	"admin":   true,
+	"changes": true,
+	"debug":   true,
+	"graphql": true,
+	"healthz": true,
+	"readyz":  true,
+	"schema":  true,
+	"skus":    true,
+	"stats":   true,
+}
+
+// parseResources reads -resources, e.g. items:items,offers:offers
+// (path:collection); empty means just items, as before
+const resourcesUsage = "paths to serve items under and their collections, e.g. items:items,offers:offers (default just items from -data)"
+
+func parseResources(s string) ([]*resource, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	var result []*resource
+
+	seen := map[string]bool{}
+
+	for _, part := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), ":", 2)
+
+		if len(kv) != 2 || kv[1] == "" {
+			return nil, fmt.Errorf("invalid resource %q (want path:collection)", part)
+		}
+
+		path := kv[0]
+
+		if !resourcePattern.MatchString(path) || reservedPaths[path] {
+			return nil, fmt.Errorf("invalid resource path %q", path)
+		}
+
+		if seen[path] {
+			return nil, fmt.Errorf("resource %s given twice", path)
+		}
+
+		seen[path] = true
+		result = append(result, &resource{path: path, collection: kv[1]})
+	}
+
+	return result, nil
+}
+
+// openResources gives each resource its DB; one in the
+// -data collection shares the main DB (which GraphQL and
+// /skus use), and the others are opened with their own
+// collection and a util collection of their own for the
+// SKU counter, e.g. util-offers
+func (a *app) openResources() error {
+	for _, res := range a.resources {
+		if res.collection == a.data {
+			res.db = a.db
+			continue
+		}
+
+		opts := a.backendOpts()
+		opts["data"] = res.collection
+		opts["util"] = a.util + "-" + res.collection
+
+		d, err := a.openDB(opts)
+
+		if err != nil {
+			return fmt.Errorf("resource %s: %w", res.path, err)
+		}
+
+		res.db = d
+	}
+
+	return nil
+}
+
+// resourceDB is the DB of the resource named by ?resource=
+// and its path, or the main DB if there's none; if there's
+// no such resource, it sends a 400 and returns false
+func (a *app) resourceDB(w http.ResponseWriter, r *http.Request) (db.DB, string, bool) {
+	path := r.URL.Query().Get("resource")
+
+	if path == "" {
+		return a.db, "items", true
+	}
+
+	for _, res := range a.resources {
+		if res.path == path {
+			return res.db, path, true
+		}
+	}
+
+	if path == "items" && len(a.resources) == 0 {
+		return a.db, path, true
+	}
+
+	writeError(w, http.StatusBadRequest, apiError{"invalid_resource", "no resource " + path, "resource"})
+	return nil, "", false
+}
+
+// stores is every DB we serve, the main one first
+func (a *app) stores() []db.DB {
+	result := []db.DB{a.db}
+
+	for _, res := range a.resources {
+		if res.db != a.db {
+			result = append(result, res.db)
+		}
+	}
+
+	return result
+}
+
+type resourceKey struct{}
+
+// scope tells the item handlers which resource they're
+// serving
+func (res *resource) scope(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), resourceKey{}, res)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// dbFor is the DB of the resource a request is for, or
+// the main one outside any resource
+func (a *app) dbFor(r *http.Request) db.DB {
+	if res, ok := r.Context().Value(resourceKey{}).(*resource); ok {
+		return res.db
+	}
+
+	return a.db
+}
+
+// resourcePath is where the request's resource is served,
+// e.g. /items
+func resourcePath(r *http.Request) string {
+	if res, ok := r.Context().Value(resourceKey{}).(*resource); ok {
+		return "/" + res.path
+	}
+
+	return "/items"
+}
+
+// addResources adds the item routes for each resource,
+// or just /items on the main DB if there aren't any
+func (a *app) addResources(rest *mux.Router) {
+	resources := a.resources
+
+	if len(resources) == 0 {
+		resources = []*resource{{path: "items", collection: a.data, db: a.db}}
+	}
+
+	for _, res := range resources {
+		sub := rest.PathPrefix("/" + res.path).Subrouter()
+		sub.Use(res.scope)
+
+		sub.HandleFunc("", a.list).Methods("GET")
+		sub.HandleFunc("", a.editor(a.add)).Methods("POST")
+		sub.HandleFunc("/upsert", a.editor(a.upsert)).Methods("POST")
+		sub.HandleFunc("/bulk", a.editor(a.bulk)).Methods("POST")
+		sub.HandleFunc("/import", a.editor(a.importItems)).Methods("POST")
+		sub.HandleFunc("/lookup", a.lookup).Methods("POST")
+
+		sub.HandleFunc("/{id}", a.get).Methods("GET")
+		sub.HandleFunc("/{id}", a.editor(a.put)).Methods("PUT")
+		sub.HandleFunc("/{id}", a.editor(a.patch)).Methods("PATCH")
+		sub.HandleFunc("/{id}", a.editor(a.drop)).Methods("DELETE")
+		sub.HandleFunc("/{id}/duplicate", a.editor(a.duplicate)).Methods("POST")
+	}
+}
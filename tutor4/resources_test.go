@@ -0,0 +1,85 @@
+package tutor4
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// TestParseResources reads -resources and rejects paths
+// we can't serve items under
+func TestParseResources(t *testing.T) {
+	table := []struct {
+		in   string
+		want []string // path:collection
+		ok   bool
+	}{
+		{"", nil, true},
+		{"items:items", []string{"items:items"}, true},
+		{"items:items, offers:offer-data", []string{"items:items", "offers:offer-data"}, true},
+		{"items", nil, false},
+		{"items:", nil, false},
+		{":items", nil, false},
+		{"Items:items", nil, false},
+		{"skus:skus", nil, false},
+		{"items:a,items:b", nil, false},
+	}
+
+	for _, st := range table {
+		got, err := parseResources(st.in)
+
+		if (err == nil) != st.ok {
+			t.Errorf("%q: unexpected error %v", st.in, err)
+			continue
+		}
+
+		if len(got) != len(st.want) {
+			t.Errorf("%q: wanted %v, got %d", st.in, st.want, len(got))
+			continue
+		}
+
+		for n, res := range got {
+			if s := res.path + ":" + res.collection; s != st.want[n] {
+				t.Errorf("%q: wanted %s, got %s", st.in, st.want[n], s)
+			}
+		}
+	}
+}
+
+// TestReindexResourcesWithMocks reindexes every resource's
+// DB, not just the main one
+func TestReindexResourcesWithMocks(t *testing.T) {
+	d, offers := new(mockDB), new(mockDB)
+	a := app{
+		router: mux.NewRouter(),
+		db:     d,
+		noAuth: true,
+		resources: []*resource{
+			{path: "items", collection: "items", db: d},
+			{path: "offers", collection: "offers", db: offers},
+		},
+	}
+
+	d.preload()
+	offers.preload()
+	a.addRoutes()
+
+	r := httptest.NewRequest("POST", "http://who-cares/admin/reindex", nil)
+	w := httptest.NewRecorder()
+
+	a.router.ServeHTTP(w, r)
+
+	var result struct {
+		Updated int `json:"updated"`
+	}
+
+	if err := json.NewDecoder(w.Result().Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Updated != 18 {
+		t.Errorf("wanted 18 reindexed, got %d", result.Updated)
+	}
+}
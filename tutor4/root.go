@@ -0,0 +1,82 @@
+package tutor4
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql/playground"
+)
+
+// Version is set when we build a release, e.g.
+//
+//	go build -ldflags "-X tutor4.Version=1.2.3"
+var Version = "dev"
+
+// what we can serve at /
+const (
+	rootPlayground = "playground"
+	rootRedirect   = "redirect"
+	rootStatus     = "status"
+)
+
+func (a *app) checkRoot() error {
+	switch a.root {
+	case rootPlayground, rootStatus:
+		return nil
+	case rootRedirect:
+		if a.rootURL == "" {
+			return fmt.Errorf("-root=redirect needs -root-url")
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("invalid root: %s", a.root)
+}
+
+// rootHandler is the GraphQL playground by default, but
+// in production we may not want that to be the first
+// thing people see
+func (a *app) rootHandler() http.Handler {
+	switch a.root {
+	case rootRedirect:
+		return http.RedirectHandler(a.rootURL, http.StatusFound)
+	case rootStatus:
+		return http.HandlerFunc(a.status)
+	}
+
+	return playground.Handler("GraphQL playground", "/graphql")
+}
+
+type statusReport struct {
+	Status  string `json:"status"`
+	Version string `json:"version"`
+	Uptime  string `json:"uptime"`
+}
+
+// status is a summary for people; probes should use
+// /healthz and /readyz, which are cheaper to parse
+func (a *app) status(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), healthTimeout)
+	defer cancel()
+
+	report := statusReport{
+		Status:  "ok",
+		Version: Version,
+		Uptime:  time.Since(a.started).Round(time.Second).String(),
+	}
+
+	code := http.StatusOK
+
+	if err := a.db.Ping(ctx); err != nil {
+		report.Status, code = "unhealthy", http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	_ = json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,77 @@
+package tutor4
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// TestRootWithMocks checks / in each -root mode
+func TestRootWithMocks(t *testing.T) {
+	table := []struct {
+		name   string
+		args   []string
+		status int
+		check  func(*httptest.ResponseRecorder) bool
+	}{
+		{
+			"playground", nil, http.StatusOK,
+			func(w *httptest.ResponseRecorder) bool {
+				return strings.Contains(w.Body.String(), "GraphQL playground")
+			},
+		},
+		{
+			"redirect", []string{"-root", "redirect", "-root-url", "https://example.com/docs"}, http.StatusFound,
+			func(w *httptest.ResponseRecorder) bool {
+				return w.Header().Get("Location") == "https://example.com/docs"
+			},
+		},
+		{
+			"status", []string{"-root", "status"}, http.StatusOK,
+			func(w *httptest.ResponseRecorder) bool {
+				var s statusReport
+
+				err := json.NewDecoder(w.Body).Decode(&s)
+
+				return err == nil && s.Status == "ok" && s.Version == Version && s.Uptime != ""
+			},
+		},
+	}
+
+	for _, st := range table {
+		t.Run(st.name, func(t *testing.T) {
+			a := app{router: mux.NewRouter(), db: new(mockDB)}
+
+			if err := a.fromArgs(append(st.args, "-no-auth")); err != nil {
+				t.Fatal(err)
+			}
+
+			a.addRoutes()
+
+			r := httptest.NewRequest("GET", "http://who-cares/", nil)
+			w := httptest.NewRecorder()
+
+			a.router.ServeHTTP(w, r)
+
+			if w.Code != st.status {
+				t.Fatalf("invalid response: %d", w.Code)
+			}
+
+			if !st.check(w) {
+				t.Errorf("invalid root: %v %s", w.Header(), w.Body)
+			}
+		})
+	}
+
+	for _, args := range [][]string{{"-root", "redirect"}, {"-root", "docs"}} {
+		a := app{router: mux.NewRouter()}
+
+		if err := a.fromArgs(args); err == nil {
+			t.Errorf("%v: accepted", args)
+		}
+	}
+}
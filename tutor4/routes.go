@@ -0,0 +1,97 @@
+package tutor4
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/gorilla/mux"
+)
+
+// routeInfo is one route; no methods means it takes any
+// (e.g. /graphql, which checks for itself)
+type routeInfo struct {
+	Path    string   `json:"path"`
+	Methods []string `json:"methods"`
+}
+
+// routes walks the router for the routes we serve, in the
+// order they're matched
+func (a *app) routes() ([]routeInfo, error) {
+	var result []routeInfo
+
+	visit := func(route *mux.Route, _ *mux.Router, ancestors []*mux.Route) error {
+		// a subrouter's own route has no handler, and
+		// maybe no path either; its routes are walked
+		// after it
+
+		if route.GetHandler() == nil {
+			return nil
+		}
+
+		if err := route.GetError(); err != nil {
+			return err
+		}
+
+		// a route in a subrouter that matched on path
+		// (like /graphql) has no path of its own
+
+		t, err := route.GetPathTemplate()
+
+		for n := len(ancestors) - 1; err != nil && n >= 0; n-- {
+			t, err = ancestors[n].GetPathTemplate()
+		}
+
+		if err != nil {
+			return err
+		}
+
+		// GetMethods fails for a route without any, which
+		// isn't an error for us
+
+		m, _ := route.GetMethods()
+
+		if m == nil {
+			m = []string{}
+		}
+
+		result = append(result, routeInfo{t, m})
+		return nil
+	}
+
+	if err := a.router.Walk(visit); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (a *app) listRoutes() {
+	routes, err := a.routes()
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	for _, r := range routes {
+		log.Println("route", r.Path, r.Methods)
+	}
+}
+
+// routeTable is the routes as JSON, for tools such as a
+// gateway or docs generator to find what we serve
+func (a *app) routeTable(w http.ResponseWriter, r *http.Request) {
+	routes, err := a.routes()
+
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	_ = json.NewEncoder(w).Encode(routes)
+}
@@ -0,0 +1,70 @@
+package tutor4
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"tutor4/graph/model"
+)
+
+type field struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	ReadOnly bool   `json:"readOnly"`
+}
+
+type schema struct {
+	Name   string  `json:"name"`
+	Fields []field `json:"fields"`
+}
+
+var kinds = map[reflect.Kind]string{
+	reflect.String:  "string",
+	reflect.Int:     "integer",
+	reflect.Int64:   "integer",
+	reflect.Float64: "number",
+	reflect.Bool:    "boolean",
+	reflect.Slice:   "array",
+}
+
+// describe walks the struct's JSON tags, so the schema
+// stays in sync as fields are added to the model
+func describe(v interface{}) schema {
+	t := reflect.TypeOf(v)
+	s := schema{Name: t.Name()}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+
+		if name == "" || name == "-" {
+			continue
+		}
+
+		kind, ok := kinds[f.Type.Kind()]
+
+		if !ok {
+			kind = f.Type.Kind().String()
+		}
+
+		s.Fields = append(s.Fields, field{
+			Name:     name,
+			Type:     kind,
+			ReadOnly: f.Tag.Get("api") == "readonly",
+		})
+	}
+
+	return s
+}
+
+func (a *app) schema(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(describe(model.Item{})); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, err)
+	}
+}
@@ -2,69 +2,339 @@ package tutor4
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"mime"
 	"net/http"
-	"net/url"
+	"sort"
 	"strconv"
-
-	"github.com/gorilla/mux"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"tutor4/db"
 	"tutor4/graph/model"
 )
 
-func logRequest(next http.Handler) http.Handler {
+func (a *app) logRequest(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		buf, err := ioutil.ReadAll(r.Body)
+		var buf []byte
 
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		// a restore is a whole backup, far too much to
+		// hold, let alone log
+
+		if !unlogged[r.URL.Path] {
+			var err error
+
+			if buf, err = ioutil.ReadAll(r.Body); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			r.Body = ioutil.NopCloser(bytes.NewBuffer(buf))
+		}
+
+		r, _ = withCaller(r)
+
+		sw := statusWriter{ResponseWriter: w}
+		start := time.Now()
+
+		next.ServeHTTP(&sw, r)
+
+		if a.sampled(sw.status, time.Since(start)) {
+			log.Println(r.Method, r.RequestURI, string(buf), sw.status)
+		}
+	})
+}
+
+// unlogged are paths whose request bodies we don't log
+var unlogged = map[string]bool{
+	"/admin/restore": true,
+}
+
+// probes are exempt from some middleware since the
+// load balancer calls them directly over plain HTTP
+var probes = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+	"/metrics": true,
+}
+
+// forceHTTPS redirects plain HTTP to HTTPS; we're assumed to
+// be behind a proxy that terminates TLS and tells us how the
+// client reached it
+func forceHTTPS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" || probes[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// redirecting a request with a body would have the
+		// client resend it in the clear, so we refuse it
+
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "HTTPS required", http.StatusBadRequest)
+			return
+		}
+
+		http.Redirect(w, r, "https://"+r.Host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
+
+// limitWait is how long a request will queue for a
+// slot before we give up and shed it
+const limitWait = 100 * time.Millisecond
+
+// limitURI refuses a request with an overlong URI before
+// we route it or parse its query, e.g. thousands of SKUs
+// on a GET
+func (a *app) limitURI(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n := len(r.URL.RequestURI()); n > a.maxURI {
+			msg := fmt.Sprintf("URI is %d bytes (max %d)", n, a.maxURI)
+			jsonError(w, http.StatusRequestURITooLong, "uri_too_long", msg)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limit caps the number of requests in flight, so a burst
+// can't open an unbounded number of Firestore calls
+func (a *app) limit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if probes[r.URL.Path] {
+			next.ServeHTTP(w, r)
 			return
 		}
 
-		r.Body = ioutil.NopCloser(bytes.NewBuffer(buf))
+		t := time.NewTimer(limitWait)
+		defer t.Stop()
+
+		select {
+		case a.sem <- struct{}{}:
+			defer func() { <-a.sem }()
+
+		case <-t.C:
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Too busy", http.StatusServiceUnavailable)
+			return
+		}
 
-		log.Println(r.Method, r.RequestURI, bytes.NewBuffer(buf).String())
 		next.ServeHTTP(w, r)
 	})
 }
 
-func basicAuth(next http.Handler) http.Handler {
+func (a *app) basicAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if probes[r.URL.Path] || a.isPprof(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var role string
+
 		user, pass, ok := r.BasicAuth()
 
-		if !ok || user != "admin" || pass != "secret" {
+		if ok {
+			role, ok = a.authenticate(user, pass)
+		}
+
+		if !ok {
 			w.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
 
+		r, c := withCaller(r)
+		c.user, c.role = user, role
+
+		r = r.WithContext(db.WithUser(r.Context(), user))
+
 		next.ServeHTTP(w, r)
 	})
 }
 
+// queryTags allows both ?tag=a&tag=b and ?tag=a,b
+func queryTags(r *http.Request) []string {
+	var tags []string
+
+	for _, t := range r.URL.Query()["tag"] {
+		tags = append(tags, strings.Split(t, ",")...)
+	}
+
+	return tags
+}
+
+// healthTimeout bounds the health check, since a probe
+// that hangs is no better than one that fails
+const healthTimeout = 2 * time.Second
+
+// health is read-only by default so routine probes stay
+// cheap; ?deep=true also checks that we can write
+func (a *app) health(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), healthTimeout)
+	defer cancel()
+
+	check := a.db.Ping
+
+	if r.FormValue("deep") == "true" {
+		check = a.db.CheckWrite
+	}
+
+	if err := check(ctx); err != nil {
+		log.Printf("health check: %s", err)
+		http.Error(w, "unhealthy", http.StatusServiceUnavailable)
+		return
+	}
+
+	fmt.Fprintln(w, "ok")
+}
+
+// ready fails while we're draining, so the load
+// balancer stops routing new requests to us
+func (a *app) ready(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&a.draining) != 0 {
+		http.Error(w, "draining", http.StatusServiceUnavailable)
+		return
+	}
+
+	fmt.Fprintln(w, "ok")
+}
+
 func (a *app) list(w http.ResponseWriter, r *http.Request) {
-	items, err := a.db.ListItems(r.Context())
+	var items []*model.Item
+
+	fields, stored, err := a.queryFields(r)
 
 	if err != nil {
-		if errors.Is(err, db.ErrNotFound) {
-			http.Error(w, err.Error(), http.StatusNotFound)
+		writeError(w, http.StatusBadRequest, apiError{"invalid_field", err.Error(), "fields"})
+		return
+	}
+
+	pg, paged, err := parsePage(r)
+
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, "invalid_page", err.Error())
+		return
+	}
+
+	// the next cursor is a SKU, so we must read it
+
+	if paged && pg.cursor && stored != nil && !hasField(stored, "sku") {
+		stored = append(stored, "sku")
+		sort.Strings(stored)
+	}
+
+	tags := queryTags(r)
+
+	if len(tags) > 0 {
+		if len(tags) > db.MaxTags {
+			http.Error(w, fmt.Sprintf("Too many tags (max %d)", db.MaxTags), http.StatusBadRequest)
+			return
+		}
+
+		items, err = a.dbFor(r).ListItemsByTags(r.Context(), tags)
+	} else {
+		// the whole collection has an ETag, so a client
+		// can skip it if nothing has been written since
+
+		var seq int
+
+		if seq, err = a.dbFor(r).LastSeq(r.Context()); err != nil {
+			dbError(w, err)
+			return
+		}
+
+		// it's weak since it comes from the change number,
+		// not the bytes; each API version, projection, and
+		// list with or without secrets is a different
+		// representation
+
+		tag := fmt.Sprintf("items-%d-v%d", seq, apiVersion(r.Context()))
+
+		if db.CanSee(r.Context()) {
+			tag += "-secrets"
+		}
+
+		if fields != nil {
+			tag += "-" + strings.Join(fields, "+")
+		}
+
+		etag := weakETag(tag)
+
+		w.Header().Set("ETag", etag)
+
+		if noneMatch(r, etag) {
+			w.WriteHeader(http.StatusNotModified)
 			return
 		}
 
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		// a cursor page is a query of its own, for the
+		// page and one more item to say if there's a next
+
+		if paged && pg.cursor {
+			pg.read = true
+			items, err = a.dbFor(r).ListItemPage(r.Context(), pg.after, pg.limit+1, stored)
+		} else if stored != nil {
+			items, err = a.dbFor(r).ListItemsFields(r.Context(), stored)
+		} else {
+			items, err = a.dbFor(r).ListItems(r.Context())
+		}
+	}
+
+	// there's no "not found" for a list: an empty one is
+	// 200 [] (never null), even for a filter that matched
+	// nothing, and any error is the DB's
+
+	if err != nil {
+		dbError(w, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
+	if items == nil {
+		items = []*model.Item{}
+	}
 
-	if err = json.NewEncoder(w).Encode(items); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintln(w, err)
+	// Firestore can't count for us, so a range is cut
+	// from the whole list, which we've read anyway
+
+	// a page from the query string is what the client
+	// asked for, so Range is only for the whole list
+
+	w.Header().Set("Accept-Ranges", rangeUnit)
+
+	if paged {
+		items = a.sendPage(w, r, pg, items)
+		w.Header().Set("Content-Type", "application/json")
+	} else if rg, ok := parseRange(r); ok && (len(tags) == 0 || len(items) > 0) {
+		// a range of nothing is 416, but a filter that
+		// matched nothing is still just []
+
+		if items, ok = sendRange(w, rg, items); !ok {
+			return
+		}
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+
+	var body interface{} = items
+
+	if fields != nil {
+		body = a.shape(items, fields)
+	}
+
+	// the status is sent by now, so all we can do is log
+
+	if err = json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("ERROR list: %s", err)
 	}
 }
 
@@ -72,12 +342,7 @@ func (a *app) listSKU(w http.ResponseWriter, r *http.Request) {
 	items, err := a.db.ListSKUs(r.Context())
 
 	if err != nil {
-		if errors.Is(err, db.ErrNotFound) {
-			http.Error(w, err.Error(), http.StatusNotFound)
-			return
-		}
-
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		dbError(w, err)
 		return
 	}
 
@@ -89,128 +354,886 @@ func (a *app) listSKU(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (a *app) location(url *url.URL, host, id string) string {
-	return fmt.Sprintf("http://%s%s/%s", host, url.String(), id)
-}
-
-func (a *app) add(w http.ResponseWriter, r *http.Request) {
-	var item model.Item
-
-	err := json.NewDecoder(r.Body).Decode(&item)
+// reserve sets aside a block of SKUs for items that don't
+// exist yet; the range is inclusive
+func (a *app) reserve(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Count int `json:"count"`
+	}
 
-	if err != nil || item.Name == "" {
-		http.Error(w, "Invalid input", http.StatusBadRequest)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, http.StatusBadRequest, "invalid_input", err.Error())
 		return
 	}
 
-	if item.ID != "" {
-		http.Error(w, "Key assigned", http.StatusConflict)
+	if req.Count < 1 || req.Count > db.MaxSKUBlock {
+		msg := fmt.Sprintf("count must be between 1 and %d", db.MaxSKUBlock)
+		writeError(w, http.StatusBadRequest, apiError{"invalid_count", msg, "count"})
 		return
 	}
 
-	id, err := a.db.AddItem(r.Context(), &item)
+	start, err := a.db.ReserveSKUBlock(r.Context(), req.Count)
 
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		dbError(w, err)
+		return
 	}
 
+	block := struct {
+		Start int `json:"start"`
+		End   int `json:"end"`
+	}{start, start + req.Count - 1}
+
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Location", a.location(r.URL, r.Host, id))
 	w.WriteHeader(http.StatusCreated)
 
-	// we're not going to return an error if the encoding
-	// fails, because we've already returned Location
+	_ = json.NewEncoder(w).Encode(block)
+}
 
-	_ = json.NewEncoder(w).Encode(item)
+// skuStats says how fast SKUs are going and how many are
+// left; it's the main DB's counter, not that of any other
+// resource (see -resources)
+func (a *app) skuStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := a.db.SKUStats(r.Context())
+
+	if err != nil {
+		dbError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	_ = json.NewEncoder(w).Encode(stats)
 }
 
-func (a *app) get(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id := vars["id"]
+// reindex recomputes the sort key for item names, e.g.
+// after changing -collation, in every resource's DB
+func (a *app) reindex(w http.ResponseWriter, r *http.Request) {
+	n := 0
 
-	item, err := a.db.GetItem(r.Context(), id)
+	for _, d := range a.stores() {
+		updated, err := d.Reindex(r.Context())
 
-	if err != nil {
-		if errors.Is(err, db.ErrNotFound) {
-			http.Error(w, err.Error(), http.StatusNotFound)
+		if err != nil {
+			dbError(w, err)
+			return
+		}
+
+		n += updated
+	}
+
+	result := struct {
+		Updated int `json:"updated"`
+	}{n}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	_ = json.NewEncoder(w).Encode(result)
+}
+
+// changes is for sync clients: it returns what's been
+// written or deleted after change number ?since=N, and
+// the number to use next time; it only covers the main
+// DB, since other resources have change numbers of their own
+func (a *app) changes(w http.ResponseWriter, r *http.Request) {
+	since := 0
+
+	if s := r.URL.Query().Get("since"); s != "" {
+		n, err := strconv.Atoi(s)
+
+		if err != nil || n < 0 {
+			jsonError(w, http.StatusBadRequest, "invalid_input", "since must be a change number")
 			return
 		}
 
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		since = n
+	}
+
+	changes, err := a.db.ListChanges(r.Context(), since)
+
+	if err != nil {
+		dbError(w, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 
-	if err = json.NewEncoder(w).Encode(item); err != nil {
+	if err = json.NewEncoder(w).Encode(changes); err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprintln(w, err)
 	}
 }
 
-func (a *app) getSKU(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	s := vars["sku"]
-
-	sku, err := strconv.Atoi(s)
+// location is the absolute URL for path on this server,
+// as the client sees it; behind a proxy that terminates
+// TLS, that's in X-Forwarded-Proto and X-Forwarded-Host,
+// which we only believe with -trust-proxy
+func (a *app) location(r *http.Request, path string) string {
+	scheme, host := "http", r.Host
 
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+	if r.TLS != nil {
+		scheme = "https"
 	}
 
-	item, err := a.db.GetItemBySKU(r.Context(), sku)
+	if a.trustProxy {
+		if p := r.Header.Get("X-Forwarded-Proto"); p == "http" || p == "https" {
+			scheme = p
+		}
 
-	if err != nil {
-		if errors.Is(err, db.ErrNotFound) {
-			http.Error(w, err.Error(), http.StatusNotFound)
-			return
+		if h := r.Header.Get("X-Forwarded-Host"); h != "" {
+			host = strings.TrimSpace(strings.Split(h, ",")[0])
 		}
+	}
+
+	return fmt.Sprintf("%s://%s%s", scheme, host, path)
+}
 
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+// itemPath is the path of a new item under the collection
+// we were called on; the query string (if any) doesn't
+// belong in the item's URL
+func itemPath(r *http.Request, id string) string {
+	return strings.TrimSuffix(r.URL.Path, "/") + "/" + id
+}
+
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Field   string `json:"field,omitempty"`
+}
+
+// jsonError is http.Error for clients that parse the body:
+//
+//	{"error":{"code":"id_not_allowed","message":"server assigns item IDs"}}
+func jsonError(w http.ResponseWriter, status int, code, msg string) {
+	writeError(w, status, apiError{Code: code, Message: msg})
+}
+
+func writeError(w http.ResponseWriter, status int, e apiError) {
+	body := struct {
+		Error apiError `json:"error"`
+	}{e}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// skuNotAllowed rejects a new item that came with a SKU
+func skuNotAllowed(w http.ResponseWriter, field string) {
+	writeError(w, http.StatusBadRequest, apiError{"sku_not_allowed", "sku is server-assigned", field})
+}
+
+// invalidItem reports a validation error as 422 (the JSON
+// was fine, but the item wasn't) and anything else as 400
+func invalidItem(w http.ResponseWriter, err error) {
+	var ve *model.ValidationError
+
+	if !errors.As(err, &ve) {
+		jsonError(w, http.StatusBadRequest, "invalid_input", err.Error())
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
+	writeError(w, http.StatusUnprocessableEntity, apiError{ve.Code, ve.Message, ve.Field})
+}
 
-	if err = json.NewEncoder(w).Encode(item); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintln(w, err)
+// badBody rejects a request body we couldn't decode,
+// telling a missing body (Decode says io.EOF, as it does
+// for just whitespace) apart from one that isn't JSON
+func badBody(w http.ResponseWriter, err error) {
+	var syntax *json.SyntaxError
+
+	switch {
+	case err == io.EOF:
+		jsonError(w, http.StatusBadRequest, "invalid_input", "request body is required")
+
+	case errors.As(err, &syntax), err == io.ErrUnexpectedEOF:
+		jsonError(w, http.StatusBadRequest, "invalid_input", "malformed JSON: "+err.Error())
+
+	default:
+		jsonError(w, http.StatusBadRequest, "invalid_input", err.Error())
 	}
 }
 
-func (a *app) put(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id := vars["id"]
+// readItem accepts either a JSON body or a plain
+// HTML form post (which can only set the name)
+func readItem(r *http.Request, item *model.Item) error {
+	ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	if ct == "application/x-www-form-urlencoded" {
+		item.Name = r.FormValue("name")
+		return nil
+	}
+
+	return decodeOne(r.Body, item)
+}
+
+var errTrailingData = errors.New("request body has data after the JSON value")
+
+// decodeOne reads a body that must be a single JSON value;
+// anything after it but white space is an error, rather
+// than quietly ignored (e.g. a second object a client sent
+// by mistake)
+func decodeOne(body io.Reader, v interface{}) error {
+	dec := json.NewDecoder(body)
+
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+
+	if err := dec.Decode(&struct{}{}); err != io.EOF {
+		return errTrailingData
+	}
+
+	return nil
+}
+
+// wantsHTML is true for browsers, which should get
+// a redirect after a form post rather than JSON
+func wantsHTML(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// prefers checks the Prefer header (RFC 7240) for
+// a preference such as "return=minimal"
+func prefers(r *http.Request, pref string) bool {
+	for _, h := range r.Header["Prefer"] {
+		for _, p := range strings.Split(h, ",") {
+			if strings.EqualFold(strings.TrimSpace(p), pref) {
+				return true
+			}
+		}
+	}
 
+	return false
+}
+
+// add creates an item; the server assigns the ID and SKU,
+// so clients must leave them out
+func (a *app) add(w http.ResponseWriter, r *http.Request) {
 	var item model.Item
 
-	err := json.NewDecoder(r.Body).Decode(&item)
+	err := readItem(r, &item)
 
-	if err != nil || item.Name == "" {
-		http.Error(w, "Invalid input", http.StatusBadRequest)
+	if err != nil {
+		badBody(w, err)
 		return
 	}
 
-	item.ID = id // in case it was left out of the object data
+	if item.ID != "" {
+		jsonError(w, http.StatusConflict, "id_not_allowed", "server assigns item IDs")
+		return
+	}
 
-	if err = a.db.UpdateItem(r.Context(), &item); err != nil {
-		if errors.Is(err, db.ErrNotFound) {
-			http.Error(w, err.Error(), http.StatusNotFound)
-			return
-		}
+	// we'd overwrite it anyway, but the client should know
+	// their SKU wasn't used
+
+	if item.Sku != 0 {
+		skuNotAllowed(w, "sku")
+		return
+	}
+
+	if err = item.Validate(); err != nil {
+		invalidItem(w, err)
+		return
+	}
+
+	// AddItem fills in the ID and SKU of the item we pass;
+	// if it fails, they may be set but aren't valid
+
+	id, err := a.dbFor(r).AddItem(r.Context(), &item)
+
+	if err != nil {
+		dbError(w, err)
+		return
+	}
+
+	a.notify(&item)
+
+	loc := a.location(r, itemPath(r, id))
 
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	// post/redirect/get: the browser will follow with a
+	// GET, so a reload doesn't post the form again
+
+	if wantsHTML(r) {
+		http.Redirect(w, r, loc, http.StatusSeeOther)
+		return
+	}
+
+	// some clients look for Content-Location instead
+
+	w.Header().Set("Location", loc)
+	w.Header().Set("Content-Location", loc)
+	w.Header().Set("X-Item-SKU", strconv.Itoa(item.Sku))
+
+	if prefers(r, "return=minimal") {
+		w.Header().Set("Preference-Applied", "return=minimal")
+		w.WriteHeader(http.StatusCreated)
 		return
 	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+
+	// we're not going to return an error if the encoding
+	// fails, because we've already returned Location
+
+	_ = json.NewEncoder(w).Encode(item)
 }
 
-func (a *app) drop(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id := vars["id"]
+type upsertResult struct {
+	Created int `json:"created"`
+	Updated int `json:"updated"`
+}
+
+// upsert creates or updates a batch of items matched by
+// their external key (rather than our ID)
+func (a *app) upsert(w http.ResponseWriter, r *http.Request) {
+	var items []*model.Item
+
+	if err := json.NewDecoder(r.Body).Decode(&items); err != nil {
+		jsonError(w, http.StatusBadRequest, "invalid_input", err.Error())
+		return
+	}
+
+	if !a.checkBulk(w, len(items)) {
+		return
+	}
+
+	if r.URL.Query().Get("validateOnly") == "true" {
+		validateOnly(w, items, true)
+		return
+	}
+
+	if !checkBatch(w, items, true) {
+		return
+	}
+
+	created, updated, err := a.dbFor(r).Upsert(r.Context(), items)
+
+	if err != nil {
+		dbError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err = json.NewEncoder(w).Encode(upsertResult{created, updated}); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, err)
+	}
+}
+
+// checkBulk rejects a batch of n items that's over
+// -max-bulk, before any of it is written; with no limit set
+// it's the most SKUs we can reserve at once, which bulk
+// needs for the whole batch
+func (a *app) checkBulk(w http.ResponseWriter, n int) bool {
+	limit := a.maxBulk
+
+	if limit == 0 {
+		limit = db.MaxSKUBlock
+	}
+
+	if n <= limit {
+		return true
+	}
+
+	jsonError(w, http.StatusRequestEntityTooLarge, "too_many_items", fmt.Sprintf("at most %d items at a time, got %d", limit, n))
+
+	return false
+}
+
+// checkBatch validates a batch of new or upserted items,
+// writing the error for the first bad one; keyed items
+// must have an external key
+func checkBatch(w http.ResponseWriter, items []*model.Item, keyed bool) bool {
+	for n, i := range items {
+		if i == nil {
+			jsonError(w, http.StatusBadRequest, "invalid_input", fmt.Sprintf("item %d is null", n))
+			return false
+		}
+
+		if i.Sku != 0 {
+			skuNotAllowed(w, fmt.Sprintf("[%d].sku", n))
+			return false
+		}
+
+		err := i.Validate()
+
+		if err == nil && keyed && i.ExternalKey == "" {
+			err = &model.ValidationError{Field: "externalKey", Code: "key_required", Message: "externalKey is required"}
+		}
+
+		if err != nil {
+			invalidItem(w, itemAt(n, err))
+			return false
+		}
+	}
+
+	return true
+}
+
+// batchReport is what's wrong with one item of a batch
+type batchReport struct {
+	Index  int        `json:"index"`
+	Errors []apiError `json:"errors"`
+}
+
+// validateOnly checks a whole batch without writing any of
+// it, for ?validateOnly=true; unlike checkBatch it doesn't
+// stop at the first bad item, but reports every one, e.g.
+//
+//	[{"index":2,"errors":[{"code":"name_required",...}]}]
+//
+// with 422 if there are any, or an empty list if not
+func validateOnly(w http.ResponseWriter, items []*model.Item, keyed bool) {
+	report := []batchReport{}
+
+	for n, i := range items {
+		if errs := itemErrors(i, keyed); len(errs) > 0 {
+			report = append(report, batchReport{n, errs})
+		}
+	}
+
+	status := http.StatusOK
+
+	if len(report) > 0 {
+		status = http.StatusUnprocessableEntity
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	_ = json.NewEncoder(w).Encode(report)
+}
+
+// itemErrors is everything checkBatch (and bulk) would
+// reject an item for; Validate stops at the first problem,
+// so the name and tags are checked on their own
+func itemErrors(i *model.Item, keyed bool) []apiError {
+	if i == nil {
+		return []apiError{{"invalid_input", "item is null", ""}}
+	}
+
+	var result []apiError
+
+	if i.Sku != 0 {
+		result = append(result, apiError{"sku_not_allowed", "sku is server-assigned", "sku"})
+	}
+
+	if !keyed && i.ID != "" {
+		result = append(result, apiError{"id_not_allowed", "server assigns item IDs", "id"})
+	}
+
+	if keyed && i.ExternalKey == "" {
+		result = append(result, apiError{"key_required", "externalKey is required", "externalKey"})
+	}
+
+	for _, err := range []error{model.ValidateName(i.Name), i.CleanTags()} {
+		var ve *model.ValidationError
+
+		switch {
+		case errors.As(err, &ve):
+			result = append(result, apiError{ve.Code, ve.Message, ve.Field})
+		case err != nil:
+			result = append(result, apiError{"invalid_input", err.Error(), ""})
+		}
+	}
+
+	return result
+}
+
+// itemAt says which item of a batch an error is about,
+// e.g. [2].name
+func itemAt(n int, err error) error {
+	var ve *model.ValidationError
+
+	if errors.As(err, &ve) {
+		ve.Field = fmt.Sprintf("[%d].%s", n, ve.Field)
+	}
+
+	return err
+}
+
+// bulk adds a batch of new items with consecutive SKUs;
+// if it fails part way, the error says how many items
+// were written (they're the first ones in the batch)
+func (a *app) bulk(w http.ResponseWriter, r *http.Request) {
+	var items []*model.Item
+
+	if err := json.NewDecoder(r.Body).Decode(&items); err != nil {
+		jsonError(w, http.StatusBadRequest, "invalid_input", err.Error())
+		return
+	}
+
+	if !a.checkBulk(w, len(items)) {
+		return
+	}
+
+	if r.URL.Query().Get("validateOnly") == "true" {
+		validateOnly(w, items, false)
+		return
+	}
+
+	if !checkBatch(w, items, false) {
+		return
+	}
+
+	for n, i := range items {
+		if i.ID != "" {
+			jsonError(w, http.StatusConflict, "id_not_allowed", fmt.Sprintf("item %d: server assigns item IDs", n))
+			return
+		}
+	}
+
+	n, err := a.dbFor(r).AddItems(r.Context(), items)
+
+	for _, i := range items[:n] {
+		a.notify(i)
+	}
+
+	if err != nil {
+		if n == 0 {
+			dbError(w, err)
+			return
+		}
+
+		log.Printf("ERROR bulk add: %d of %d written: %s", n, len(items), err)
+		jsonError(w, http.StatusInternalServerError, "partial_write", fmt.Sprintf("only the first %d of %d items were added", n, len(items)))
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+
+	_ = json.NewEncoder(w).Encode(items)
+}
+
+// importItems adds a batch of new items that keep the SKUs
+// they came with, e.g. from the old system, rather than
+// being given new ones; a SKU that's in use fails the lot
+// with 409, and the counter is moved past the highest SKU
+func (a *app) importItems(w http.ResponseWriter, r *http.Request) {
+	var items []*model.Item
+
+	if err := json.NewDecoder(r.Body).Decode(&items); err != nil {
+		jsonError(w, http.StatusBadRequest, "invalid_input", err.Error())
+		return
+	}
+
+	if !a.checkBulk(w, len(items)) {
+		return
+	}
+
+	seen := make(map[int]bool, len(items))
+
+	for n, i := range items {
+		if i == nil {
+			jsonError(w, http.StatusBadRequest, "invalid_input", fmt.Sprintf("item %d is null", n))
+			return
+		}
+
+		if i.ID != "" {
+			jsonError(w, http.StatusConflict, "id_not_allowed", fmt.Sprintf("item %d: server assigns item IDs", n))
+			return
+		}
+
+		field := fmt.Sprintf("[%d].sku", n)
+
+		if i.Sku < 1 || i.Sku > db.MaxSKU {
+			writeError(w, http.StatusUnprocessableEntity, apiError{"sku_required", fmt.Sprintf("sku must be 1 to %d", db.MaxSKU), field})
+			return
+		}
+
+		if seen[i.Sku] {
+			writeError(w, http.StatusConflict, apiError{"duplicate_sku", fmt.Sprintf("SKU %d is in the batch twice", i.Sku), field})
+			return
+		}
+
+		seen[i.Sku] = true
+
+		if err := i.Validate(); err != nil {
+			invalidItem(w, itemAt(n, err))
+			return
+		}
+	}
+
+	n, err := a.dbFor(r).ImportItems(r.Context(), items)
+
+	for _, i := range items[:n] {
+		a.notify(i)
+	}
+
+	if err != nil {
+		if n == 0 {
+			dbError(w, err)
+			return
+		}
+
+		log.Printf("ERROR import: %d of %d written: %s", n, len(items), err)
+		jsonError(w, http.StatusInternalServerError, "partial_write", fmt.Sprintf("only the first %d of %d items were imported", n, len(items)))
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+
+	_ = json.NewEncoder(w).Encode(items)
+}
+
+func (a *app) get(w http.ResponseWriter, r *http.Request) {
+	id, ok := a.pathID(w, r)
+
+	if !ok {
+		return
+	}
+
+	item, err := a.dbFor(r).GetItem(r.Context(), id)
+
+	if err != nil {
+		dbError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err = json.NewEncoder(w).Encode(item); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, err)
+	}
+}
+
+// lookup reads several items by ID, e.g. {"ids":["a","b"]};
+// an item that's missing or can't be read is listed in
+// errors (and counted in X-Item-Errors) rather than
+// failing the rest
+func (a *app) lookup(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		IDs []string `json:"ids"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		badBody(w, err)
+		return
+	}
+
+	if len(req.IDs) > db.MaxGetItems {
+		jsonError(w, http.StatusBadRequest, "too_many_items", fmt.Sprintf("at most %d items at a time", db.MaxGetItems))
+		return
+	}
+
+	for n, id := range req.IDs {
+		if err := a.checkID(id); err != nil {
+			writeError(w, http.StatusBadRequest, apiError{"invalid_id", fmt.Sprintf("id %d: %s", n, err), "ids"})
+			return
+		}
+	}
+
+	batch, err := a.dbFor(r).GetItems(r.Context(), req.IDs)
+
+	if err != nil {
+		dbError(w, err)
+		return
+	}
+
+	if len(batch.Errors) > 0 {
+		w.Header().Set("X-Item-Errors", strconv.Itoa(len(batch.Errors)))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	_ = json.NewEncoder(w).Encode(batch)
+}
+
+func (a *app) getSKU(w http.ResponseWriter, r *http.Request) {
+	sku, ok := pathSKU(w, r)
+
+	if !ok {
+		return
+	}
+
+	// ?any=true takes one of several items with the SKU,
+	// which should never happen but can after a bad import
+
+	ctx := r.Context()
+
+	if r.URL.Query().Get("any") == "true" {
+		ctx = db.WithAnyMatch(ctx)
+	}
+
+	item, err := a.db.GetItemBySKU(ctx, sku)
+
+	if err != nil {
+		dbError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err = json.NewEncoder(w).Encode(item); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, err)
+	}
+}
+
+func (a *app) put(w http.ResponseWriter, r *http.Request) {
+	id, ok := a.pathID(w, r)
+
+	if !ok {
+		return
+	}
+
+	var item model.Item
+
+	err := decodeOne(r.Body, &item)
+
+	if err != nil {
+		badBody(w, err)
+		return
+	}
+
+	if err = item.Validate(); err != nil {
+		invalidItem(w, err)
+		return
+	}
+
+	item.ID = id // in case it was left out of the object data
+
+	// If-None-Match: * means "only if it doesn't exist", so
+	// the client is choosing the ID for a new item
+
+	if r.Header.Get("If-None-Match") == "*" {
+		a.createWithID(w, r, &item)
+		return
+	}
+
+	if err = a.dbFor(r).UpdateItem(r.Context(), &item); err != nil {
+		dbError(w, err)
+		return
+	}
+
+	// by default there's no body; if asked, read the item
+	// back so the client sees what the server stored
+
+	if !prefers(r, "return=representation") {
+		return
+	}
+
+	// a shared read may have started before our write, so
+	// this one has to be our own
+
+	stored, err := a.dbFor(r).GetItem(db.WithFresh(r.Context()), id)
+
+	if err != nil {
+		dbError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Preference-Applied", "return=representation")
+
+	_ = json.NewEncoder(w).Encode(stored)
+}
+
+func (a *app) createWithID(w http.ResponseWriter, r *http.Request, item *model.Item) {
+	if item.Sku != 0 {
+		skuNotAllowed(w, "sku")
+		return
+	}
+
+	// with -id-scheme sku, an item-<sku> ID chosen by the
+	// client would get some other SKU, and AddItem would
+	// fail for good once the counter reached this one
+
+	if a.idScheme == db.IDSKU && skuIDPattern.MatchString(item.ID) {
+		writeError(w, http.StatusBadRequest, apiError{"invalid_id", "invalid id: item-<sku> ids are server-assigned", "id"})
+		return
+	}
+
+	if err := a.dbFor(r).CreateWithID(r.Context(), item.ID, item); err != nil {
+		dbError(w, err)
+		return
+	}
+
+	a.notify(item)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", a.location(r, r.URL.Path))
+	w.Header().Set("X-Item-SKU", strconv.Itoa(item.Sku))
+	w.WriteHeader(http.StatusCreated)
+
+	_ = json.NewEncoder(w).Encode(item)
+}
+
+// duplicate clones an item under a new ID and SKU; the
+// body may override the name, but nothing else is taken
+// from it, and the external key isn't copied since it
+// has to stay unique
+func (a *app) duplicate(w http.ResponseWriter, r *http.Request) {
+	id, ok := a.pathID(w, r)
+
+	if !ok {
+		return
+	}
+
+	var opts struct {
+		Name string `json:"name"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&opts); err != nil && err != io.EOF {
+		jsonError(w, http.StatusBadRequest, "invalid_input", err.Error())
+		return
+	}
+
+	src, err := a.dbFor(r).GetItem(r.Context(), id)
+
+	if err != nil {
+		dbError(w, err)
+		return
+	}
+
+	item := model.Item{
+		Name: opts.Name,
+		Tags: append([]string(nil), src.Tags...),
+	}
+
+	if item.Name == "" {
+		item.Name = "Copy of " + src.Name
+	}
+
+	if err = item.Validate(); err != nil {
+		invalidItem(w, err)
+		return
+	}
+
+	if _, err = a.dbFor(r).AddItem(r.Context(), &item); err != nil {
+		dbError(w, err)
+		return
+	}
+
+	a.notify(&item)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", a.location(r, resourcePath(r)+"/"+item.ID))
+	w.Header().Set("X-Item-SKU", strconv.Itoa(item.Sku))
+	w.WriteHeader(http.StatusCreated)
+
+	_ = json.NewEncoder(w).Encode(item)
+}
+
+func (a *app) drop(w http.ResponseWriter, r *http.Request) {
+	id, ok := a.pathID(w, r)
+
+	if !ok {
+		return
+	}
 
-	if err := a.db.DeleteItem(r.Context(), id); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := a.dbFor(r).DeleteItem(r.Context(), id); err != nil {
+		dbError(w, err)
 		return
 	}
 }
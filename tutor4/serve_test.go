@@ -1,25 +1,46 @@
 package tutor4
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/gorilla/mux"
+
+	"tutor4/db"
+	"tutor4/graph/model"
 )
 
+var emulator = flag.String("emulator", os.Getenv("FIRESTORE_EMULATOR_HOST"), "FS emulator host:port")
+
 // TestWithApp **MUST** have a Firestore emulator
 // running and also uses the network; it may be
 // fragile if the port is already in use
 //
 // but it's a full door-to-door test of the app
+//
+// pass the emulator with `go test -args -emulator host:port`
+// (or set FIRESTORE_EMULATOR_HOST); it's skipped otherwise
 func TestWithApp(t *testing.T) {
-	args := []string{"-addr", "localhost:8089"}
+	if *emulator == "" {
+		t.Skip("no Firestore emulator")
+	}
+
+	args := []string{"-addr", "localhost:8089", "-emulator", *emulator}
 
 	go RunApp(args)
 
@@ -75,7 +96,7 @@ func TestWithMocks(t *testing.T) {
 		t.Errorf("invalid response: %d", resp.StatusCode)
 	}
 
-	var result []Item
+	var result []model.Item
 
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
 		t.Fatal(err)
@@ -88,7 +109,7 @@ func TestWithMocks(t *testing.T) {
 	fmt.Println(result)
 
 	for i := range result {
-		if result[i].SKU < 1000 || result[i].SKU > 1009 {
+		if result[i].Sku < 1000 || result[i].Sku > 1009 {
 			t.Errorf("invalid SKU: %#v", result[i])
 		}
 	}
@@ -119,7 +140,7 @@ func TestWithMockServer(t *testing.T) {
 		t.Errorf("invalid response: %d", resp.StatusCode)
 	}
 
-	var result []Item
+	var result []model.Item
 
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
 		t.Fatal(err)
@@ -179,3 +200,2333 @@ func TestNotFoundWithMocks(t *testing.T) {
 		t.Errorf("invalid response: %d", resp.StatusCode)
 	}
 }
+
+// TestAmbiguousSKUWithMocks gets a SKU two items have,
+// which is a conflict unless the client takes either
+func TestAmbiguousSKUWithMocks(t *testing.T) {
+	d := new(mockDB)
+	a := app{router: mux.NewRouter(), db: d, noAuth: true}
+
+	d.preload()
+	a.addRoutes()
+
+	for _, i := range d.data {
+		if i.Sku == 1001 {
+			i.Sku = 1000
+		}
+	}
+
+	for url, status := range map[string]int{
+		"http://who-cares/skus/1000":          http.StatusConflict,
+		"http://who-cares/skus/1000?any=true": http.StatusOK,
+		"http://who-cares/skus/1002":          http.StatusOK,
+	} {
+		r := httptest.NewRequest("GET", url, nil)
+		w := httptest.NewRecorder()
+
+		a.router.ServeHTTP(w, r)
+
+		if resp := w.Result(); resp.StatusCode != status {
+			t.Errorf("%s: wanted %d, got %d", url, status, resp.StatusCode)
+		}
+	}
+}
+
+// TestAddJSONWithMocks posts JSON and expects the item back
+func TestAddJSONWithMocks(t *testing.T) {
+	d := new(mockDB)
+	a := app{
+		router: mux.NewRouter(),
+		db:     d,
+		noAuth: true,
+	}
+
+	d.preload()
+	a.addRoutes()
+
+	r := httptest.NewRequest("POST", "http://who-cares/items", strings.NewReader(`{"name":"widget"}`))
+	w := httptest.NewRecorder()
+
+	r.Header.Set("Content-Type", "application/json")
+	a.router.ServeHTTP(w, r)
+
+	resp := w.Result()
+
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("invalid response: %d", resp.StatusCode)
+	}
+
+	var result model.Item
+
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Name != "widget" || resp.Header.Get("Location") == "" {
+		t.Errorf("invalid result: %#v", result)
+	}
+
+	// the server assigned both the ID and the SKU
+
+	if item, ok := d.data[result.ID]; !ok || item.Sku != result.Sku || result.Sku != d.next-1 {
+		t.Errorf("invalid SKU: %#v", result)
+	}
+
+	if sku := resp.Header.Get("X-Item-SKU"); sku != strconv.Itoa(result.Sku) {
+		t.Errorf("invalid SKU header: %s", sku)
+	}
+}
+
+// TestAddFailWithMocks must not return an item at all
+func TestAddFailWithMocks(t *testing.T) {
+	d := &mockDB{fail: true}
+	a := app{
+		router: mux.NewRouter(),
+		db:     d,
+		noAuth: true,
+	}
+
+	a.addRoutes()
+
+	r := httptest.NewRequest("POST", "http://who-cares/items", strings.NewReader(`{"name":"widget"}`))
+	w := httptest.NewRecorder()
+
+	a.router.ServeHTTP(w, r)
+
+	resp := w.Result()
+
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("invalid response: %d", resp.StatusCode)
+	}
+
+	if resp.Header.Get("Location") != "" || resp.Header.Get("X-Item-SKU") != "" {
+		t.Errorf("invalid headers: %v", resp.Header)
+	}
+}
+
+// TestAddFormWithMocks posts a form as a browser would
+// and expects to be redirected to the new item
+func TestAddFormWithMocks(t *testing.T) {
+	d := new(mockDB)
+	a := app{
+		router: mux.NewRouter(),
+		db:     d,
+		noAuth: true,
+	}
+
+	d.preload()
+	a.addRoutes()
+
+	r := httptest.NewRequest("POST", "http://who-cares/items", strings.NewReader("name=widget"))
+	w := httptest.NewRecorder()
+
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("Accept", "text/html")
+	a.router.ServeHTTP(w, r)
+
+	resp := w.Result()
+
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("invalid response: %d", resp.StatusCode)
+	}
+
+	loc := resp.Header.Get("Location")
+	id := loc[strings.LastIndex(loc, "/")+1:]
+
+	if item, ok := d.data[id]; !ok || item.Name != "widget" {
+		t.Errorf("invalid location: %s", loc)
+	}
+}
+
+// TestForceHTTPS simulates the proxy's forwarded header
+func TestForceHTTPS(t *testing.T) {
+	d := new(mockDB)
+	a := app{
+		router: mux.NewRouter(),
+		db:     d,
+		noAuth: true,
+		https:  true,
+	}
+
+	d.preload()
+	a.addRoutes()
+
+	table := []struct {
+		method, url, proto string
+		status             int
+		location           string
+	}{
+		{"GET", "http://who-cares/items?x=1", "http", http.StatusMovedPermanently, "https://who-cares/items?x=1"},
+		{"GET", "http://who-cares/items", "https", http.StatusOK, ""},
+		{"POST", "http://who-cares/items", "http", http.StatusBadRequest, ""},
+		{"GET", "http://who-cares/healthz", "http", http.StatusOK, ""},
+	}
+
+	for _, tt := range table {
+		r := httptest.NewRequest(tt.method, tt.url, nil)
+		w := httptest.NewRecorder()
+
+		r.Header.Set("X-Forwarded-Proto", tt.proto)
+		a.router.ServeHTTP(w, r)
+
+		resp := w.Result()
+
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s %s (%s): invalid response: %d", tt.method, tt.url, tt.proto, resp.StatusCode)
+		}
+
+		if loc := resp.Header.Get("Location"); loc != tt.location {
+			t.Errorf("%s %s (%s): invalid location: %s", tt.method, tt.url, tt.proto, loc)
+		}
+	}
+}
+
+// TestCreateWithIDWithMocks uses a client-chosen ID once,
+// and then fails trying to create it again
+func TestCreateWithIDWithMocks(t *testing.T) {
+	d := new(mockDB)
+	a := app{
+		router: mux.NewRouter(),
+		db:     d,
+		noAuth: true,
+	}
+
+	d.preload()
+	a.addRoutes()
+
+	for _, status := range []int{http.StatusCreated, http.StatusConflict} {
+		r := httptest.NewRequest("PUT", "http://who-cares/items/my-id", strings.NewReader(`{"name":"widget"}`))
+		w := httptest.NewRecorder()
+
+		r.Header.Set("If-None-Match", "*")
+		a.router.ServeHTTP(w, r)
+
+		resp := w.Result()
+
+		if resp.StatusCode != status {
+			t.Errorf("invalid response: %d", resp.StatusCode)
+		}
+	}
+
+	if item, ok := d.data["my-id"]; !ok || item.Name != "widget" || item.Sku != 1009 {
+		t.Errorf("invalid item: %#v", item)
+	}
+}
+
+// TestSchemaWithMocks checks the server-assigned fields
+func TestSchemaWithMocks(t *testing.T) {
+	a := app{
+		router: mux.NewRouter(),
+		db:     new(mockDB),
+		noAuth: true,
+	}
+
+	a.addRoutes()
+
+	r := httptest.NewRequest("GET", "http://who-cares/schema", nil)
+	w := httptest.NewRecorder()
+
+	a.router.ServeHTTP(w, r)
+
+	resp := w.Result()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("invalid response: %d", resp.StatusCode)
+	}
+
+	var result schema
+
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+
+	readOnly := map[string]bool{"id": true, "name": false, "sku": true, "tags": false, "externalKey": false, "seq": true, "supplierCost": false}
+
+	if result.Name != "Item" || len(result.Fields) != len(readOnly) {
+		t.Fatalf("invalid schema: %#v", result)
+	}
+
+	for _, f := range result.Fields {
+		if ro, ok := readOnly[f.Name]; !ok || ro != f.ReadOnly {
+			t.Errorf("invalid field: %#v", f)
+		}
+	}
+}
+
+// TestGraphQLBatchWithMocks asks for several items by SKU
+// and expects them all to come from one backend call
+func TestGraphQLBatchWithMocks(t *testing.T) {
+	d := new(mockDB)
+	a := app{
+		router: mux.NewRouter(),
+		db:     d,
+		noAuth: true,
+	}
+
+	d.preload()
+	a.addRoutes()
+
+	query := `{"query":"{a: item(sku: 1000) {name} b: item(sku: 1001) {name} c: item(sku: 1002) {name}}"}`
+	r := httptest.NewRequest("POST", "http://who-cares/graphql", strings.NewReader(query))
+	w := httptest.NewRecorder()
+
+	r.Header.Set("Content-Type", "application/json")
+	a.router.ServeHTTP(w, r)
+
+	resp := w.Result()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("invalid response: %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Data map[string]model.Item `json:"data"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Data) != 3 || result.Data["b"].Name != "item-2" {
+		t.Errorf("invalid result: %#v", result)
+	}
+
+	if d.batches != 1 {
+		t.Errorf("invalid batch count: %d", d.batches)
+	}
+}
+
+// TestGraphQLAmbiguousWithMocks batches a SKU two items
+// have with one that's fine; only the first fails
+func TestGraphQLAmbiguousWithMocks(t *testing.T) {
+	d := new(mockDB)
+	a := app{
+		router: mux.NewRouter(),
+		db:     d,
+		noAuth: true,
+	}
+
+	d.preload()
+	a.addRoutes()
+
+	for _, i := range d.data {
+		if i.Sku == 1001 {
+			i.Sku = 1000
+		}
+	}
+
+	query := `{"query":"{a: item(sku: 1000) {name} c: item(sku: 1002) {name}}"}`
+	r := httptest.NewRequest("POST", "http://who-cares/graphql", strings.NewReader(query))
+	w := httptest.NewRecorder()
+
+	r.Header.Set("Content-Type", "application/json")
+	a.router.ServeHTTP(w, r)
+
+	var result struct {
+		Data   map[string]*model.Item `json:"data"`
+		Errors []struct {
+			Message string   `json:"message"`
+			Path    []string `json:"path"`
+		} `json:"errors"`
+	}
+
+	if err := json.NewDecoder(w.Result().Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Data["a"] != nil || result.Data["c"] == nil || result.Data["c"].Name != "item-3" {
+		t.Errorf("invalid data: %#v", result.Data)
+	}
+
+	if len(result.Errors) != 1 || result.Errors[0].Path[0] != "a" || !strings.Contains(result.Errors[0].Message, "ambiguous") {
+		t.Errorf("invalid errors: %#v", result.Errors)
+	}
+
+	if d.batches != 1 {
+		t.Errorf("invalid batch count: %d", d.batches)
+	}
+}
+
+// TestCreateParityWithMocks creates an item through each
+// API and checks REST sends every field GraphQL does, as
+// the same JSON type; GraphQL's fields come from the
+// schema, so a new one is checked too
+func TestCreateParityWithMocks(t *testing.T) {
+	d := new(mockDB)
+	a := app{router: mux.NewRouter(), db: d, noAuth: true}
+
+	d.preload()
+	a.addRoutes()
+
+	post := func(url, body string) map[string]interface{} {
+		r := httptest.NewRequest("POST", url, strings.NewReader(body))
+		w := httptest.NewRecorder()
+
+		r.Header.Set("Content-Type", "application/json")
+		a.router.ServeHTTP(w, r)
+
+		var result map[string]interface{}
+
+		if err := json.NewDecoder(w.Result().Body).Decode(&result); err != nil {
+			t.Fatalf("%s: %s", url, err)
+		}
+
+		return result
+	}
+
+	schema := post("http://who-cares/graphql", `{"query":"{__type(name: \"Item\") {fields {name}}}"}`)
+
+	var fields []string
+
+	for _, f := range schema["data"].(map[string]interface{})["__type"].(map[string]interface{})["fields"].([]interface{}) {
+		fields = append(fields, f.(map[string]interface{})["name"].(string))
+	}
+
+	query := fmt.Sprintf(`{"query":"mutation {createItem(input: {name: \"new\"}) {%s}}"}`, strings.Join(fields, " "))
+	gql := post("http://who-cares/graphql", query)["data"].(map[string]interface{})["createItem"].(map[string]interface{})
+	rest := post("http://who-cares/items", `{"name":"new"}`)
+
+	if len(fields) == 0 || len(gql) != len(fields) {
+		t.Fatalf("invalid GraphQL item: %v", gql)
+	}
+
+	for k, v := range gql {
+		if r, ok := rest[k]; !ok {
+			t.Errorf("%s: missing from REST", k)
+		} else if fmt.Sprintf("%T", r) != fmt.Sprintf("%T", v) {
+			t.Errorf("%s: REST sends %T, GraphQL %T", k, r, v)
+		}
+	}
+
+	if rest["name"] != gql["name"] || fmt.Sprint(rest["tags"]) != "[]" || fmt.Sprint(gql["tags"]) != "[]" {
+		t.Errorf("items differ: %v, %v", rest, gql)
+	}
+}
+
+// TestCORSWithMocks covers the wildcard and credentialed
+// modes and the preflight cache header
+func TestCORSWithMocks(t *testing.T) {
+	table := []struct {
+		args         []string
+		method       string
+		origin       string
+		allow, creds string
+		maxAge       string
+		status       int
+	}{
+		{[]string{"-cors-origins", "*"}, "GET", "http://a.com", "*", "", "", http.StatusOK},
+		{[]string{"-cors-origins", "http://a.com", "-cors-credentials"}, "GET", "http://a.com", "http://a.com", "true", "", http.StatusOK},
+		{[]string{"-cors-origins", "http://a.com", "-cors-credentials"}, "GET", "http://b.com", "", "", "", http.StatusOK},
+		{[]string{"-cors-origins", "*", "-cors-max-age", "10m"}, "OPTIONS", "http://a.com", "*", "", "600", http.StatusNoContent},
+	}
+
+	for _, tt := range table {
+		a := app{
+			router: mux.NewRouter(),
+			db:     new(mockDB),
+		}
+
+		if err := a.fromArgs(append(tt.args, "-no-auth")); err != nil {
+			t.Fatal(err)
+		}
+
+		a.addRoutes()
+
+		r := httptest.NewRequest(tt.method, "http://who-cares/items", nil)
+		w := httptest.NewRecorder()
+
+		r.Header.Set("Origin", tt.origin)
+		r.Header.Set("Access-Control-Request-Method", "POST")
+		a.router.ServeHTTP(w, r)
+
+		resp := w.Result()
+		h := resp.Header
+
+		if resp.StatusCode != tt.status {
+			t.Errorf("%v: invalid response: %d", tt.args, resp.StatusCode)
+		}
+
+		if h.Get("Access-Control-Allow-Origin") != tt.allow ||
+			h.Get("Access-Control-Allow-Credentials") != tt.creds ||
+			h.Get("Access-Control-Max-Age") != tt.maxAge {
+			t.Errorf("%v: invalid headers: %v", tt.args, h)
+		}
+	}
+
+	var a app
+
+	if err := a.fromArgs([]string{"-cors-origins", "*", "-cors-credentials"}); err == nil {
+		t.Errorf("credentials allowed with wildcard")
+	}
+}
+
+// TestLimitWithMocks sends more requests than we allow in
+// flight and expects the extra ones to be shed
+func TestLimitWithMocks(t *testing.T) {
+	d := &mockDB{delay: 3 * limitWait}
+	a := app{
+		router:        mux.NewRouter(),
+		db:            d,
+		noAuth:        true,
+		maxConcurrent: 2,
+	}
+
+	d.preload()
+	a.addRoutes()
+
+	var wg sync.WaitGroup
+
+	codes := make(chan int, 6)
+
+	for i := 0; i < cap(codes); i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			r := httptest.NewRequest("GET", "http://who-cares/items", nil)
+			w := httptest.NewRecorder()
+
+			a.router.ServeHTTP(w, r)
+			codes <- w.Result().StatusCode
+		}()
+	}
+
+	wg.Wait()
+	close(codes)
+
+	count := make(map[int]int)
+
+	for c := range codes {
+		count[c]++
+	}
+
+	if count[http.StatusOK] != 2 || count[http.StatusServiceUnavailable] != 4 {
+		t.Errorf("invalid responses: %v", count)
+	}
+}
+
+// TestLogCLFWithMocks checks the access log fields
+func TestLogCLFWithMocks(t *testing.T) {
+	var buf bytes.Buffer
+
+	clfLog.SetOutput(&buf)
+	defer clfLog.SetOutput(os.Stderr)
+
+	d := new(mockDB)
+	a := app{
+		router: mux.NewRouter(),
+		db:     d,
+		logFmt: "clf",
+	}
+
+	d.preload()
+	a.addRoutes()
+
+	r := httptest.NewRequest("GET", "http://who-cares/items/nope", nil)
+	w := httptest.NewRecorder()
+
+	r.SetBasicAuth("admin", "secret")
+	a.router.ServeHTTP(w, r)
+
+	re := regexp.MustCompile(`^192\.0\.2\.1 - admin \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [-+]\d{4}\] "GET /items/nope HTTP/1\.1" 404 \d+\n$`)
+
+	if line := buf.String(); !re.MatchString(line) {
+		t.Errorf("invalid log: %q", line)
+	}
+}
+
+// TestLogSampleWithMocks logs only failures with sampling
+// at 0 and every request with it at 1
+func TestLogSampleWithMocks(t *testing.T) {
+	var buf bytes.Buffer
+
+	clfLog.SetOutput(&buf)
+	defer clfLog.SetOutput(os.Stderr)
+
+	table := []struct {
+		drop float64
+		want []string
+	}{
+		{1, []string{" 500 "}},
+		{0, []string{" 200 ", " 200 ", " 500 "}},
+	}
+
+	for _, st := range table {
+		buf.Reset()
+
+		d := new(mockDB)
+		a := app{
+			router:  mux.NewRouter(),
+			db:      d,
+			noAuth:  true,
+			logFmt:  "clf",
+			logDrop: st.drop,
+		}
+
+		d.preload()
+		a.addRoutes()
+
+		for _, fail := range []bool{false, false, true} {
+			d.fail = fail
+
+			r := httptest.NewRequest("GET", "http://who-cares/items", nil)
+			w := httptest.NewRecorder()
+
+			a.router.ServeHTTP(w, r)
+		}
+
+		lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+
+		if len(lines) != len(st.want) {
+			t.Errorf("drop %g: wanted %d lines, got %q", st.drop, len(st.want), buf.String())
+			continue
+		}
+
+		for n, l := range lines {
+			if !strings.Contains(l, st.want[n]) {
+				t.Errorf("drop %g: invalid log: %q", st.drop, l)
+			}
+		}
+	}
+}
+
+// TestTagsWithMocks filters by one tag and then by several
+func TestTagsWithMocks(t *testing.T) {
+	d := new(mockDB)
+	a := app{
+		router: mux.NewRouter(),
+		db:     d,
+		noAuth: true,
+	}
+
+	d.preload()
+	a.addRoutes()
+
+	for _, body := range []string{
+		`{"name":"tv","tags":["electronics","video","video"]}`,
+		`{"name":"radio","tags":["electronics","audio"]}`,
+		`{"name":"sofa","tags":["furniture"]}`,
+	} {
+		r := httptest.NewRequest("POST", "http://who-cares/items", strings.NewReader(body))
+		w := httptest.NewRecorder()
+
+		a.router.ServeHTTP(w, r)
+
+		if resp := w.Result(); resp.StatusCode != http.StatusCreated {
+			t.Fatalf("invalid response: %d", resp.StatusCode)
+		}
+	}
+
+	table := []struct {
+		query string
+		names []string
+	}{
+		{"tag=electronics", []string{"radio", "tv"}},
+		{"tag=video", []string{"tv"}},
+		{"tag=audio&tag=furniture", []string{"radio", "sofa"}},
+		{"tag=video,furniture", []string{"sofa", "tv"}},
+	}
+
+	for _, tt := range table {
+		r := httptest.NewRequest("GET", "http://who-cares/items?"+tt.query, nil)
+		w := httptest.NewRecorder()
+
+		a.router.ServeHTTP(w, r)
+
+		var result []model.Item
+
+		if err := json.NewDecoder(w.Result().Body).Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+
+		names := make([]string, 0, len(result))
+
+		for _, i := range result {
+			names = append(names, i.Name)
+		}
+
+		sort.Strings(names)
+
+		if strings.Join(names, ",") != strings.Join(tt.names, ",") {
+			t.Errorf("%s: invalid result: %v", tt.query, names)
+		}
+	}
+
+	for _, i := range d.data {
+		if i.Name == "tv" && len(i.Tags) != 2 {
+			t.Errorf("tags not deduplicated: %v", i.Tags)
+		}
+	}
+
+	r := httptest.NewRequest("POST", "http://who-cares/items", strings.NewReader(`{"name":"x","tags":[""]}`))
+	w := httptest.NewRecorder()
+
+	a.router.ServeHTTP(w, r)
+
+	if resp := w.Result(); resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("empty tag allowed: %d", resp.StatusCode)
+	}
+}
+
+// TestPreferWithMocks creates items with both preferences
+func TestPreferWithMocks(t *testing.T) {
+	d := new(mockDB)
+	a := app{
+		router: mux.NewRouter(),
+		db:     d,
+		noAuth: true,
+	}
+
+	d.preload()
+	a.addRoutes()
+
+	table := []struct {
+		prefer  string
+		applied string
+		body    bool
+	}{
+		{"return=minimal", "return=minimal", false},
+		{"return=representation", "", true},
+		{"", "", true},
+	}
+
+	for _, tt := range table {
+		r := httptest.NewRequest("POST", "http://who-cares/items", strings.NewReader(`{"name":"widget"}`))
+		w := httptest.NewRecorder()
+
+		if tt.prefer != "" {
+			r.Header.Set("Prefer", tt.prefer)
+		}
+
+		a.router.ServeHTTP(w, r)
+
+		resp := w.Result()
+		body, _ := ioutil.ReadAll(resp.Body)
+
+		if resp.StatusCode != http.StatusCreated || resp.Header.Get("Location") == "" {
+			t.Errorf("%q: invalid response: %d", tt.prefer, resp.StatusCode)
+		}
+
+		if applied := resp.Header.Get("Preference-Applied"); applied != tt.applied {
+			t.Errorf("%q: invalid preference applied: %q", tt.prefer, applied)
+		}
+
+		if (len(body) > 0) != tt.body {
+			t.Errorf("%q: invalid body: %q", tt.prefer, body)
+		}
+	}
+}
+
+// TestWebhookWithMocks creates items via REST and GraphQL
+// and expects the webhook to hear about both
+func TestWebhookWithMocks(t *testing.T) {
+	got := make(chan model.Item, 2)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var item model.Item
+
+		if err := json.NewDecoder(r.Body).Decode(&item); err != nil {
+			t.Error(err)
+		}
+
+		got <- item
+	}))
+
+	defer hook.Close()
+
+	d := new(mockDB)
+	a := app{
+		router:  mux.NewRouter(),
+		db:      d,
+		noAuth:  true,
+		webhook: hook.URL,
+	}
+
+	d.preload()
+	a.addRoutes()
+
+	for _, req := range []struct{ url, body string }{
+		{"http://who-cares/items", `{"name":"rest"}`},
+		{"http://who-cares/graphql", `{"query":"mutation {createItem(input: {name: \"gql\"}) {id}}"}`},
+	} {
+		r := httptest.NewRequest("POST", req.url, strings.NewReader(req.body))
+		w := httptest.NewRecorder()
+
+		r.Header.Set("Content-Type", "application/json")
+		a.router.ServeHTTP(w, r)
+	}
+
+	a.wg.Wait()
+	close(got)
+
+	names := make([]string, 0, 2)
+
+	for item := range got {
+		if item.ID == "" || item.Sku < 1009 {
+			t.Errorf("invalid item: %#v", item)
+		}
+
+		names = append(names, item.Name)
+	}
+
+	sort.Strings(names)
+
+	if strings.Join(names, ",") != "gql,rest" {
+		t.Errorf("invalid webhooks: %v", names)
+	}
+}
+
+// TestUpsertWithMocks pushes the same external keys twice
+func TestUpsertWithMocks(t *testing.T) {
+	d := new(mockDB)
+	a := app{
+		router: mux.NewRouter(),
+		db:     d,
+		noAuth: true,
+	}
+
+	d.preload()
+	a.addRoutes()
+
+	table := []struct {
+		body   string
+		status int
+		result upsertResult
+	}{
+		{`[{"name":"a","externalKey":"x1"},{"name":"b","externalKey":"x2"}]`, http.StatusOK, upsertResult{2, 0}},
+		{`[{"name":"a2","externalKey":"x1"},{"name":"c","externalKey":"x3"}]`, http.StatusOK, upsertResult{1, 1}},
+		{`[{"name":"d"}]`, http.StatusUnprocessableEntity, upsertResult{}},
+		{`[null]`, http.StatusBadRequest, upsertResult{}},
+	}
+
+	for _, tt := range table {
+		r := httptest.NewRequest("POST", "http://who-cares/items/upsert", strings.NewReader(tt.body))
+		w := httptest.NewRecorder()
+
+		a.router.ServeHTTP(w, r)
+
+		resp := w.Result()
+
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: invalid response: %d", tt.body, resp.StatusCode)
+			continue
+		}
+
+		if tt.status != http.StatusOK {
+			continue
+		}
+
+		var result upsertResult
+
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+
+		if result != tt.result {
+			t.Errorf("%s: invalid result: %#v", tt.body, result)
+		}
+	}
+
+	if len(d.data) != 12 {
+		t.Errorf("invalid item count: %d", len(d.data))
+	}
+
+	for _, i := range d.data {
+		if i.ExternalKey == "x1" && (i.Name != "a2" || i.Sku != 1009) {
+			t.Errorf("invalid update: %#v", i)
+		}
+	}
+}
+
+// TestBulkWithMocks adds a batch and checks the SKUs
+func TestBulkWithMocks(t *testing.T) {
+	d := new(mockDB)
+	a := app{
+		router: mux.NewRouter(),
+		db:     d,
+		noAuth: true,
+	}
+
+	d.preload()
+	a.addRoutes()
+
+	table := []struct {
+		body   string
+		status int
+		count  int
+	}{
+		{`[{"name":"a"},{"name":"b"},{"name":"c"}]`, http.StatusCreated, 3},
+		{`[{"name":"d"},{"name":""}]`, http.StatusUnprocessableEntity, 0},
+		{`[{"name":"d","sku":1}]`, http.StatusBadRequest, 0},
+		{`[{"name":"d","id":"mine"}]`, http.StatusConflict, 0},
+		{`{"name":"d"}`, http.StatusBadRequest, 0},
+	}
+
+	for _, tt := range table {
+		r := httptest.NewRequest("POST", "http://who-cares/items/bulk", strings.NewReader(tt.body))
+		w := httptest.NewRecorder()
+
+		a.router.ServeHTTP(w, r)
+
+		resp := w.Result()
+
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: invalid response: %d", tt.body, resp.StatusCode)
+			continue
+		}
+
+		if tt.status != http.StatusCreated {
+			continue
+		}
+
+		var items []*model.Item
+
+		if err := json.NewDecoder(resp.Body).Decode(&items); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(items) != tt.count {
+			t.Fatalf("%s: invalid count: %d", tt.body, len(items))
+		}
+
+		for n, i := range items {
+			if i.Sku != 1009+n || i.ID == "" {
+				t.Errorf("%s: invalid item: %#v", tt.body, i)
+			}
+		}
+	}
+
+	if len(d.data) != 12 {
+		t.Errorf("invalid item count: %d", len(d.data))
+	}
+}
+
+// TestValidateOnlyWithMocks checks a batch of good and bad
+// items without writing any, and gets the bad ones by index
+func TestValidateOnlyWithMocks(t *testing.T) {
+	d := new(mockDB)
+	a := app{
+		router: mux.NewRouter(),
+		db:     d,
+		noAuth: true,
+	}
+
+	d.preload()
+	a.addRoutes()
+
+	type report struct {
+		Index  int
+		Errors []apiError
+	}
+
+	table := []struct {
+		path   string
+		body   string
+		status int
+		want   []report
+	}{
+		{
+			"/items/bulk",
+			`[{"name":"a"},{"name":""},{"name":"c","sku":1,"tags":[""]},{"name":"d"}]`,
+			http.StatusUnprocessableEntity,
+			[]report{
+				{1, []apiError{{"name_required", "name is required", "name"}}},
+				{2, []apiError{{"sku_not_allowed", "sku is server-assigned", "sku"}, {"empty_tag", "tags can't be empty", "tags"}}},
+			},
+		},
+		{
+			"/items/upsert",
+			`[{"name":"a","externalKey":"a"},{"name":"b"}]`,
+			http.StatusUnprocessableEntity,
+			[]report{
+				{1, []apiError{{"key_required", "externalKey is required", "externalKey"}}},
+			},
+		},
+		{
+			"/items/bulk",
+			`[{"name":"a"},{"name":"b"}]`,
+			http.StatusOK,
+			[]report{},
+		},
+	}
+
+	for _, tt := range table {
+		r := httptest.NewRequest("POST", "http://who-cares"+tt.path+"?validateOnly=true", strings.NewReader(tt.body))
+		w := httptest.NewRecorder()
+
+		a.router.ServeHTTP(w, r)
+
+		if w.Code != tt.status {
+			t.Errorf("%s: invalid response: %d", tt.body, w.Code)
+			continue
+		}
+
+		var got []report
+
+		if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: invalid report: %+v", tt.body, got)
+		}
+	}
+
+	if len(d.data) != 9 {
+		t.Errorf("invalid item count: %d", len(d.data))
+	}
+}
+
+// TestMaxBulkWithMocks sends batches at and over -max-bulk
+// to each bulk endpoint
+func TestMaxBulkWithMocks(t *testing.T) {
+	d := new(mockDB)
+	a := app{router: mux.NewRouter(), db: d}
+
+	if err := a.fromArgs([]string{"-no-auth", "-max-bulk", "2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	d.preload()
+	a.addRoutes()
+
+	table := []struct {
+		path   string
+		body   string
+		status int
+	}{
+		{"/items/bulk", `[{"name":"a"},{"name":"b"},{"name":"c"}]`, http.StatusRequestEntityTooLarge},
+		{"/items/upsert", `[{"name":"a","externalKey":"a"},{"name":"b","externalKey":"b"},{"name":"c","externalKey":"c"}]`, http.StatusRequestEntityTooLarge},
+		{"/items/import", `[{"name":"a","sku":1},{"name":"b","sku":2},{"name":"c","sku":3}]`, http.StatusRequestEntityTooLarge},
+		{"/items/bulk", `[{"name":"a"},{"name":"b"}]`, http.StatusCreated},
+		{"/items/upsert", `[{"name":"a","externalKey":"a"},{"name":"b","externalKey":"b"}]`, http.StatusOK},
+		{"/items/import", `[{"name":"a","sku":1},{"name":"b","sku":2}]`, http.StatusCreated},
+	}
+
+	for _, tt := range table {
+		r := httptest.NewRequest("POST", "http://who-cares"+tt.path, strings.NewReader(tt.body))
+		w := httptest.NewRecorder()
+
+		a.router.ServeHTTP(w, r)
+
+		if w.Code != tt.status {
+			t.Errorf("%s %s: invalid response: %d", tt.path, tt.body, w.Code)
+			continue
+		}
+
+		if tt.status == http.StatusRequestEntityTooLarge && !strings.Contains(w.Body.String(), "at most 2 items at a time, got 3") {
+			t.Errorf("%s: invalid error: %s", tt.path, w.Body)
+		}
+	}
+
+	// nothing over the limit was written
+
+	if len(d.data) != 15 {
+		t.Errorf("invalid item count: %d", len(d.data))
+	}
+
+	for _, arg := range []string{"0", "10001"} {
+		b := app{router: mux.NewRouter(), db: new(mockDB)}
+
+		if err := b.fromArgs([]string{"-max-bulk", arg}); err == nil {
+			t.Errorf("-max-bulk %s accepted", arg)
+		}
+	}
+}
+
+// TestImportWithMocks imports items that keep their SKUs,
+// and rejects ones that are taken or missing
+func TestImportWithMocks(t *testing.T) {
+	d := new(mockDB)
+	a := app{
+		router: mux.NewRouter(),
+		db:     d,
+		noAuth: true,
+	}
+
+	d.preload()
+	a.addRoutes()
+
+	table := []struct {
+		body   string
+		status int
+	}{
+		{`[{"name":"a","sku":42},{"name":"b","sku":2000}]`, http.StatusCreated},
+		{`[{"name":"c","sku":1003}]`, http.StatusConflict},
+		{`[{"name":"c","sku":3000},{"name":"d","sku":3000}]`, http.StatusConflict},
+		{`[{"name":"c"}]`, http.StatusUnprocessableEntity},
+		{`[{"name":"","sku":3000}]`, http.StatusUnprocessableEntity},
+		{`[{"name":"c","sku":3000,"id":"mine"}]`, http.StatusConflict},
+	}
+
+	for _, tt := range table {
+		r := httptest.NewRequest("POST", "http://who-cares/items/import", strings.NewReader(tt.body))
+		w := httptest.NewRecorder()
+
+		a.router.ServeHTTP(w, r)
+
+		if w.Code != tt.status {
+			t.Errorf("%s: invalid response: %d", tt.body, w.Code)
+		}
+	}
+
+	if len(d.data) != 11 {
+		t.Errorf("invalid item count: %d", len(d.data))
+	}
+
+	for _, i := range d.data {
+		if i.Name == "a" && i.Sku != 42 || i.Name == "b" && i.Sku != 2000 {
+			t.Errorf("SKU not kept: %#v", i)
+		}
+	}
+
+	// the next SKU handed out is past the highest imported
+
+	r := httptest.NewRequest("POST", "http://who-cares/items", strings.NewReader(`{"name":"e"}`))
+	w := httptest.NewRecorder()
+
+	a.router.ServeHTTP(w, r)
+
+	var item model.Item
+
+	if err := json.NewDecoder(w.Body).Decode(&item); err != nil {
+		t.Fatal(err)
+	}
+
+	if item.Sku != 2001 {
+		t.Errorf("invalid SKU: %d", item.Sku)
+	}
+}
+
+// TestAddErrorsWithMocks checks the structured error bodies
+func TestAddErrorsWithMocks(t *testing.T) {
+	d := new(mockDB)
+	a := app{
+		router: mux.NewRouter(),
+		db:     d,
+		noAuth: true,
+	}
+
+	d.preload()
+	a.addRoutes()
+
+	table := []struct {
+		body   string
+		status int
+		code   string
+	}{
+		{`{"id":"mine","name":"widget"}`, http.StatusConflict, "id_not_allowed"},
+		{`{"name":"widget","sku":1234}`, http.StatusBadRequest, "sku_not_allowed"},
+		{`{"name":""}`, http.StatusUnprocessableEntity, "name_required"},
+		{`{"name":"` + strings.Repeat("x", model.MaxNameLen+1) + `"}`, http.StatusUnprocessableEntity, "name_too_long"},
+		{`{"name":"widget","tags":[""]}`, http.StatusUnprocessableEntity, "empty_tag"},
+		{`{"name":`, http.StatusBadRequest, "invalid_input"},
+	}
+
+	for _, tt := range table {
+		r := httptest.NewRequest("POST", "http://who-cares/items", strings.NewReader(tt.body))
+		w := httptest.NewRecorder()
+
+		a.router.ServeHTTP(w, r)
+
+		resp := w.Result()
+
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: invalid response: %d", tt.body, resp.StatusCode)
+		}
+
+		var result struct {
+			Error apiError `json:"error"`
+		}
+
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			t.Fatalf("%s: %s", tt.body, err)
+		}
+
+		if result.Error.Code != tt.code || result.Error.Message == "" {
+			t.Errorf("%s: invalid error: %#v", tt.body, result.Error)
+		}
+	}
+}
+
+// TestEmptyBodyWithMocks tells a missing body from a bad
+// one, or one with more after the item, on both add and put
+func TestEmptyBodyWithMocks(t *testing.T) {
+	d := new(mockDB)
+	a := app{router: mux.NewRouter(), db: d, noAuth: true}
+
+	d.preload()
+	a.addRoutes()
+
+	var id string
+
+	for k := range d.data {
+		id = k
+		break
+	}
+
+	table := []struct {
+		body    string
+		message string // how it starts
+	}{
+		{"", "request body is required"},
+		{" \n\t ", "request body is required"},
+		{`{"name":`, "malformed JSON"},
+		{`{"name" "x"}`, "malformed JSON"},
+		{`{"name":42}`, "json: cannot unmarshal"},
+		{`{"name":"x"}{"name":"y"}`, errTrailingData.Error()},
+		{`{"name":"x"} junk`, errTrailingData.Error()},
+	}
+
+	for _, req := range []struct{ method, url string }{
+		{"POST", "http://who-cares/items"},
+		{"PUT", "http://who-cares/items/" + id},
+	} {
+		for _, tt := range table {
+			r := httptest.NewRequest(req.method, req.url, strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+
+			a.router.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			var result struct {
+				Error apiError `json:"error"`
+			}
+
+			if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+				t.Fatalf("%s %q: %s", req.method, tt.body, err)
+			}
+
+			if resp.StatusCode != http.StatusBadRequest || !strings.HasPrefix(result.Error.Message, tt.message) {
+				t.Errorf("%s %q: invalid error: %d %#v", req.method, tt.body, resp.StatusCode, result.Error)
+			}
+		}
+	}
+
+	// white space after the object is fine
+
+	r := httptest.NewRequest("POST", "http://who-cares/items", strings.NewReader("{\"name\":\"x\"}\n\n"))
+	w := httptest.NewRecorder()
+
+	a.router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusCreated {
+		t.Errorf("trailing newline: invalid response: %d", w.Code)
+	}
+}
+
+// TestHealthWithMocks runs both checks against a good
+// DB and one that fails
+func TestHealthWithMocks(t *testing.T) {
+	for _, fail := range []bool{false, true} {
+		a := app{
+			router: mux.NewRouter(),
+			db:     &mockDB{fail: fail},
+		}
+
+		a.addRoutes()
+
+		status := http.StatusOK
+
+		if fail {
+			status = http.StatusServiceUnavailable
+		}
+
+		for _, url := range []string{"http://who-cares/healthz", "http://who-cares/healthz?deep=true"} {
+			r := httptest.NewRequest("GET", url, nil)
+			w := httptest.NewRecorder()
+
+			a.router.ServeHTTP(w, r)
+
+			if resp := w.Result(); resp.StatusCode != status {
+				t.Errorf("%s (fail %t): invalid response: %d", url, fail, resp.StatusCode)
+			}
+		}
+	}
+}
+
+// TestDuplicateWithMocks clones an item with and without
+// a new name, and one that doesn't exist
+func TestDuplicateWithMocks(t *testing.T) {
+	d := &mockDB{}
+	a := app{router: mux.NewRouter(), db: d, noAuth: true}
+
+	d.preload()
+	a.addRoutes()
+
+	src := &model.Item{Name: "widget", Tags: []string{"red"}, ExternalKey: "w-1"}
+
+	if _, err := d.AddItem(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+
+	table := []struct {
+		id, body, name string
+		status         int
+	}{
+		{src.ID, "", "Copy of widget", http.StatusCreated},
+		{src.ID, `{"name":"gadget"}`, "gadget", http.StatusCreated},
+		{src.ID, `{"name":`, "", http.StatusBadRequest},
+		{"missing", "", "", http.StatusNotFound},
+	}
+
+	for _, tt := range table {
+		r := httptest.NewRequest("POST", "http://who-cares/items/"+tt.id+"/duplicate", strings.NewReader(tt.body))
+		w := httptest.NewRecorder()
+
+		a.router.ServeHTTP(w, r)
+
+		resp := w.Result()
+
+		if resp.StatusCode != tt.status {
+			t.Errorf("%q: invalid response: %d", tt.body, resp.StatusCode)
+			continue
+		}
+
+		if tt.status != http.StatusCreated {
+			continue
+		}
+
+		var item model.Item
+
+		if err := json.NewDecoder(resp.Body).Decode(&item); err != nil {
+			t.Fatalf("%q: %s", tt.body, err)
+		}
+
+		if item.ID == src.ID || item.Sku == src.Sku || item.Name != tt.name {
+			t.Errorf("%q: invalid clone: %#v", tt.body, item)
+		}
+
+		if len(item.Tags) != 1 || item.Tags[0] != "red" || item.ExternalKey != "" {
+			t.Errorf("%q: invalid clone fields: %#v", tt.body, item)
+		}
+
+		if loc := resp.Header.Get("Location"); loc != "http://who-cares/items/"+item.ID {
+			t.Errorf("%q: invalid location: %s", tt.body, loc)
+		}
+	}
+}
+
+// TestGraphQLValidationWithMocks checks that GraphQL reports
+// the same error codes as REST
+func TestGraphQLValidationWithMocks(t *testing.T) {
+	a := app{router: mux.NewRouter(), db: &mockDB{}, noAuth: true}
+
+	a.addRoutes()
+
+	table := []struct {
+		name, tags, code string
+	}{
+		{"", "[]", "name_required"},
+		{strings.Repeat("x", model.MaxNameLen+1), "[]", "name_too_long"},
+		{"widget", `[\"\"]`, "empty_tag"},
+	}
+
+	for _, tt := range table {
+		query := fmt.Sprintf(`{"query":"mutation {createItem(input: {name: \"%s\", tags: %s}) {id}}"}`, tt.name, tt.tags)
+		r := httptest.NewRequest("POST", "http://who-cares/graphql", strings.NewReader(query))
+		w := httptest.NewRecorder()
+
+		r.Header.Set("Content-Type", "application/json")
+		a.router.ServeHTTP(w, r)
+
+		var result struct {
+			Errors []struct {
+				Message    string
+				Extensions map[string]interface{}
+			}
+		}
+
+		if err := json.NewDecoder(w.Result().Body).Decode(&result); err != nil {
+			t.Fatalf("%s: %s", tt.code, err)
+		}
+
+		if len(result.Errors) != 1 {
+			t.Fatalf("%s: wanted one error, got %#v", tt.code, result.Errors)
+		}
+
+		if e := result.Errors[0]; e.Extensions["code"] != tt.code || e.Message == "" {
+			t.Errorf("%s: invalid error: %#v", tt.code, e)
+		}
+	}
+}
+
+// TestGraphQLSkusWithMocks pages through the SKUs in order
+func TestGraphQLSkusWithMocks(t *testing.T) {
+	d := new(mockDB)
+	a := app{router: mux.NewRouter(), db: d, noAuth: true}
+
+	d.preload()
+	a.addRoutes()
+
+	table := []struct {
+		args string
+		skus []int
+	}{
+		{"first: 3", []int{1000, 1001, 1002}},
+		{`first: 4, after: \"1002\"`, []int{1003, 1004, 1005, 1006}},
+		{`after: \"1006\"`, []int{1007, 1008}},
+		{`after: \"1008\"`, []int{}},
+	}
+
+	for _, tt := range table {
+		query := fmt.Sprintf(`{"query":"{skus(%s) {sku id}}"}`, tt.args)
+		r := httptest.NewRequest("POST", "http://who-cares/graphql", strings.NewReader(query))
+		w := httptest.NewRecorder()
+
+		r.Header.Set("Content-Type", "application/json")
+		a.router.ServeHTTP(w, r)
+
+		var result struct {
+			Data struct {
+				Skus []model.SkuEntry `json:"skus"`
+			} `json:"data"`
+		}
+
+		if err := json.NewDecoder(w.Result().Body).Decode(&result); err != nil {
+			t.Fatalf("%s: %s", tt.args, err)
+		}
+
+		got := result.Data.Skus
+
+		if len(got) != len(tt.skus) {
+			t.Errorf("%s: invalid result: %#v", tt.args, got)
+			continue
+		}
+
+		for n, e := range got {
+			if e.Sku != tt.skus[n] || d.data[e.ID].Sku != e.Sku {
+				t.Errorf("%s: invalid entry %d: %#v", tt.args, n, e)
+			}
+		}
+	}
+}
+
+// TestPutPreferWithMocks checks PUT only returns the item
+// when asked to
+func TestPutPreferWithMocks(t *testing.T) {
+	d := new(mockDB)
+	a := app{router: mux.NewRouter(), db: d, noAuth: true}
+
+	d.preload()
+	a.addRoutes()
+
+	var id string
+
+	for k := range d.data {
+		id = k
+		break
+	}
+
+	for _, prefer := range []string{"", "return=representation"} {
+		r := httptest.NewRequest("PUT", "http://who-cares/items/"+id, strings.NewReader(`{"name":"renamed"}`))
+		w := httptest.NewRecorder()
+
+		if prefer != "" {
+			r.Header.Set("Prefer", prefer)
+		}
+
+		a.router.ServeHTTP(w, r)
+
+		resp := w.Result()
+		body, _ := ioutil.ReadAll(resp.Body)
+
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%q: invalid response: %d", prefer, resp.StatusCode)
+		}
+
+		if prefer == "" {
+			if len(body) > 0 {
+				t.Errorf("unexpected body: %q", body)
+			}
+
+			continue
+		}
+
+		var item model.Item
+
+		if err := json.Unmarshal(body, &item); err != nil {
+			t.Fatalf("%q: %s", prefer, err)
+		}
+
+		if item.ID != id || item.Name != "renamed" {
+			t.Errorf("invalid item: %#v", item)
+		}
+
+		if applied := resp.Header.Get("Preference-Applied"); applied != prefer {
+			t.Errorf("invalid preference applied: %q", applied)
+		}
+	}
+}
+
+// TestLocationWithMocks makes sure a query string on the
+// POST doesn't end up in the new item's URL
+func TestLocationWithMocks(t *testing.T) {
+	a := app{router: mux.NewRouter(), db: &mockDB{}, noAuth: true}
+
+	a.addRoutes()
+
+	r := httptest.NewRequest("POST", "http://who-cares/items?foo=bar", strings.NewReader(`{"name":"widget"}`))
+	w := httptest.NewRecorder()
+
+	a.router.ServeHTTP(w, r)
+
+	resp := w.Result()
+
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("invalid response: %d", resp.StatusCode)
+	}
+
+	loc := resp.Header.Get("Location")
+
+	if !regexp.MustCompile(`^http://who-cares/items/[-0-9a-f]+$`).MatchString(loc) {
+		t.Errorf("invalid location: %s", loc)
+	}
+
+	if cl := resp.Header.Get("Content-Location"); cl != loc {
+		t.Errorf("invalid content location: %s", cl)
+	}
+}
+
+// TestClientIP only believes proxy headers when told to
+func TestClientIP(t *testing.T) {
+	table := []struct {
+		trust   bool
+		headers map[string]string
+		ip      string
+	}{
+		{false, nil, "192.0.2.1"},
+		{false, map[string]string{"X-Forwarded-For": "203.0.113.7"}, "192.0.2.1"},
+		{true, nil, "192.0.2.1"},
+		{true, map[string]string{"X-Forwarded-For": "203.0.113.7, 10.0.0.1"}, "203.0.113.7"},
+		{true, map[string]string{"X-Forwarded-For": "2001:db8::1"}, "2001:db8::1"},
+		{true, map[string]string{"X-Real-IP": "203.0.113.8"}, "203.0.113.8"},
+		{true, map[string]string{"X-Forwarded-For": "bogus", "X-Real-IP": "203.0.113.8"}, "203.0.113.8"},
+		{true, map[string]string{"X-Forwarded-For": "bogus"}, "192.0.2.1"},
+	}
+
+	for _, tt := range table {
+		a := app{trustProxy: tt.trust}
+		r := httptest.NewRequest("GET", "http://who-cares/items", nil)
+
+		for k, v := range tt.headers {
+			r.Header.Set(k, v)
+		}
+
+		if ip := a.clientIP(r); ip != tt.ip {
+			t.Errorf("%t %v: wanted %s, got %s", tt.trust, tt.headers, tt.ip, ip)
+		}
+	}
+}
+
+// TestChangesWithMocks creates, updates and deletes items
+// and checks what a sync client would see after each
+func TestChangesWithMocks(t *testing.T) {
+	d := new(mockDB)
+	a := app{router: mux.NewRouter(), db: d, noAuth: true}
+
+	d.preload()
+	a.addRoutes()
+
+	changes := func(since int) db.Changes {
+		r := httptest.NewRequest("GET", fmt.Sprintf("http://who-cares/changes?since=%d", since), nil)
+		w := httptest.NewRecorder()
+
+		a.router.ServeHTTP(w, r)
+
+		var c db.Changes
+
+		if err := json.NewDecoder(w.Result().Body).Decode(&c); err != nil {
+			t.Fatal(err)
+		}
+
+		return c
+	}
+
+	do := func(method, url, body string) *http.Response {
+		r := httptest.NewRequest(method, "http://who-cares"+url, strings.NewReader(body))
+		w := httptest.NewRecorder()
+
+		a.router.ServeHTTP(w, r)
+		return w.Result()
+	}
+
+	// preloaded items have no change number yet
+
+	start := changes(0)
+
+	if len(start.Items) != 0 || len(start.Deleted) != 0 {
+		t.Fatalf("invalid start: %#v", start)
+	}
+
+	var item model.Item
+
+	if err := json.NewDecoder(do("POST", "/items", `{"name":"new"}`).Body).Decode(&item); err != nil {
+		t.Fatal(err)
+	}
+
+	created := changes(start.NextSeq)
+
+	if len(created.Items) != 1 || created.Items[0].ID != item.ID || created.NextSeq <= start.NextSeq {
+		t.Errorf("invalid create: %#v", created)
+	}
+
+	do("PUT", "/items/"+item.ID, `{"name":"newer"}`)
+
+	updated := changes(created.NextSeq)
+
+	if len(updated.Items) != 1 || updated.Items[0].Name != "newer" || updated.NextSeq <= created.NextSeq {
+		t.Errorf("invalid update: %#v", updated)
+	}
+
+	do("DELETE", "/items/"+item.ID, "")
+
+	deleted := changes(updated.NextSeq)
+
+	if len(deleted.Items) != 0 || len(deleted.Deleted) != 1 || deleted.Deleted[0] != item.ID {
+		t.Errorf("invalid delete: %#v", deleted)
+	}
+
+	// and a client starting from scratch sees only the
+	// tombstone, since the item is gone
+
+	if all := changes(0); len(all.Items) != 0 || len(all.Deleted) != 1 {
+		t.Errorf("invalid history: %#v", all)
+	}
+
+	if resp := do("GET", "/changes?since=x", ""); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("invalid since: %d", resp.StatusCode)
+	}
+}
+
+// TestListOrderWithMocks expects the item list in SKU order
+func TestListOrderWithMocks(t *testing.T) {
+	d := new(mockDB)
+	a := app{
+		router: mux.NewRouter(),
+		db:     d,
+		noAuth: true,
+	}
+
+	d.preload()
+	a.addRoutes()
+
+	r := httptest.NewRequest("GET", "http://who-cares/items", nil)
+	w := httptest.NewRecorder()
+
+	a.router.ServeHTTP(w, r)
+
+	resp := w.Result()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("invalid response: %d", resp.StatusCode)
+	}
+
+	var result []model.Item
+
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result) != len(d.data) {
+		t.Fatalf("invalid result: %#v", result)
+	}
+
+	ok := sort.SliceIsSorted(result, func(x, y int) bool {
+		return result[x].Sku < result[y].Sku
+	})
+
+	if !ok {
+		t.Errorf("not in SKU order: %#v", result)
+	}
+}
+
+// TestGraphQLFieldsWithMocks only asks for names, which
+// should be all we read
+func TestGraphQLFieldsWithMocks(t *testing.T) {
+	table := []struct {
+		query  string
+		fields []string
+	}{
+		{`{"query":"{items {name}}"}`, []string{"name"}},
+		{`{"query":"{items {__typename sku name}}"}`, []string{"sku", "name"}},
+	}
+
+	for _, st := range table {
+		d := new(mockDB)
+		a := app{
+			router: mux.NewRouter(),
+			db:     d,
+			noAuth: true,
+		}
+
+		d.preload()
+		a.addRoutes()
+
+		r := httptest.NewRequest("POST", "http://who-cares/graphql", strings.NewReader(st.query))
+		w := httptest.NewRecorder()
+
+		r.Header.Set("Content-Type", "application/json")
+		a.router.ServeHTTP(w, r)
+
+		resp := w.Result()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("invalid response: %d", resp.StatusCode)
+		}
+
+		if fmt.Sprint(d.fields) != fmt.Sprint(st.fields) {
+			t.Errorf("%s: wanted fields %v, got %v", st.query, st.fields, d.fields)
+		}
+	}
+}
+
+// TestTimeoutsWithMocks makes the DB slower than the REST
+// timeout but not the GraphQL one
+func TestTimeoutsWithMocks(t *testing.T) {
+	table := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"rest", "GET", "/items", "", http.StatusGatewayTimeout},
+		{"graphql", "POST", "/graphql", `{"query":"{items {name}}"}`, http.StatusOK},
+	}
+
+	for _, st := range table {
+		t.Run(st.name, func(t *testing.T) {
+			d := &mockDB{delay: 50 * time.Millisecond}
+			a := app{
+				router:      mux.NewRouter(),
+				db:          d,
+				noAuth:      true,
+				restTimeout: 10 * time.Millisecond,
+				gqlTimeout:  time.Second,
+			}
+
+			d.preload()
+			a.addRoutes()
+
+			r := httptest.NewRequest(st.method, "http://who-cares"+st.path, strings.NewReader(st.body))
+			w := httptest.NewRecorder()
+
+			r.Header.Set("Content-Type", "application/json")
+			a.router.ServeHTTP(w, r)
+
+			if w.Code != st.status {
+				t.Errorf("invalid response: %d", w.Code)
+			}
+		})
+	}
+}
+
+// TestTimeoutBodyWithMocks gets the JSON 504 from a slow
+// request, with the request ID it came with
+func TestTimeoutBodyWithMocks(t *testing.T) {
+	d := &mockDB{delay: 50 * time.Millisecond}
+	a := app{
+		router:      mux.NewRouter(),
+		db:          d,
+		noAuth:      true,
+		restTimeout: 10 * time.Millisecond,
+	}
+
+	d.preload()
+	a.addRoutes()
+
+	r := httptest.NewRequest("GET", "http://who-cares/items", nil)
+	w := httptest.NewRecorder()
+
+	r.Header.Set("X-Request-ID", "req-42")
+	a.router.ServeHTTP(w, r)
+
+	resp := w.Result()
+
+	if resp.StatusCode != http.StatusGatewayTimeout || resp.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("invalid response: %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	var body struct {
+		Error struct {
+			Code      string `json:"code"`
+			Message   string `json:"message"`
+			RequestID string `json:"requestId"`
+		} `json:"error"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+
+	if e := body.Error; e.Code != "timeout" || e.Message == "" || e.RequestID != "req-42" {
+		t.Errorf("invalid error: %#v", e)
+	}
+}
+
+// TestGraphQLOnlyWithMocks runs with -rest=false, so only
+// GraphQL (and the probes) should answer
+func TestGraphQLOnlyWithMocks(t *testing.T) {
+	d := new(mockDB)
+	a := app{router: mux.NewRouter(), db: d}
+
+	if err := a.fromArgs([]string{"-no-auth", "-rest=false"}); err != nil {
+		t.Fatal(err)
+	}
+
+	d.preload()
+	a.addRoutes()
+
+	table := []struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"GET", "/items", "", http.StatusNotFound},
+		{"GET", "/skus", "", http.StatusNotFound},
+		{"GET", "/healthz", "", http.StatusOK},
+		{"POST", "/graphql", `{"query":"{items {name}}"}`, http.StatusOK},
+	}
+
+	for _, st := range table {
+		r := httptest.NewRequest(st.method, "http://who-cares"+st.path, strings.NewReader(st.body))
+		w := httptest.NewRecorder()
+
+		r.Header.Set("Content-Type", "application/json")
+		a.router.ServeHTTP(w, r)
+
+		if w.Code != st.status {
+			t.Errorf("%s %s: wanted %d, got %d", st.method, st.path, st.status, w.Code)
+		}
+	}
+}
+
+// TestLocationProxyWithMocks sends forwarded headers with
+// and without -trust-proxy
+func TestLocationProxyWithMocks(t *testing.T) {
+	table := []struct {
+		name  string
+		trust bool
+		want  string
+	}{
+		{"trusted", true, `^https://shop.example.com/items/[-0-9a-f]+$`},
+		{"untrusted", false, `^http://who-cares/items/[-0-9a-f]+$`},
+	}
+
+	for _, st := range table {
+		t.Run(st.name, func(t *testing.T) {
+			a := app{router: mux.NewRouter(), db: &mockDB{}, noAuth: true, trustProxy: st.trust}
+
+			a.addRoutes()
+
+			r := httptest.NewRequest("POST", "http://who-cares/items", strings.NewReader(`{"name":"widget"}`))
+			w := httptest.NewRecorder()
+
+			r.Header.Set("X-Forwarded-Proto", "https")
+			r.Header.Set("X-Forwarded-Host", "shop.example.com")
+			a.router.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if resp.StatusCode != http.StatusCreated {
+				t.Fatalf("invalid response: %d", resp.StatusCode)
+			}
+
+			if loc := resp.Header.Get("Location"); !regexp.MustCompile(st.want).MatchString(loc) {
+				t.Errorf("invalid location: %s", loc)
+			}
+		})
+	}
+}
+
+// TestGraphQLMaxItemsWithMocks has more items than the
+// cap, which should cut the list short
+func TestGraphQLMaxItemsWithMocks(t *testing.T) {
+	d := new(mockDB)
+	a := app{
+		router:      mux.NewRouter(),
+		db:          d,
+		noAuth:      true,
+		gqlMaxItems: 5,
+	}
+
+	d.preload()
+	a.addRoutes()
+
+	query := `{"query":"{items {sku}}"}`
+	r := httptest.NewRequest("POST", "http://who-cares/graphql", strings.NewReader(query))
+	w := httptest.NewRecorder()
+
+	r.Header.Set("Content-Type", "application/json")
+	a.router.ServeHTTP(w, r)
+
+	resp := w.Result()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("invalid response: %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Data struct {
+			Items []model.Item `json:"items"`
+		} `json:"data"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(result.Data.Items); n != 5 {
+		t.Errorf("wanted 5 items, got %d", n)
+	}
+}
+
+// TestReserveWithMocks reserves two blocks, which must not
+// overlap, and tries some bad counts
+func TestReserveWithMocks(t *testing.T) {
+	d := new(mockDB)
+	a := app{router: mux.NewRouter(), db: d, noAuth: true}
+
+	d.preload()
+	a.addRoutes()
+
+	table := []struct {
+		body   string
+		status int
+		start  int
+		end    int
+	}{
+		{`{"count":10}`, http.StatusCreated, 1009, 1018},
+		{`{"count":1}`, http.StatusCreated, 1019, 1019},
+		{`{"count":0}`, http.StatusBadRequest, 0, 0},
+		{`{"count":100000}`, http.StatusBadRequest, 0, 0},
+		{`{"count":"ten"}`, http.StatusBadRequest, 0, 0},
+	}
+
+	for _, st := range table {
+		r := httptest.NewRequest("POST", "http://who-cares/skus/reserve", strings.NewReader(st.body))
+		w := httptest.NewRecorder()
+
+		a.router.ServeHTTP(w, r)
+
+		if w.Code != st.status {
+			t.Errorf("%s: invalid response: %d", st.body, w.Code)
+			continue
+		}
+
+		if w.Code != http.StatusCreated {
+			continue
+		}
+
+		var block struct {
+			Start, End int
+		}
+
+		if err := json.NewDecoder(w.Body).Decode(&block); err != nil {
+			t.Fatal(err)
+		}
+
+		if block.Start != st.start || block.End != st.end {
+			t.Errorf("%s: invalid block: %+v", st.body, block)
+		}
+	}
+
+	// the next item must come after the reserved blocks
+
+	r := httptest.NewRequest("POST", "http://who-cares/items", strings.NewReader(`{"name":"after"}`))
+	w := httptest.NewRecorder()
+
+	a.router.ServeHTTP(w, r)
+
+	if sku := w.Header().Get("X-Item-SKU"); sku != "1020" {
+		t.Errorf("invalid SKU after reserving: %s", sku)
+	}
+}
+
+// nilListDB is a backend whose empty lists are nil
+type nilListDB struct {
+	*mockDB
+}
+
+func (n nilListDB) ListItems(ctx context.Context) ([]*model.Item, error) {
+	items, err := n.mockDB.ListItems(ctx)
+
+	if len(items) == 0 {
+		items = nil
+	}
+
+	return items, err
+}
+
+// TestListEmptyWithMocks gets 200 [] for an empty list,
+// even from a backend that returns nil, and 500 if the
+// DB fails
+func TestListEmptyWithMocks(t *testing.T) {
+	table := []struct {
+		fail   bool
+		status int
+		body   string
+	}{
+		{false, http.StatusOK, "[]\n"},
+		{true, http.StatusInternalServerError, `{"error":{"code":"internal","message":"` + errShouldFail.Error() + `"}}` + "\n"},
+	}
+
+	for _, st := range table {
+		for _, d := range []db.DB{&mockDB{fail: st.fail}, nilListDB{&mockDB{fail: st.fail}}} {
+			a := app{router: mux.NewRouter(), db: d, noAuth: true}
+
+			a.addRoutes()
+
+			r := httptest.NewRequest("GET", "http://who-cares/items", nil)
+			w := httptest.NewRecorder()
+
+			a.router.ServeHTTP(w, r)
+
+			resp := w.Result()
+			body, _ := ioutil.ReadAll(resp.Body)
+
+			if resp.StatusCode != st.status || string(body) != st.body {
+				t.Errorf("%T fail=%t: got %d, %q", d, st.fail, resp.StatusCode, body)
+			}
+		}
+	}
+}
+
+// TestFilterMissWithMocks filters the list so nothing
+// matches, in each way a list can be asked for, and must
+// get 200 [] rather than a 404 (or a 416 for a range)
+func TestFilterMissWithMocks(t *testing.T) {
+	d := new(mockDB)
+	a := app{router: mux.NewRouter(), db: d, noAuth: true}
+
+	d.preload()
+	a.addRoutes()
+
+	table := []struct {
+		query string
+		rng   string
+	}{
+		{"?tag=nothing", ""},
+		{"?tag=nothing,nowhere", ""},
+		{"?tag=nothing&limit=5", ""},
+		{"?tag=nothing&limit=5&after=1000", ""},
+		{"?tag=nothing&fields=name", ""},
+		{"?tag=nothing", "items=0-4"},
+	}
+
+	for _, tt := range table {
+		r := httptest.NewRequest("GET", "http://who-cares/items"+tt.query, nil)
+		w := httptest.NewRecorder()
+
+		if tt.rng != "" {
+			r.Header.Set("Range", tt.rng)
+		}
+
+		a.router.ServeHTTP(w, r)
+
+		if w.Code != http.StatusOK || w.Body.String() != "[]\n" {
+			t.Errorf("%s %s: got %d, %q", tt.query, tt.rng, w.Code, w.Body)
+		}
+	}
+}
+
+// TestLookupWithMocks reads items by ID, with one that
+// doesn't decode and one that isn't there
+func TestLookupWithMocks(t *testing.T) {
+	d := &mockDB{
+		data: map[string]*model.Item{
+			"a":   {ID: "a", Name: "apple", Sku: 1000},
+			"b":   {ID: "b", Name: "banana", Sku: 1001},
+			"bad": {ID: "bad", Sku: 1002},
+		},
+		broken: map[string]bool{"bad": true},
+	}
+
+	a := app{router: mux.NewRouter(), db: d, noAuth: true}
+
+	a.addRoutes()
+
+	body := `{"ids":["a","bad","nope","b"]}`
+	r := httptest.NewRequest("POST", "http://who-cares/items/lookup", strings.NewReader(body))
+	w := httptest.NewRecorder()
+
+	a.router.ServeHTTP(w, r)
+
+	resp := w.Result()
+
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("X-Item-Errors") != "2" {
+		t.Fatalf("invalid response: %d %s", resp.StatusCode, resp.Header.Get("X-Item-Errors"))
+	}
+
+	var batch db.ItemBatch
+
+	if err := json.NewDecoder(resp.Body).Decode(&batch); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(batch.Items) != 2 || batch.Items[0].Name != "apple" || batch.Items[1].Name != "banana" {
+		t.Errorf("invalid items: %v", batch.Items)
+	}
+
+	if len(batch.Errors) != 2 || batch.Errors[0].ID != "bad" || batch.Errors[1].ID != "nope" {
+		t.Errorf("invalid errors: %v", batch.Errors)
+	}
+
+	// an ID we'd never accept fails the whole request
+
+	r = httptest.NewRequest("POST", "http://who-cares/items/lookup", strings.NewReader(`{"ids":["a","x/y"]}`))
+	w = httptest.NewRecorder()
+
+	a.router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("invalid ID: wanted 400, got %d", w.Code)
+	}
+}
+
+// TestListETagWithMocks lists, asks again with the ETag,
+// then changes an item so the ETag must change
+func TestListETagWithMocks(t *testing.T) {
+	d := new(mockDB)
+	a := app{router: mux.NewRouter(), db: d, noAuth: true}
+
+	d.preload()
+	a.addRoutes()
+
+	list := func(etag string) *http.Response {
+		r := httptest.NewRequest("GET", "http://who-cares/items", nil)
+		w := httptest.NewRecorder()
+
+		if etag != "" {
+			r.Header.Set("If-None-Match", etag)
+		}
+
+		a.router.ServeHTTP(w, r)
+
+		return w.Result()
+	}
+
+	resp := list("")
+	etag := resp.Header.Get("ETag")
+
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(etag, `W/"`) {
+		t.Fatalf("invalid response: %d %q", resp.StatusCode, etag)
+	}
+
+	if resp = list(etag); resp.StatusCode != http.StatusNotModified {
+		t.Errorf("unchanged: wanted 304, got %d", resp.StatusCode)
+	}
+
+	r := httptest.NewRequest("POST", "http://who-cares/items", strings.NewReader(`{"name":"new"}`))
+	w := httptest.NewRecorder()
+
+	a.router.ServeHTTP(w, r)
+
+	resp = list(etag)
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("changed: wanted 200, got %d", resp.StatusCode)
+	}
+
+	if next := resp.Header.Get("ETag"); next == etag {
+		t.Errorf("ETag didn't change: %s", next)
+	}
+
+	// an older version's body differs, so its ETag must
+	// too, and a cache must know to keep them apart
+
+	r = httptest.NewRequest("GET", "http://who-cares/items", nil)
+	w = httptest.NewRecorder()
+
+	r.Header.Set("X-API-Version", "1")
+	r.Header.Set("If-None-Match", list("").Header.Get("ETag"))
+	a.router.ServeHTTP(w, r)
+
+	if resp = w.Result(); resp.StatusCode != http.StatusOK {
+		t.Errorf("version 1: wanted 200, got %d", resp.StatusCode)
+	}
+
+	if vary := resp.Header.Get("Vary"); vary != "X-API-Version" {
+		t.Errorf("version 1: invalid Vary: %q", vary)
+	}
+}
+
+// TestSKUInputWithMocks tries to pick a SKU through each
+// way of creating an item
+func TestSKUInputWithMocks(t *testing.T) {
+	d := new(mockDB)
+	a := app{router: mux.NewRouter(), db: d, noAuth: true}
+
+	d.preload()
+	a.addRoutes()
+
+	table := []struct {
+		method, path, body string
+		ifNoneMatch        string
+	}{
+		{"POST", "/items", `{"name":"a","sku":1}`, ""},
+		{"POST", "/items/upsert", `[{"name":"a","externalKey":"k","sku":1}]`, ""},
+		{"PUT", "/items/mine", `{"name":"a","sku":1}`, "*"},
+	}
+
+	for _, st := range table {
+		r := httptest.NewRequest(st.method, "http://who-cares"+st.path, strings.NewReader(st.body))
+		w := httptest.NewRecorder()
+
+		if st.ifNoneMatch != "" {
+			r.Header.Set("If-None-Match", st.ifNoneMatch)
+		}
+
+		a.router.ServeHTTP(w, r)
+
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "sku_not_allowed") {
+			t.Errorf("%s %s: invalid response: %d %s", st.method, st.path, w.Code, w.Body)
+		}
+	}
+
+	if len(d.data) != 9 {
+		t.Errorf("items were added: %d", len(d.data))
+	}
+
+	// GraphQL's input type has no sku, so it's rejected
+	// before it gets to us
+
+	query := `{"query":"mutation {createItem(input: {name: \"a\", sku: 1}) {id}}"}`
+	r := httptest.NewRequest("POST", "http://who-cares/graphql", strings.NewReader(query))
+	w := httptest.NewRecorder()
+
+	r.Header.Set("Content-Type", "application/json")
+	a.router.ServeHTTP(w, r)
+
+	if body := w.Body.String(); !strings.Contains(body, `"errors"`) || !strings.Contains(body, "sku") {
+		t.Errorf("graphql: invalid response: %s", body)
+	}
+}
+
+// TestSKUStringWithMocks reads a SKU as a string and as a
+// number, and writes it back both ways; the string comes
+// first, so an app without -sku-string shows it's not
+// left set for everyone
+func TestSKUStringWithMocks(t *testing.T) {
+	table := []struct {
+		args []string
+		sku  interface{}
+	}{
+		{[]string{"-sku-string"}, "1000"},
+		{nil, 1000.0},
+	}
+
+	for _, st := range table {
+		d := new(mockDB)
+		a := app{router: mux.NewRouter(), db: d}
+
+		if err := a.fromArgs(append(st.args, "-no-auth")); err != nil {
+			t.Fatal(err)
+		}
+
+		d.preload()
+		a.addRoutes()
+
+		r := httptest.NewRequest("GET", "http://who-cares/skus/1000", nil)
+		w := httptest.NewRecorder()
+
+		a.router.ServeHTTP(w, r)
+
+		var raw map[string]interface{}
+
+		if err := json.NewDecoder(w.Body).Decode(&raw); err != nil {
+			t.Fatal(err)
+		}
+
+		if raw["sku"] != st.sku {
+			t.Errorf("%v: invalid sku: %#v", st.args, raw["sku"])
+		}
+
+		r = httptest.NewRequest("GET", "http://who-cares/items", nil)
+		w = httptest.NewRecorder()
+
+		a.router.ServeHTTP(w, r)
+
+		var list []map[string]interface{}
+
+		if err := json.NewDecoder(w.Body).Decode(&list); err != nil || len(list) == 0 {
+			t.Fatalf("%v: invalid list: %v", st.args, err)
+		}
+
+		if reflect.TypeOf(list[0]["sku"]) != reflect.TypeOf(st.sku) {
+			t.Errorf("%v: invalid sku in list: %#v", st.args, list[0]["sku"])
+		}
+
+		id := raw["id"].(string)
+
+		for _, body := range []string{`{"name":"a","sku":1000}`, `{"name":"b","sku":"1000"}`} {
+			r = httptest.NewRequest("PUT", "http://who-cares/items/"+id, strings.NewReader(body))
+			w = httptest.NewRecorder()
+
+			a.router.ServeHTTP(w, r)
+
+			if w.Code != http.StatusOK || d.data[id].Sku != 1000 {
+				t.Errorf("%v %s: invalid response: %d %#v", st.args, body, w.Code, d.data[id])
+			}
+
+			r = httptest.NewRequest("PATCH", "http://who-cares/items/"+id, strings.NewReader(body))
+			r.Header.Set("Content-Type", mergePatchType)
+			w = httptest.NewRecorder()
+
+			a.router.ServeHTTP(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Errorf("%v %s: invalid patch response: %d %s", st.args, body, w.Code, w.Body)
+			}
+		}
+
+		r = httptest.NewRequest("PUT", "http://who-cares/items/"+id, strings.NewReader(`{"name":"c","sku":"x"}`))
+		w = httptest.NewRecorder()
+
+		a.router.ServeHTTP(w, r)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%v: bad sku: invalid response: %d", st.args, w.Code)
+		}
+	}
+}
+
+// TestMaxURIWithMocks refuses an overlong query, even for
+// a path we don't serve
+func TestMaxURIWithMocks(t *testing.T) {
+	d := new(mockDB)
+	a := app{router: mux.NewRouter(), db: d}
+
+	if err := a.fromArgs([]string{"-no-auth", "-max-uri", "100"}); err != nil {
+		t.Fatal(err)
+	}
+
+	d.preload()
+	a.addRoutes()
+
+	long := "?tag=" + strings.Repeat("x", 100)
+
+	table := []struct {
+		path   string
+		status int
+	}{
+		{"/items", http.StatusOK},
+		{"/items?tag=x", http.StatusOK},
+		{"/items" + long, http.StatusRequestURITooLong},
+		{"/nowhere" + long, http.StatusRequestURITooLong},
+	}
+
+	for _, st := range table {
+		r := httptest.NewRequest("GET", "http://who-cares"+st.path, nil)
+		w := httptest.NewRecorder()
+
+		a.handler().ServeHTTP(w, r)
+
+		if w.Code != st.status {
+			t.Errorf("%s: invalid response: %d", st.path, w.Code)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package tutor4
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+)
+
+type connKey struct{}
+
+// withConn keeps each request's connection in its context,
+// so streaming can change its deadlines
+func withConn(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, c)
+}
+
+// streaming gives a request -stream-timeout to finish, in
+// place of the server's read and write timeouts, which are
+// set for quick requests; it's what http.ResponseController
+// does in newer Go, on the connection withConn kept
+//
+// the connection's deadlines are reset for the next request
+// on it, so they only last as long as this one
+func (a *app) streaming(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var deadline time.Time
+
+		ctx := r.Context()
+
+		if a.streamTimeout > 0 {
+			var cancel context.CancelFunc
+
+			deadline = time.Now().Add(a.streamTimeout)
+			ctx, cancel = context.WithDeadline(ctx, deadline)
+
+			defer cancel()
+		}
+
+		if c, ok := ctx.Value(connKey{}).(net.Conn); ok {
+			_ = c.SetReadDeadline(deadline)
+			_ = c.SetWriteDeadline(deadline)
+		}
+
+		next(w, r.WithContext(ctx))
+	}
+}
@@ -0,0 +1,70 @@
+package tutor4
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// deadlineConn records the deadlines set on it
+type deadlineConn struct {
+	net.Conn
+	read, write time.Time
+}
+
+func (c *deadlineConn) SetReadDeadline(t time.Time) error {
+	c.read = t
+	return nil
+}
+
+func (c *deadlineConn) SetWriteDeadline(t time.Time) error {
+	c.write = t
+	return nil
+}
+
+// TestStreaming moves the connection's deadlines out to
+// -stream-timeout, or clears them with none
+func TestStreaming(t *testing.T) {
+	table := []struct {
+		timeout time.Duration
+		limited bool
+	}{
+		{time.Hour, true},
+		{0, false},
+	}
+
+	for _, st := range table {
+		a := app{streamTimeout: st.timeout}
+		c := &deadlineConn{read: time.Now(), write: time.Now()}
+
+		var limited bool
+
+		h := a.streaming(func(w http.ResponseWriter, r *http.Request) {
+			_, limited = r.Context().Deadline()
+		})
+
+		r := httptest.NewRequest("GET", "http://who-cares/admin/backup", nil)
+		r = r.WithContext(withConn(context.Background(), c))
+
+		h(httptest.NewRecorder(), r)
+
+		if limited != st.limited {
+			t.Errorf("%v: wanted a deadline %t, got %t", st.timeout, st.limited, limited)
+		}
+
+		if !st.limited {
+			if !c.read.IsZero() || !c.write.IsZero() {
+				t.Errorf("%v: deadlines not cleared: %v, %v", st.timeout, c.read, c.write)
+			}
+
+			continue
+		}
+
+		if c.read.Before(time.Now().Add(st.timeout-time.Minute)) || !c.write.Equal(c.read) {
+			t.Errorf("%v: invalid deadlines: %v, %v", st.timeout, c.read, c.write)
+		}
+	}
+}
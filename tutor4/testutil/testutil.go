@@ -0,0 +1,124 @@
+// Package testutil runs the service against the in-memory
+// backend so handlers can be tested end to end without
+// Firestore
+package testutil
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+
+	"tutor4"
+	"tutor4/db"
+	"tutor4/graph/model"
+)
+
+// the credentials basic auth accepts
+const (
+	User     = "admin"
+	Password = "secret"
+)
+
+type config struct {
+	auth  bool
+	args  []string
+	names []string
+}
+
+// Option changes how NewTestServer sets up the service
+type Option func(*config)
+
+// WithAuth leaves basic auth on (it's off by default)
+func WithAuth() Option {
+	return func(c *config) { c.auth = true }
+}
+
+// WithArgs passes extra command-line flags to the service
+func WithArgs(args ...string) Option {
+	return func(c *config) { c.args = append(c.args, args...) }
+}
+
+// WithItems seeds items with the given names before the
+// server starts
+func WithItems(names ...string) Option {
+	return func(c *config) { c.names = append(c.names, names...) }
+}
+
+// Server is a running test server and the memory DB
+// behind it; call Close when done
+type Server struct {
+	*httptest.Server
+	DB *db.Memory
+}
+
+// NewTestServer starts the service on a local port; it
+// panics if the options are invalid, like httptest does
+func NewTestServer(opts ...Option) *Server {
+	var c config
+
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	// the backend is ours, but the flag says what it is
+	// (e.g. for /admin/backend)
+
+	args := append([]string{"-backend", "memory"}, c.args...)
+
+	if !c.auth {
+		args = append(args, "-no-auth")
+	}
+
+	m := db.NewMemory()
+	h, err := tutor4.NewHandler(m, args)
+
+	if err != nil {
+		panic("testutil: " + err.Error())
+	}
+
+	s := &Server{Server: httptest.NewServer(h), DB: m}
+
+	if _, err := s.Seed(c.names...); err != nil {
+		s.Close()
+		panic("testutil: " + err.Error())
+	}
+
+	return s
+}
+
+// Seed adds items with the given names directly to the
+// DB and returns them with their IDs and SKUs filled in
+func (s *Server) Seed(names ...string) ([]*model.Item, error) {
+	items := make([]*model.Item, 0, len(names))
+
+	for _, n := range names {
+		i := &model.Item{Name: n}
+
+		if _, err := s.DB.AddItem(context.Background(), i); err != nil {
+			return nil, err
+		}
+
+		items = append(items, i)
+	}
+
+	return items, nil
+}
+
+// Do makes an authenticated request to the server; the
+// path is relative to its URL and body may be nil
+func (s *Server) Do(method, path string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, s.URL+path, body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	req.SetBasicAuth(User, Password)
+
+	return s.Client().Do(req)
+}
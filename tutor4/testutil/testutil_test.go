@@ -0,0 +1,470 @@
+package testutil
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"tutor4/db"
+	"tutor4/graph/model"
+)
+
+// TestNewTestServer seeds items and reads them back
+func TestNewTestServer(t *testing.T) {
+	s := NewTestServer(WithItems("apple", "banana"))
+	defer s.Close()
+
+	resp, err := http.Get(s.URL + "/items")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	var items []*model.Item
+
+	if err = json.NewDecoder(resp.Body).Decode(&items); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(items) != 2 {
+		t.Errorf("wanted 2 items, got %d", len(items))
+	}
+
+	resp, err = s.Do("POST", "/items", strings.NewReader(`{"name":"cherry"}`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("add: wanted 201, got %d", resp.StatusCode)
+	}
+}
+
+// TestWithAuth checks credentials are required and that
+// Do supplies them
+func TestWithAuth(t *testing.T) {
+	s := NewTestServer(WithAuth())
+	defer s.Close()
+
+	resp, err := http.Get(s.URL + "/items")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("no auth: wanted 401, got %d", resp.StatusCode)
+	}
+
+	resp, err = s.Do("GET", "/items", nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("auth: wanted 200, got %d", resp.StatusCode)
+	}
+}
+
+// TestSKUScheme passes -id-scheme through to the backend
+func TestSKUScheme(t *testing.T) {
+	s := NewTestServer(WithArgs("-id-scheme", "sku"), WithItems("apple"))
+	defer s.Close()
+
+	resp, err := s.Do("GET", "/skus/1000", nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	var item model.Item
+
+	if err = json.NewDecoder(resp.Body).Decode(&item); err != nil {
+		t.Fatal(err)
+	}
+
+	if item.ID != "item-001000" || item.Name != "apple" {
+		t.Errorf("invalid item: %#v", item)
+	}
+}
+
+// TestSKUSchemeClientID won't let a client take an ID the
+// SKU scheme will want later
+func TestSKUSchemeClientID(t *testing.T) {
+	s := NewTestServer(WithArgs("-id-scheme", "sku"), WithItems("apple"))
+	defer s.Close()
+
+	put := func(id string) int {
+		r, err := http.NewRequest("PUT", s.URL+"/items/"+id, strings.NewReader(`{"name":"mine"}`))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("If-None-Match", "*")
+		resp, err := http.DefaultClient.Do(r)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// had it been taken, it would have got SKU 1001, and
+	// cherry, the item with SKU 1002, couldn't be added
+
+	if code := put("item-001002"); code != http.StatusBadRequest {
+		t.Errorf("item-001002: wanted 400, got %d", code)
+	}
+
+	items, err := s.Seed("banana", "cherry")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if items[1].ID != "item-001002" {
+		t.Errorf("invalid items: %v", items)
+	}
+
+	// IDs of any other form are still the client's choice
+
+	if code := put("mine"); code != http.StatusCreated {
+		t.Errorf("mine: wanted 201, got %d", code)
+	}
+}
+
+// TestListByName lists mixed-case names case-insensitively
+// and reindexes through the admin endpoint
+func TestListByName(t *testing.T) {
+	s := NewTestServer(WithArgs("-list-order", "name"), WithItems("banana", "Zebra", "apple", "Cherry"))
+	defer s.Close()
+
+	resp, err := s.Do("GET", "/items", nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	var items []*model.Item
+
+	if err = json.NewDecoder(resp.Body).Decode(&items); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"apple", "banana", "Cherry", "Zebra"}
+
+	if len(items) != len(want) {
+		t.Fatalf("wanted %d items, got %d", len(want), len(items))
+	}
+
+	for n, i := range items {
+		if i.Name != want[n] {
+			t.Errorf("%d: wanted %s, got %s", n, want[n], i.Name)
+		}
+	}
+
+	resp, err = s.Do("POST", "/admin/reindex", nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	var result struct {
+		Updated int `json:"updated"`
+	}
+
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusOK || result.Updated != 0 {
+		t.Errorf("reindex: got %d, %#v", resp.StatusCode, result)
+	}
+}
+
+// TestBackupRestore restores a backup into an empty server
+// and checks the items are as they were, and new ones get
+// SKUs after them
+func TestBackupRestore(t *testing.T) {
+	from := NewTestServer(WithItems("apple", "banana", "cherry"))
+	defer from.Close()
+
+	if _, err := from.DB.AddItem(context.Background(), &model.Item{Name: "tagged", Tags: []string{"fruit"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := from.Do("GET", "/admin/backup", nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backup, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/gzip" {
+		t.Fatalf("backup: got %d, %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	if cd := resp.Header.Get("Content-Disposition"); !strings.HasPrefix(cd, "attachment; ") {
+		t.Errorf("invalid disposition: %q", cd)
+	}
+
+	to := NewTestServer()
+	defer to.Close()
+
+	resp, err = to.Do("POST", "/admin/restore", bytes.NewReader(backup))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var result struct {
+		Restored int  `json:"restored"`
+		SKUFixed bool `json:"skuFixed"`
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusOK || result.Restored != 4 || !result.SKUFixed {
+		t.Fatalf("restore: got %d, %#v", resp.StatusCode, result)
+	}
+
+	ctx := context.Background()
+	want, _ := from.DB.ListItems(ctx)
+	got, _ := to.DB.ListItems(ctx)
+
+	if len(got) != len(want) {
+		t.Fatalf("wanted %d items, got %d", len(want), len(got))
+	}
+
+	for n := range want {
+		w, g := *want[n], *got[n]
+
+		// the change number is the new DB's own
+
+		w.Seq, g.Seq = 0, 0
+
+		if !reflect.DeepEqual(w, g) {
+			t.Errorf("%d: wanted %#v, got %#v", n, w, g)
+		}
+	}
+
+	next := &model.Item{Name: "date"}
+
+	if _, err = to.DB.AddItem(ctx, next); err != nil {
+		t.Fatal(err)
+	}
+
+	if next.Sku != 1004 {
+		t.Errorf("wanted SKU 1004 after restore, got %d", next.Sku)
+	}
+}
+
+// TestBackupRestoreAuth backs up and restores as an editor
+// with auth on, and the secret fields must go both ways
+func TestBackupRestoreAuth(t *testing.T) {
+	from := NewTestServer(WithAuth())
+	defer from.Close()
+
+	ctx := context.Background()
+
+	if _, err := from.DB.AddItem(ctx, &model.Item{Name: "costly", SupplierCost: "4.20"}); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := from.Do("GET", "/admin/backup", nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backup, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("backup: got %d, %v", resp.StatusCode, err)
+	}
+
+	to := NewTestServer(WithAuth())
+	defer to.Close()
+
+	resp, err = to.Do("POST", "/admin/restore", bytes.NewReader(backup))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("restore: got %d", resp.StatusCode)
+	}
+
+	items, _ := to.DB.ListItems(ctx)
+
+	if len(items) != 1 || items[0].SupplierCost != "4.20" {
+		t.Errorf("invalid restore: %v", items)
+	}
+
+	// and without credentials there's no backup at all
+
+	resp, err = http.Get(from.URL + "/admin/backup")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("backup without auth: got %d", resp.StatusCode)
+	}
+}
+
+// TestResources serves items and offers from their own
+// collections, each with its own SKUs
+func TestResources(t *testing.T) {
+	s := NewTestServer(WithArgs("-resources", "items:items,offers:offers"), WithItems("apple"))
+	defer s.Close()
+
+	resp, err := s.Do("POST", "/offers", strings.NewReader(`{"name":"half price"}`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var offer model.Item
+
+	err = json.NewDecoder(resp.Body).Decode(&offer)
+	resp.Body.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusCreated || offer.Sku != 1000 {
+		t.Fatalf("add offer: got %d, %#v", resp.StatusCode, offer)
+	}
+
+	if loc := resp.Header.Get("Location"); !strings.HasSuffix(loc, "/offers/"+offer.ID) {
+		t.Errorf("invalid location: %s", loc)
+	}
+
+	for path, want := range map[string]string{"/items": "apple", "/offers": "half price"} {
+		resp, err = s.Do("GET", path, nil)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var items []*model.Item
+
+		err = json.NewDecoder(resp.Body).Decode(&items)
+		resp.Body.Close()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(items) != 1 || items[0].Name != want {
+			t.Errorf("%s: wanted just %s, got %v", path, want, items)
+		}
+	}
+
+	// an offer isn't an item
+
+	resp, err = s.Do("GET", "/items/"+offer.ID, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("offer as item: wanted 404, got %d", resp.StatusCode)
+	}
+}
+
+// TestSKUStats counts the SKUs new items and a reservation
+// take
+func TestSKUStats(t *testing.T) {
+	s := NewTestServer(WithItems("apple", "banana"))
+	defer s.Close()
+
+	stats := func() (result db.SKUStats) {
+		resp, err := s.Do("GET", "/stats/sku", nil)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer resp.Body.Close()
+
+		if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+
+		return result
+	}
+
+	if got := stats(); got.Next != 1002 || got.AllocatedSinceStart != 2 || got.Remaining != db.MaxSKU-1001 {
+		t.Errorf("after seeding: %#v", got)
+	}
+
+	for _, body := range []string{`{"name":"cherry"}`, `{"name":"date"}`} {
+		resp, err := s.Do("POST", "/items", strings.NewReader(body))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp.Body.Close()
+	}
+
+	resp, err := s.Do("POST", "/skus/reserve", strings.NewReader(`{"count":10}`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp.Body.Close()
+
+	if got := stats(); got.Next != 1014 || got.AllocatedSinceStart != 14 || got.Remaining != db.MaxSKU-1013 {
+		t.Errorf("after adding: %#v", got)
+	}
+}
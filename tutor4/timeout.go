@@ -0,0 +1,161 @@
+package tutor4
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// timeout gives each request d to finish before it's cut
+// off with a JSON 504; zero means no limit
+//
+// it works like http.TimeoutHandler, which we can't use
+// because it sends its own plain text body: the handler
+// runs with a deadline on its context and writes to a
+// buffer, which we send if it finishes in time
+func timeout(d time.Duration) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		if d <= 0 {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+
+			tw := timeoutWriter{header: http.Header{}}
+			done := make(chan struct{})
+			panicked := make(chan interface{}, 1)
+
+			go func() {
+				defer func() {
+					if p := recover(); p != nil {
+						panicked <- p
+					}
+				}()
+
+				next.ServeHTTP(&tw, r.WithContext(ctx))
+				close(done)
+			}()
+
+			select {
+			case p := <-panicked:
+				panic(p)
+
+			case <-done:
+				tw.flush(w)
+
+			case <-ctx.Done():
+				tw.stop()
+
+				// if the client went away there's no one
+				// to tell
+
+				if ctx.Err() == context.DeadlineExceeded {
+					timedOut(w, r)
+				}
+			}
+		})
+	}
+}
+
+// timedOut is the 504 for a request that ran out of time;
+// it has the request ID, if the proxy in front sent one,
+// to match it up with the proxy's logs
+func timedOut(w http.ResponseWriter, r *http.Request) {
+	type timeoutError struct {
+		apiError
+		RequestID string `json:"requestId,omitempty"`
+	}
+
+	body := struct {
+		Error timeoutError `json:"error"`
+	}{timeoutError{apiError{Code: "timeout", Message: "request timed out"}, r.Header.Get("X-Request-ID")}}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusGatewayTimeout)
+
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// timeoutWriter holds what the handler writes until we
+// know it finished in time; after that, writes fail
+type timeoutWriter struct {
+	mu      sync.Mutex
+	header  http.Header
+	status  int
+	body    bytes.Buffer
+	stopped bool
+}
+
+func (t *timeoutWriter) Header() http.Header {
+	return t.header
+}
+
+func (t *timeoutWriter) WriteHeader(code int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.status == 0 && !t.stopped {
+		t.status = code
+	}
+}
+
+func (t *timeoutWriter) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.stopped {
+		return 0, http.ErrHandlerTimeout
+	}
+
+	if t.status == 0 {
+		t.status = http.StatusOK
+	}
+
+	return t.body.Write(p)
+}
+
+// stop makes later writes fail, once we've given up
+func (t *timeoutWriter) stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.stopped = true
+}
+
+// copyHeader sets the handler's headers on the real
+// response; Vary is added to, since middleware outside
+// (e.g. CORS) may have set its own
+func copyHeader(dst, src http.Header) {
+	for k, v := range src {
+		if k == "Vary" {
+			dst[k] = append(dst[k], v...)
+			continue
+		}
+
+		dst[k] = v
+	}
+}
+
+// flush sends what the handler wrote, once it's done
+func (t *timeoutWriter) flush(w http.ResponseWriter) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	copyHeader(w.Header(), t.header)
+
+	if t.status == 0 {
+		t.status = http.StatusOK
+	}
+
+	w.WriteHeader(t.status)
+
+	_, _ = w.Write(t.body.Bytes())
+}
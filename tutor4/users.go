@@ -0,0 +1,112 @@
+package tutor4
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"tutor4/db"
+)
+
+const (
+	roleReader = "reader"
+	roleEditor = "editor"
+)
+
+// user is an entry in the users file, which maps names
+// to users:
+//
+//	{"alice": {"passwordHash": "$2a$10$...", "role": "editor"}}
+type user struct {
+	PasswordHash string `json:"passwordHash"`
+	Role         string `json:"role"`
+}
+
+func loadUsers(file string) (map[string]user, error) {
+	data, err := ioutil.ReadFile(file)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var users map[string]user
+
+	if err = json.Unmarshal(data, &users); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+
+	for name, u := range users {
+		if u.Role != roleReader && u.Role != roleEditor {
+			return nil, fmt.Errorf("%s: user %s has invalid role %q", file, name, u.Role)
+		}
+
+		if _, err = bcrypt.Cost([]byte(u.PasswordHash)); err != nil {
+			return nil, fmt.Errorf("%s: user %s: %w", file, name, err)
+		}
+	}
+
+	return users, nil
+}
+
+// authenticate returns the user's role if the password is
+// right; without a users file there's just admin/secret,
+// who can do anything
+func (a *app) authenticate(name, pass string) (string, bool) {
+	if a.users == nil {
+		return roleEditor, name == "admin" && pass == "secret"
+	}
+
+	u, ok := a.users[name]
+
+	if !ok {
+		return "", false
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(pass)) != nil {
+		return "", false
+	}
+
+	return u.Role, true
+}
+
+// editor only lets editors through to a handler that
+// changes data; with auth off, everyone is an editor
+func (a *app) editor(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !a.canWrite(r.Context()) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// secrets lets editors read secret item fields, which
+// everyone else gets empty; so the body depends on who
+// asks, and a cache must keep them apart
+func (a *app) secrets(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Authorization")
+
+		if a.canWrite(r.Context()) {
+			r = r.WithContext(db.WithSecrets(r.Context()))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *app) canWrite(ctx context.Context) bool {
+	if a.noAuth {
+		return true
+	}
+
+	c := callerFrom(ctx)
+
+	return c != nil && c.role == roleEditor
+}
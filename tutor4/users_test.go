@@ -0,0 +1,172 @@
+package tutor4
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// TestRolesWithMocks lets a reader read but not write, and
+// an editor do both
+func TestRolesWithMocks(t *testing.T) {
+	file := usersFile(t)
+
+	defer os.Remove(file)
+
+	d := new(mockDB)
+	a := app{router: mux.NewRouter(), db: d}
+
+	if err := a.fromArgs([]string{"-users", file}); err != nil {
+		t.Fatal(err)
+	}
+
+	d.preload()
+	a.addRoutes()
+
+	table := []struct {
+		user, pass string
+		method     string
+		url, body  string
+		status     int
+	}{
+		{"rita", "rita-pw", "GET", "/items", "", http.StatusOK},
+		{"rita", "rita-pw", "POST", "/items", `{"name":"x"}`, http.StatusForbidden},
+		{"ed", "ed-pw", "GET", "/items", "", http.StatusOK},
+		{"ed", "ed-pw", "POST", "/items", `{"name":"x"}`, http.StatusCreated},
+		{"ed", "rita-pw", "GET", "/items", "", http.StatusUnauthorized},
+		{"admin", "secret", "GET", "/items", "", http.StatusUnauthorized},
+	}
+
+	for _, tt := range table {
+		r := httptest.NewRequest(tt.method, "http://who-cares"+tt.url, strings.NewReader(tt.body))
+		w := httptest.NewRecorder()
+
+		r.SetBasicAuth(tt.user, tt.pass)
+		a.router.ServeHTTP(w, r)
+
+		if resp := w.Result(); resp.StatusCode != tt.status {
+			t.Errorf("%s %s %s: invalid response: %d", tt.user, tt.method, tt.url, resp.StatusCode)
+		}
+	}
+
+	// GraphQL queries come by POST too, so only the
+	// mutation is refused
+
+	for query, ok := range map[string]bool{
+		`{"query":"{items {name}}"}`:                                   true,
+		`{"query":"mutation {createItem(input: {name: \"x\"}) {id}}"}`: false,
+	} {
+		r := httptest.NewRequest("POST", "http://who-cares/graphql", strings.NewReader(query))
+		w := httptest.NewRecorder()
+
+		r.Header.Set("Content-Type", "application/json")
+		r.SetBasicAuth("rita", "rita-pw")
+		a.router.ServeHTTP(w, r)
+
+		body, _ := ioutil.ReadAll(w.Result().Body)
+
+		if strings.Contains(string(body), "forbidden") == ok {
+			t.Errorf("%s: invalid result: %s", query, body)
+		}
+	}
+}
+
+// TestSecretsETagWithMocks gives an editor, who sees
+// secret fields, a different list ETag from a reader
+func TestSecretsETagWithMocks(t *testing.T) {
+	file := usersFile(t)
+
+	defer os.Remove(file)
+
+	d := new(mockDB)
+	a := app{router: mux.NewRouter(), db: d}
+
+	if err := a.fromArgs([]string{"-users", file, "-enc-key", "MDEyMzQ1Njc4OWFiY2RlZg=="}); err != nil {
+		t.Fatal(err)
+	}
+
+	d.preload()
+	a.addRoutes()
+
+	etags := map[string]string{}
+
+	for _, name := range []string{"rita", "ed"} {
+		r := httptest.NewRequest("GET", "http://who-cares/items", nil)
+		w := httptest.NewRecorder()
+
+		r.SetBasicAuth(name, name+"-pw")
+		a.router.ServeHTTP(w, r)
+
+		resp := w.Result()
+
+		if !strings.Contains(strings.Join(resp.Header["Vary"], ","), "Authorization") {
+			t.Errorf("%s: no Vary: Authorization: %v", name, resp.Header["Vary"])
+		}
+
+		etags[name] = resp.Header.Get("ETag")
+	}
+
+	if etags["rita"] == "" || etags["rita"] == etags["ed"] {
+		t.Errorf("invalid ETags: %v", etags)
+	}
+}
+
+// usersFile writes a users file with a reader, rita, and
+// an editor, ed, each with the password <name>-pw
+func usersFile(t *testing.T) string {
+	users := make(map[string]user)
+
+	for name, role := range map[string]string{"rita": roleReader, "ed": roleEditor} {
+		hash, err := bcrypt.GenerateFromPassword([]byte(name+"-pw"), bcrypt.MinCost)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		users[name] = user{PasswordHash: string(hash), Role: role}
+	}
+
+	data, _ := json.Marshal(users)
+
+	return writeTemp(t, data)
+}
+
+// TestLoadUsers rejects unknown roles and bad hashes
+func TestLoadUsers(t *testing.T) {
+	for _, data := range []string{
+		`{"x": {"passwordHash": "$2a$04$abcdefghijklmnopqrstuuJ3p6Kx3lGm1fM1Ud8hXwD9fFq3Cw0b6", "role": "admin"}}`,
+		`{"x": {"passwordHash": "plaintext", "role": "reader"}}`,
+		`{"x": `,
+	} {
+		file := writeTemp(t, []byte(data))
+
+		if _, err := loadUsers(file); err == nil {
+			t.Errorf("%s: no error", data)
+		}
+
+		os.Remove(file)
+	}
+}
+
+func writeTemp(t *testing.T, data []byte) string {
+	f, err := ioutil.TempFile("", "users-*.json")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	if _, err = f.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	return f.Name()
+}
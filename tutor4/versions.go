@@ -0,0 +1,215 @@
+package tutor4
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// apiBehavior is how a version of the REST API differs
+// from the latest one
+type apiBehavior struct {
+	// newFields are item fields added since; they're left
+	// out of any item in a response
+	newFields []string
+
+	// plainErrors sends errors as text, as http.Error does,
+	// not {"error":{...}}
+	plainErrors bool
+}
+
+// latestVersion is what clients get without X-API-Version
+const latestVersion = 2
+
+// apiVersions are the versions we still serve; version 1
+// is the API before items had tags and the like, when
+// errors were plain text
+var apiVersions = map[int]apiBehavior{
+	1: {
+		newFields:   []string{"tags", "externalKey", "seq", "supplierCost", skuDisplay},
+		plainErrors: true,
+	},
+	latestVersion: {},
+}
+
+type versionKey struct{}
+
+// apiVersion is the version a request asked for (see
+// versioned), or the latest outside the REST API
+func apiVersion(ctx context.Context) int {
+	if v, ok := ctx.Value(versionKey{}).(int); ok {
+		return v
+	}
+
+	return latestVersion
+}
+
+// versioned reads X-API-Version and shapes the response
+// to match what that version promised; an unknown version
+// (or one newer than ours) is rejected
+func versioned(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		version := latestVersion
+
+		if h := r.Header.Get("X-API-Version"); h != "" {
+			v, err := strconv.Atoi(h)
+
+			if _, ok := apiVersions[v]; err != nil || !ok {
+				jsonError(w, http.StatusBadRequest, "invalid_version", fmt.Sprintf("unknown API version %q (1 to %d)", h, latestVersion))
+				return
+			}
+
+			version = v
+		}
+
+		// a cache must keep each version's response apart
+
+		w.Header().Set("X-API-Version", strconv.Itoa(version))
+		w.Header().Add("Vary", "X-API-Version")
+
+		r = r.WithContext(context.WithValue(r.Context(), versionKey{}, version))
+
+		if version == latestVersion {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		bw := bufferWriter{header: http.Header{}}
+
+		next.ServeHTTP(&bw, r)
+
+		bw.reshape(apiVersions[version])
+		bw.flush(w)
+	})
+}
+
+// bufferWriter holds a response so it can be reshaped
+// before it's sent
+type bufferWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferWriter) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferWriter) WriteHeader(code int) {
+	if b.status == 0 {
+		b.status = code
+	}
+}
+
+func (b *bufferWriter) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+
+	return b.body.Write(p)
+}
+
+func (b *bufferWriter) flush(w http.ResponseWriter) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+
+	copyHeader(w.Header(), b.header)
+
+	w.WriteHeader(b.status)
+
+	_, _ = w.Write(b.body.Bytes())
+}
+
+// reshape rewrites a JSON body the way an older version
+// of the API would have sent it; anything else is left
+func (b *bufferWriter) reshape(behavior apiBehavior) {
+	body, ok := b.decode()
+
+	if !ok {
+		return
+	}
+
+	if behavior.plainErrors && b.status >= 400 {
+		var e struct {
+			Error apiError `json:"error"`
+		}
+
+		if err := json.Unmarshal(b.body.Bytes(), &e); err == nil && e.Error.Message != "" {
+			b.header.Set("Content-Type", "text/plain; charset=utf-8")
+			b.body.Reset()
+			b.body.WriteString(e.Error.Message + "\n")
+		}
+
+		return
+	}
+
+	if len(behavior.newFields) == 0 {
+		return
+	}
+
+	dropFields(body, behavior.newFields)
+	b.replace(body)
+}
+
+// decode reads a JSON body, keeping numbers as they were
+// sent; it fails for anything else
+func (b *bufferWriter) decode() (interface{}, bool) {
+	if !strings.HasPrefix(b.header.Get("Content-Type"), "application/json") {
+		return nil, false
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(b.body.Bytes()))
+	dec.UseNumber()
+
+	var body interface{}
+
+	if err := dec.Decode(&body); err != nil {
+		return nil, false
+	}
+
+	return body, true
+}
+
+// replace sets the body to v as JSON, or leaves it if v
+// can't be encoded
+func (b *bufferWriter) replace(v interface{}) {
+	out, err := json.Marshal(v)
+
+	if err != nil {
+		return
+	}
+
+	b.body.Reset()
+	b.body.Write(out)
+	b.body.WriteByte('\n')
+}
+
+// dropFields removes fields from every item in a JSON
+// value, wherever it is (e.g. in a list or an audit entry);
+// an item is any object with an id and a SKU
+func dropFields(v interface{}, fields []string) {
+	switch v := v.(type) {
+	case []interface{}:
+		for _, e := range v {
+			dropFields(e, fields)
+		}
+
+	case map[string]interface{}:
+		_, id := v["id"]
+		_, sku := v["sku"]
+
+		for k, e := range v {
+			if id && sku && hasField(fields, k) {
+				delete(v, k)
+				continue
+			}
+
+			dropFields(e, fields)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package tutor4
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"tutor4/graph/model"
+)
+
+// TestAPIVersionsWithMocks gets the same item as each
+// version and checks its fields and the error shape
+func TestAPIVersionsWithMocks(t *testing.T) {
+	d := new(mockDB)
+	a := app{
+		router: mux.NewRouter(),
+		db:     d,
+		noAuth: true,
+	}
+
+	d.preload()
+	a.addRoutes()
+
+	item := &model.Item{Name: "tv", Tags: []string{"video"}}
+
+	if _, err := d.AddItem(context.Background(), item); err != nil {
+		t.Fatal(err)
+	}
+
+	table := []struct {
+		version string
+		status  int
+		fields  []string
+	}{
+		{"", http.StatusOK, []string{"id", "name", "seq", "sku", "tags"}},
+		{"2", http.StatusOK, []string{"id", "name", "seq", "sku", "tags"}},
+		{"1", http.StatusOK, []string{"id", "name", "sku"}},
+		{"3", http.StatusBadRequest, nil},
+		{"v1", http.StatusBadRequest, nil},
+	}
+
+	for _, st := range table {
+		r := httptest.NewRequest("GET", "http://who-cares/items/"+item.ID, nil)
+		w := httptest.NewRecorder()
+
+		if st.version != "" {
+			r.Header.Set("X-API-Version", st.version)
+		}
+
+		a.router.ServeHTTP(w, r)
+
+		resp := w.Result()
+
+		if resp.StatusCode != st.status {
+			t.Errorf("%q: wanted %d, got %d", st.version, st.status, resp.StatusCode)
+			continue
+		}
+
+		if st.fields == nil {
+			continue
+		}
+
+		var got map[string]interface{}
+
+		if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(got) != len(st.fields) {
+			t.Errorf("%q: wanted %v, got %v", st.version, st.fields, got)
+		}
+
+		for _, f := range st.fields {
+			if _, ok := got[f]; !ok {
+				t.Errorf("%q: no %s in %v", st.version, f, got)
+			}
+		}
+	}
+
+	// version 1 errors were plain text
+
+	for version, want := range map[string]string{
+		"1": "text/plain; charset=utf-8",
+		"2": "application/json",
+	} {
+		r := httptest.NewRequest("GET", "http://who-cares/changes?since=x", nil)
+		w := httptest.NewRecorder()
+
+		r.Header.Set("X-API-Version", version)
+		a.router.ServeHTTP(w, r)
+
+		resp := w.Result()
+		body, _ := ioutil.ReadAll(resp.Body)
+
+		if resp.StatusCode != http.StatusBadRequest || resp.Header.Get("Content-Type") != want {
+			t.Errorf("v%s: got %d, %s: %s", version, resp.StatusCode, resp.Header.Get("Content-Type"), body)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package tutor4
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"tutor4/graph/model"
+)
+
+const (
+	webhookTries   = 3
+	webhookBackoff = 500 * time.Millisecond
+	webhookTimeout = 5 * time.Second
+)
+
+var webhookClient = http.Client{Timeout: webhookTimeout}
+
+// notify posts a newly-created item to the webhook (if
+// there is one) in the background, so the client isn't
+// kept waiting; shutdown cancels it and waits (for as
+// long as shutdown may take) for it to finish
+func (a *app) notify(item *model.Item) {
+	if a.webhook == "" {
+		return
+	}
+
+	body, err := json.Marshal(item)
+
+	if err != nil {
+		log.Printf("webhook %s: %s", item.ID, err)
+		return
+	}
+
+	ok := a.goBG(func(ctx context.Context) {
+		for try := 1; ; try++ {
+			err := a.postWebhook(ctx, body)
+
+			if err == nil {
+				return
+			}
+
+			if try == webhookTries || ctx.Err() != nil {
+				log.Printf("webhook %s: giving up: %s", item.ID, err)
+				return
+			}
+
+			select {
+			case <-time.After(time.Duration(try) * webhookBackoff):
+			case <-ctx.Done():
+				log.Printf("webhook %s: giving up: %s", item.ID, ctx.Err())
+				return
+			}
+		}
+	})
+
+	if !ok {
+		log.Printf("webhook %s: not sent, shutting down", item.ID)
+	}
+}
+
+func (a *app) postWebhook(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", a.webhook, bytes.NewReader(body))
+
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := webhookClient.Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	return nil
+}